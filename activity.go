@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// activityStore remembers when each function was last seen active, so that a
+// zero rate over a window Prometheus has not been collecting for, i.e. after
+// it restarted, is not taken for idleness
+type activityStore struct {
+	mu   sync.Mutex
+	last *lruCache
+}
+
+func newActivityStore(maxEntries int, ttl time.Duration) *activityStore {
+	return &activityStore{
+		last: newLRUCache(maxEntries, ttl),
+	}
+}
+
+// record notes a function as active at the given time
+func (a *activityStore) record(name string, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.last.set(name, now)
+}
+
+func (a *activityStore) lastActive(name string) (time.Time, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	value, exists := a.last.get(name)
+	if !exists {
+		return time.Time{}, false
+	}
+	return value.(time.Time), true
+}
+
+// sortLeastRecentlyActive orders functions by when they were last seen
+// active, the oldest first, functions never seen active leading in their
+// original order
+func (a *activityStore) sortLeastRecentlyActive(functions []Function) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	last := make(map[string]time.Time, len(functions))
+	for _, fn := range functions {
+		if value, exists := a.last.get(fn.Name); exists {
+			last[fn.Name] = value.(time.Time)
+		}
+	}
+
+	sort.SliceStable(functions, func(i, j int) bool {
+		return last[functions[i].Name].Before(last[functions[j].Name])
+	})
+}
+
+// bridge reports whether a zero rate over window must not be trusted: when
+// Prometheus has been up for less than the window, a function is only taken
+// for idle once it is known to have been inactive for all of it. An unknown
+// uptime of zero trusts the rate.
+func (a *activityStore) bridge(name string, window time.Duration, uptime time.Duration, now time.Time) bool {
+	if uptime <= 0 || uptime >= window {
+		return false
+	}
+
+	last, seen := a.lastActive(name)
+	return !seen || now.Sub(last) < window
+}
+
+// inactiveFor reports how long a function has been inactive and whether that
+// is at least duration. A function not seen active yet is noted as active
+// now, so that it is only taken for idle once it stayed inactive for all of
+// duration under the idler's watch.
+func (a *activityStore) inactiveFor(name string, duration time.Duration, now time.Time) (time.Duration, bool) {
+	last, seen := a.lastActive(name)
+	if !seen {
+		a.record(name, now)
+		return 0, false
+	}
+
+	since := now.Sub(last)
+	return since, since >= duration
+}
+
+// retain drops functions which were not listed by the gateway in the last
+// reconcile pass
+func (a *activityStore) retain(names map[string]bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.last.retain(names)
+}
+
+func (a *activityStore) snapshot() map[string]time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	snapshot := make(map[string]time.Time, a.last.len())
+	a.last.each(func(key string, value interface{}) {
+		snapshot[key] = value.(time.Time)
+	})
+	return snapshot
+}
+
+// restore loads a snapshot, keeping the most recently active functions
+// should it hold more than the store does
+func (a *activityStore) restore(snapshot map[string]time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return snapshot[names[i]].Before(snapshot[names[j]])
+	})
+
+	for _, name := range names {
+		a.last.set(name, snapshot[name])
+	}
+}
+
+// activitySnapshot is the file written to activity_snapshot_file, the last
+// activity of each function by gateway name
+type activitySnapshot map[string]map[string]time.Time
+
+// saveActivity writes the activity of every gateway, replacing the file only
+// once it was written in full
+func saveActivity(path string, gateways []*Gateway) error {
+	snapshot := activitySnapshot{}
+	for _, gateway := range gateways {
+		snapshot[gateway.Name] = gateway.activity.snapshot()
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadActivity restores the activity of each gateway from the file, which
+// may not exist yet
+func loadActivity(path string, gateways []*Gateway) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	snapshot := activitySnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	for _, gateway := range gateways {
+		gateway.activity.restore(snapshot[gateway.Name])
+	}
+	return nil
+}
+
+// runActivitySnapshots saves the activity of every gateway at each interval
+func runActivitySnapshots(path string, interval time.Duration, gateways []*Gateway) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := saveActivity(path, gateways); err != nil {
+			log.Printf("Unable to save activity snapshot to %s: %s\n", path, err)
+		}
+	}
+}
+
+// prometheusUptime returns how long Prometheus has been collecting, zero when
+// prometheus_uptime_query is empty or returned nothing
+func prometheusUptime(ctx context.Context, gateway *Gateway) time.Duration {
+	return queryDuration(ctx, gateway, gateway.Config.PrometheusUptimeQuery, "uptime")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+func Test_activityStore_bridge(t *testing.T) {
+	activity := newActivityStore(0, 0)
+	now := time.Now()
+	window := time.Minute * 5
+
+	activity.record("figlet", now.Add(-time.Minute))
+	activity.record("nodeinfo", now.Add(-time.Minute*10))
+
+	cases := []struct {
+		name     string
+		function string
+		uptime   time.Duration
+		want     bool
+	}{
+		{"unknown uptime trusts the rate", "figlet", 0, false},
+		{"full window trusts the rate", "figlet", window, false},
+		{"recently active is bridged", "figlet", time.Minute * 2, true},
+		{"inactive for the window is not bridged", "nodeinfo", time.Minute * 2, false},
+		{"unknown function is bridged", "env", time.Minute * 2, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := activity.bridge(c.function, window, c.uptime, now); got != c.want {
+				t.Errorf("Bridge wanted: %v got: %v", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_activityStore_inactiveFor(t *testing.T) {
+	activity := newActivityStore(0, 0)
+	now := time.Now()
+
+	if _, sustained := activity.inactiveFor("figlet", time.Minute*30, now); sustained {
+		t.Errorf("A function not seen yet should not be taken for idle")
+	}
+	if since, sustained := activity.inactiveFor("figlet", time.Minute*30, now.Add(time.Minute*10)); sustained || since != time.Minute*10 {
+		t.Errorf("Inactive wanted: 10m0s false got: %s %v", since, sustained)
+	}
+	if _, sustained := activity.inactiveFor("figlet", time.Minute*30, now.Add(time.Minute*30)); !sustained {
+		t.Errorf("A function inactive for the duration should be taken for idle")
+	}
+}
+
+func Test_activityStore_sortLeastRecentlyActive(t *testing.T) {
+	activity := newActivityStore(0, 0)
+	now := time.Now()
+	activity.record("figlet", now.Add(-time.Minute))
+	activity.record("echo", now.Add(-time.Hour))
+
+	functions := []Function{
+		{Function: requests.Function{Name: "figlet"}},
+		{Function: requests.Function{Name: "echo"}},
+		{Function: requests.Function{Name: "nodeinfo"}},
+	}
+	activity.sortLeastRecentlyActive(functions)
+
+	names := []string{}
+	for _, fn := range functions {
+		names = append(names, fn.Name)
+	}
+	if names[0] != "nodeinfo" || names[1] != "echo" || names[2] != "figlet" {
+		t.Errorf("Order wanted: [nodeinfo echo figlet] got: %v", names)
+	}
+}
+
+func Test_saveActivity_roundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "activity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "activity.json")
+	active := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	saved := &Gateway{Name: "prod", activity: newActivityStore(0, 0)}
+	saved.activity.record("figlet", active)
+	if err := saveActivity(path, []*Gateway{saved}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &Gateway{Name: "prod", activity: newActivityStore(0, 0)}
+	other := &Gateway{Name: "staging", activity: newActivityStore(0, 0)}
+	if err := loadActivity(path, []*Gateway{loaded, other}); err != nil {
+		t.Fatal(err)
+	}
+
+	if last, seen := loaded.activity.lastActive("figlet"); !seen || !last.Equal(active) {
+		t.Errorf("Last active wanted: %s got: %s %v", active, last, seen)
+	}
+	if _, seen := other.activity.lastActive("figlet"); seen {
+		t.Errorf("Activity should only be restored to its own gateway")
+	}
+
+	if err := loadActivity(filepath.Join(dir, "missing.json"), []*Gateway{loaded}); err != nil {
+		t.Errorf("A missing snapshot should not be an error, got: %s", err)
+	}
+}
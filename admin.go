@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serveAdmin exposes the idler's own metrics and API, it blocks until the
+// server fails
+func serveAdmin(port int, gateways []*Gateway, policySource *gitPolicySource) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", makeDashboardHandler())
+	mux.Handle("/metrics", prometheus.Handler())
+	mux.HandleFunc("/api/recommendations", makeRecommendationsHandler(gateways))
+	mux.HandleFunc("/api/status", makeRecommendationsHandler(gateways))
+	mux.HandleFunc("/api/cycles", makeCyclesHandler(gateways))
+	mux.HandleFunc("/api/forecast", makeForecastHandler(gateways))
+	mux.HandleFunc("/api/policies", makePoliciesHandler(gateways))
+	mux.HandleFunc("/api/tuning", makeTuningHandler(gateways))
+	mux.HandleFunc("/api/replicas", makeReplicasHandler(gateways))
+	mux.HandleFunc("/api/candidates", makeCandidatesHandler(gateways))
+	mux.HandleFunc("/api/policies/sync", makePolicySyncHandler(policySource))
+	mux.HandleFunc("/api/history", makeHistoryHandler())
+	mux.HandleFunc("/api/history/compact", makeAuditCompactHandler())
+	mux.HandleFunc("/api/history/rollups", makeRollupsHandler())
+	mux.HandleFunc("/api/schemas", makeSchemasHandler())
+	mux.HandleFunc("/api/schemas/", makeSchemasHandler())
+
+	log.Printf("Admin server listening on port %d\n", port)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		log.Printf("Admin server stopped: %s\n", err)
+	}
+}
+
+// makeRecommendationsHandler lists the latest recommendation for every
+// function, optionally filtered with ?gateway=
+func makeRecommendationsHandler(gateways []*Gateway) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		filter := r.URL.Query().Get("gateway")
+
+		list := []functionStatus{}
+		for _, gateway := range gateways {
+			if len(filter) > 0 && filter != gateway.Name {
+				continue
+			}
+			list = append(list, gateway.status.list()...)
+		}
+
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// makeTuningHandler lists the inactivity durations learned by auto_tune for
+// every function, optionally filtered with ?gateway=
+func makeTuningHandler(gateways []*Gateway) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		filter := r.URL.Query().Get("gateway")
+
+		list := []tuningStatus{}
+		for _, gateway := range gateways {
+			if len(filter) > 0 && filter != gateway.Name {
+				continue
+			}
+			list = append(list, gateway.tuning.list(gateway.Name)...)
+		}
+
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// makeReplicasHandler lists the replicas idled functions ran before the
+// idler scaled them down, optionally filtered with ?gateway=
+func makeReplicasHandler(gateways []*Gateway) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		filter := r.URL.Query().Get("gateway")
+
+		list := []replicaRecord{}
+		for _, gateway := range gateways {
+			if len(filter) > 0 && filter != gateway.Name {
+				continue
+			}
+			list = append(list, gateway.replicas.list()...)
+		}
+
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// makeCandidatesHandler lists the functions marked idle with
+// confirm_scale_down, optionally filtered with ?gateway=
+func makeCandidatesHandler(gateways []*Gateway) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		filter := r.URL.Query().Get("gateway")
+
+		list := []candidate{}
+		for _, gateway := range gateways {
+			if len(filter) > 0 && filter != gateway.Name {
+				continue
+			}
+			list = append(list, gateway.candidates.list()...)
+		}
+
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// makeCyclesHandler lists the summary of the last reconcile pass of every
+// gateway, optionally filtered with ?gateway=
+func makeCyclesHandler(gateways []*Gateway) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		filter := r.URL.Query().Get("gateway")
+
+		list := []*cycleSummary{}
+		for _, gateway := range gateways {
+			if len(filter) > 0 && filter != gateway.Name {
+				continue
+			}
+			if summary := gateway.status.lastCycle(); summary != nil {
+				list = append(list, summary)
+			}
+		}
+
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	bytesOut, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(bytesOut)
+}
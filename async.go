@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/types"
+)
+
+// asyncProvider adds the rate of asynchronous invocations, processed by the
+// queue-worker without going through the gateway's counters, to the
+// activity reported by the provider it wraps
+type asyncProvider struct {
+	next       MetricsProvider
+	prometheus *prometheusProvider
+}
+
+func newAsyncProvider(next MetricsProvider, config types.Config, client *http.Client) *asyncProvider {
+	return &asyncProvider{
+		next:       next,
+		prometheus: &prometheusProvider{client: client, config: config},
+	}
+}
+
+// asyncActivityQuery renders async_query for a function, or for every
+// function when name is empty, $window is replaced by the window
+func asyncActivityQuery(name string, window time.Duration, config types.Config) string {
+	function := name
+	if len(function) == 0 {
+		function = ".+"
+	}
+	query := strings.Replace(config.AsyncQuery, "$function", function, -1)
+	return strings.Replace(query, "$window", promDuration(window), -1)
+}
+
+func (p *asyncProvider) Activity(ctx context.Context, function string, window time.Duration) (float64, bool, error) {
+	rate, found, err := p.next.Activity(ctx, function, window)
+	if err != nil {
+		return 0, false, err
+	}
+
+	async, err := p.prometheus.rates(ctx, asyncActivityQuery(function, window, p.prometheus.config), function)
+	if err != nil {
+		return 0, false, err
+	}
+	if asyncRate, exists := async[function]; exists {
+		return rate + asyncRate, true, nil
+	}
+	return rate, found, nil
+}
+
+// ActivityBatch is not batched when the provider it wraps does not batch
+func (p *asyncProvider) ActivityBatch(ctx context.Context, functions []string, window time.Duration) (map[string]float64, bool, error) {
+	batcher, ok := p.next.(BatchMetricsProvider)
+	if !ok {
+		return nil, false, nil
+	}
+
+	rates, ok, err := batcher.ActivityBatch(ctx, functions, window)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	async, err := p.prometheus.rates(ctx, asyncActivityQuery("", window, p.prometheus.config), "")
+	if err != nil {
+		return nil, false, err
+	}
+	combined := make(map[string]float64, len(rates))
+	for function, rate := range rates {
+		combined[function] = rate
+	}
+	for _, function := range functions {
+		if asyncRate, exists := async[function]; exists {
+			combined[function] += asyncRate
+		}
+	}
+	return combined, true, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_asyncActivityQuery(t *testing.T) {
+	config := types.Config{AsyncQuery: types.DefaultAsyncQuery}
+
+	want := `sum(rate(queue_worker_messages_processed_total{function_name=~"figlet"}[5m])) by (function_name)`
+	if got := asyncActivityQuery("figlet", time.Minute*5, config); got != want {
+		t.Errorf("Query wanted: %s got: %s", want, got)
+	}
+	want = `sum(rate(queue_worker_messages_processed_total{function_name=~".+"}[5m])) by (function_name)`
+	if got := asyncActivityQuery("", time.Minute*5, config); got != want {
+		t.Errorf("Batch query wanted: %s got: %s", want, got)
+	}
+}
+
+func Test_asyncProvider(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "success", "data": {"resultType": "vector", "result": [
+			{"metric": {"function_name": "nodeinfo"}, "value": [1546300800, "0.25"]},
+			{"metric": {"function_name": "env"}, "value": [1546300800, "0"]}]}}`))
+	}))
+	defer prometheus.Close()
+
+	parsed, _ := url.Parse(prometheus.URL)
+	host, portVal, _ := net.SplitHostPort(parsed.Host)
+	port, _ := strconv.Atoi(portVal)
+	config := types.Config{PrometheusHost: host, PrometheusPort: port, AsyncQuery: types.DefaultAsyncQuery}
+
+	next := &fakeBatchProvider{fakeProvider: fakeProvider{
+		rates:   map[string]float64{"figlet": 0.5, "nodeinfo": 0},
+		windows: map[string]time.Duration{},
+	}}
+	provider := newAsyncProvider(next, config, newHeaderClient("", nil, nil))
+
+	rates, ok, err := provider.ActivityBatch(context.Background(), []string{"figlet", "nodeinfo", "env"}, time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rates) != 3 || rates["figlet"] != 0.5 || rates["nodeinfo"] != 0.25 || rates["env"] != 0 {
+		t.Errorf("Asynchronous invocations should be added, got: %v", rates)
+	}
+	if next.rates["nodeinfo"] != 0 {
+		t.Errorf("The rates of the wrapped provider should not be changed")
+	}
+
+	// Only invoked asynchronously, so unknown to the gateway's counters
+	rate, found, err := provider.Activity(context.Background(), "env", time.Minute)
+	if err != nil || !found || rate != 0 {
+		t.Errorf("Unexpected activity: %f %t %v", rate, found, err)
+	}
+}
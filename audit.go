@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditCompactInterval is how often events older than audit_retention are
+// pruned, the size limit is enforced in the background once a write passes it
+const auditCompactInterval = time.Hour
+
+// audit is set when audit_log_file is, every notification is appended to it
+var audit *auditLog
+
+// auditLog appends notifications to a file as lines of JSON
+type auditLog struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	size      int64
+	retention time.Duration
+	maxSize   int64
+
+	// oversized wakes runAuditCompaction once the log passes maxSize
+	oversized chan struct{}
+}
+
+func openAuditLog(path string, retention time.Duration, maxSize int64) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &auditLog{
+		path:      path,
+		file:      file,
+		size:      info.Size(),
+		retention: retention,
+		maxSize:   maxSize,
+		oversized: make(chan struct{}, 1),
+	}, nil
+}
+
+// write appends a notification, it never compacts the log so that a
+// reconcile pass does not wait on it
+func (a *auditLog) write(notification Notification) {
+	line, err := json.Marshal(notification)
+	if err != nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	written, err := a.file.Write(append(line, '\n'))
+	a.size += int64(written)
+	if err != nil {
+		log.Printf("Unable to write to audit log %s: %s\n", a.path, err)
+	}
+
+	if a.maxSize > 0 && a.size > a.maxSize {
+		select {
+		case a.oversized <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// auditCompaction reports what a compaction pruned
+type auditCompaction struct {
+	EventsBefore int   `json:"events_before"`
+	EventsAfter  int   `json:"events_after"`
+	RolledUp     int   `json:"rolled_up"`
+	BytesBefore  int64 `json:"bytes_before"`
+	BytesAfter   int64 `json:"bytes_after"`
+}
+
+func (a *auditLog) compact(now time.Time) (auditCompaction, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.compactLocked(now)
+}
+
+// rollupPath is where the daily aggregates of the pruned events are kept
+func (a *auditLog) rollupPath() string {
+	return a.path + ".rollups.json"
+}
+
+// compactLocked rewrites the log without the events older than the retention
+// and, over the size limit, without the oldest events until it is down to
+// three quarters of it so that compactions are not run on every write. The
+// events pruned are first rolled up into daily aggregates.
+func (a *auditLog) compactLocked(now time.Time) (auditCompaction, error) {
+	result := auditCompaction{BytesBefore: a.size}
+
+	lines, err := readAuditLines(a.path)
+	if err != nil {
+		return result, err
+	}
+	result.EventsBefore = len(lines)
+
+	pruned := make([]bool, len(lines))
+	var size int64
+	for i, line := range lines {
+		if a.retention > 0 && line.notification.Timestamp.Before(now.Add(-a.retention)) {
+			pruned[i] = true
+			continue
+		}
+		size += int64(len(line.raw)) + 1
+	}
+
+	if a.maxSize > 0 && size > a.maxSize {
+		for i := 0; i < len(lines) && size > a.maxSize*3/4; i++ {
+			if !pruned[i] {
+				pruned[i] = true
+				size -= int64(len(lines[i].raw)) + 1
+			}
+		}
+	}
+
+	kept := []auditLine{}
+	for i, line := range lines {
+		if pruned[i] {
+			result.RolledUp++
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	if result.RolledUp > 0 {
+		stored, err := loadRollups(a.rollupPath())
+		if err != nil {
+			return result, err
+		}
+		stored.merge(rollUp(lines, func(i int) bool { return pruned[i] }))
+		if err := saveRollups(a.rollupPath(), stored); err != nil {
+			return result, err
+		}
+	}
+
+	tmp := a.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return result, err
+	}
+	writer := bufio.NewWriter(file)
+	for _, line := range kept {
+		writer.Write(line.raw)
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return result, err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return result, err
+	}
+	if err := os.Rename(tmp, a.path); err != nil {
+		os.Remove(tmp)
+		return result, err
+	}
+
+	reopened, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return result, err
+	}
+	a.file.Close()
+	a.file = reopened
+	a.size = size
+
+	result.EventsAfter = len(kept)
+	result.BytesAfter = size
+	return result, nil
+}
+
+// auditLine is an event of the audit log as written and decoded
+type auditLine struct {
+	raw          []byte
+	notification Notification
+}
+
+// readAuditLines reads back the events of the audit log, skipping lines cut
+// short by a crash
+func readAuditLines(path string) ([]auditLine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := []auditLine{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		notification := Notification{}
+		if err := json.Unmarshal(scanner.Bytes(), &notification); err != nil {
+			continue
+		}
+		raw := append([]byte{}, scanner.Bytes()...)
+		lines = append(lines, auditLine{raw: raw, notification: notification})
+	}
+	return lines, scanner.Err()
+}
+
+// runAuditCompaction prunes the audit log at every auditCompactInterval
+// with audit_retention set, and as soon as a write passes audit_max_size_mb
+func runAuditCompaction(a *auditLog) {
+	for {
+		select {
+		case <-a.oversized:
+		case <-time.After(auditCompactInterval):
+			if a.retention == 0 {
+				continue
+			}
+		}
+
+		result, err := a.compact(time.Now())
+		if err != nil {
+			log.Printf("Unable to compact audit log %s: %s\n", a.path, err)
+			continue
+		}
+		if writeDebug {
+			log.Printf("Compacted audit log %s: %d of %d event(s) kept\n", a.path, result.EventsAfter, result.EventsBefore)
+		}
+	}
+}
+
+// makeAuditCompactHandler compacts the audit log on demand and returns what
+// was pruned
+func makeAuditCompactHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if audit == nil {
+			http.Error(w, "audit_log_file is not set", http.StatusNotFound)
+			return
+		}
+
+		result, err := audit.compact(time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_auditLog_compact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2019, 1, 31, 0, 0, 0, 0, time.UTC)
+	log, err := openAuditLog(dir+"/audit.log", time.Hour*24*7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		log.write(Notification{Kind: kindScale, Function: "figlet", Timestamp: now.Add(-time.Hour * 24 * time.Duration(i))})
+	}
+
+	result, err := log.compact(now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if result.EventsBefore != 30 || result.EventsAfter != 8 {
+		t.Errorf("Events older than the retention should be pruned, got: %+v", result)
+	}
+
+	log.write(Notification{Kind: kindScale, Function: "figlet", Timestamp: now})
+	lines, _ := readAuditLines(log.path)
+	if len(lines) != 9 {
+		t.Errorf("Events should be appended after a compaction, want: 9 got: %d", len(lines))
+	}
+}
+
+func Test_auditLog_maxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	log, err := openAuditLog(dir+"/audit.log", 0, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		log.write(Notification{Kind: kindScale, Function: "figlet", Timestamp: start.Add(time.Minute * time.Duration(i))})
+	}
+
+	// Writes only append and signal the background compaction
+	if lines, _ := readAuditLines(log.path); len(lines) != 100 {
+		t.Errorf("A write should not compact the log, events want: 100 got: %d", len(lines))
+	}
+	select {
+	case <-log.oversized:
+	default:
+		t.Fatalf("A write past the size limit should signal a compaction")
+	}
+	if _, err := log.compact(time.Now()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	info, err := os.Stat(log.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 2048 || info.Size() != log.size {
+		t.Errorf("Audit log should be kept under 2048 bytes, got: %d tracked: %d", info.Size(), log.size)
+	}
+
+	lines, _ := readAuditLines(log.path)
+	if last := lines[len(lines)-1].notification.Timestamp; !last.Equal(start.Add(time.Minute * 99)) {
+		t.Errorf("The most recent event should be kept, got: %s", last)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/types"
+)
+
+// externalAutoscalerAnnotation marks a function scaled by another
+// autoscaler, i.e. hpa or keda, which the idler leaves alone
+const externalAutoscalerAnnotation = "com.openfaas.autoscaler.external"
+
+// In-cluster credentials of the idler's service account
+const (
+	serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// defaultFunctionNamespace holds the functions listed without a namespace
+const defaultFunctionNamespace = "openfaas-fn"
+
+// scaleTargets is a list of HorizontalPodAutoscalers or KEDA ScaledObjects,
+// of which only the workload they scale is read
+type scaleTargets struct {
+	Items []struct {
+		Spec struct {
+			ScaleTargetRef struct {
+				Kind string `json:"kind"`
+				Name string `json:"name"`
+			} `json:"scaleTargetRef"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+// kubernetesClient reaches the Kubernetes API with the idler's service
+// account, to list the autoscalers of a namespace and record replicas
+type kubernetesClient struct {
+	url       string
+	tokenFile string
+	client    *http.Client
+}
+
+// newKubernetesClient connects to kubernetes_api_url, by default the API
+// server of the cluster the idler runs in, trusting its service account CA
+func newKubernetesClient(config types.Config) *kubernetesClient {
+	var tlsConfig *tls.Config
+	if data, err := ioutil.ReadFile(serviceAccountCAFile); err == nil {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(data)
+		tlsConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &kubernetesClient{
+		url:       strings.TrimSuffix(config.KubernetesAPIURL, "/"),
+		tokenFile: serviceAccountTokenFile,
+		client:    newHeaderClient(config.UserAgent, nil, tlsConfig),
+	}
+}
+
+// autoscaled returns the Deployments of a namespace targeted by an HPA or a
+// KEDA ScaledObject, by name, with the kind of autoscaler. A cluster without
+// KEDA has no ScaledObjects.
+func (k *kubernetesClient) autoscaled(ctx context.Context, namespace string) (map[string]string, error) {
+	autoscaled := make(map[string]string)
+
+	hpas, err := k.list(ctx, "/apis/autoscaling/v1/namespaces/"+url.PathEscape(namespace)+"/horizontalpodautoscalers")
+	if err != nil {
+		return nil, err
+	}
+	for _, hpa := range hpas.Items {
+		if ref := hpa.Spec.ScaleTargetRef; ref.Kind == "Deployment" {
+			autoscaled[ref.Name] = "hpa"
+		}
+	}
+
+	scaledObjects, err := k.list(ctx, "/apis/keda.sh/v1alpha1/namespaces/"+url.PathEscape(namespace)+"/scaledobjects")
+	if err != nil && !strings.Contains(err.Error(), "got: 404") {
+		return nil, err
+	}
+	for _, scaledObject := range scaledObjects.Items {
+		// KEDA scales a Deployment when no kind is given
+		if ref := scaledObject.Spec.ScaleTargetRef; len(ref.Kind) == 0 || ref.Kind == "Deployment" {
+			autoscaled[ref.Name] = "keda"
+		}
+	}
+	return autoscaled, nil
+}
+
+func (k *kubernetesClient) list(ctx context.Context, path string) (scaleTargets, error) {
+	targets := scaleTargets{}
+
+	body, err := k.do(ctx, http.MethodGet, path, "", nil)
+	if err != nil {
+		return targets, err
+	}
+	if err := json.Unmarshal(body, &targets); err != nil {
+		return targets, err
+	}
+	return targets, nil
+}
+
+// do sends a request to the API server and returns the body of a 2xx
+// response
+func (k *kubernetesClient) do(ctx context.Context, method string, path string, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, k.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	// Service account tokens are rotated, so the file is read every time
+	if token, err := ioutil.ReadFile(k.tokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	res, err := k.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	bytesOut, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("Unexpected status code from Kubernetes want: 2xx, got: %d, body: %s", res.StatusCode, string(bytesOut))
+	}
+	return bytesOut, nil
+}
+
+// externalAutoscalers finds which functions another autoscaler manages: those
+// with the com.openfaas.autoscaler.external annotation, and with
+// kubernetes_autoscalers those targeted by an HPA or ScaledObject, looked up
+// once per namespace. The functions of a namespace whose lookup failed are
+// returned as unchecked, to be left alone too.
+func externalAutoscalers(ctx context.Context, gateway *Gateway, functions []Function, summary *cycleSummary) (map[string]string, map[string]bool) {
+	autoscaled := make(map[string]string)
+	unchecked := make(map[string]bool)
+	failed := make(map[string]bool)
+
+	byNamespace := make(map[string]map[string]string)
+	for _, fn := range functions {
+		if value := settingValue(fn, externalAutoscalerAnnotation); len(value) > 0 && value != "false" && value != "0" {
+			autoscaled[fn.Name] = value
+			continue
+		}
+		if !gateway.Config.KubernetesAutoscalers || gateway.kubernetes == nil {
+			continue
+		}
+
+		namespace := fn.Namespace
+		if len(namespace) == 0 {
+			namespace = defaultFunctionNamespace
+		}
+		if failed[namespace] {
+			unchecked[fn.Name] = true
+			continue
+		}
+
+		deployments, looked := byNamespace[namespace]
+		if !looked {
+			lookupCtx, cancel := withTimeout(ctx, gateway.Config.ListFunctionsTimeout)
+			var err error
+			deployments, err = gateway.kubernetes.autoscaled(lookupCtx, namespace)
+			cancel()
+			if err != nil {
+				log.Printf("[%s] Unable to list the autoscalers of namespace %s: %s\n", gateway.Name, namespace, err)
+				summary.fail("autoscalers", err)
+				failed[namespace] = true
+				unchecked[fn.Name] = true
+				continue
+			}
+			byNamespace[namespace] = deployments
+		}
+		if kind, exists := deployments[fn.Name]; exists {
+			autoscaled[fn.Name] = kind
+		}
+	}
+	return autoscaled, unchecked
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_externalAutoscalers(t *testing.T) {
+	keda := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apis/autoscaling/v1/namespaces/openfaas-fn/horizontalpodautoscalers":
+			w.Write([]byte(`{"items": [
+				{"spec": {"scaleTargetRef": {"kind": "Deployment", "name": "figlet"}}},
+				{"spec": {"scaleTargetRef": {"kind": "StatefulSet", "name": "nodeinfo"}}}
+			]}`))
+		case "/apis/keda.sh/v1alpha1/namespaces/openfaas-fn/scaledobjects":
+			if !keda {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`{"items": [{"spec": {"scaleTargetRef": {"name": "env"}}}]}`))
+		default:
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	gateway := newGateway(types.Config{Name: "test", KubernetesAutoscalers: true})
+	gateway.kubernetes = newKubernetesClient(types.Config{KubernetesAPIURL: server.URL})
+
+	external := map[string]string{externalAutoscalerAnnotation: "hpa"}
+	functions := []Function{
+		{Function: requests.Function{Name: "figlet"}},
+		{Function: requests.Function{Name: "nodeinfo"}, Namespace: "openfaas-fn"},
+		{Function: requests.Function{Name: "env"}},
+		{Function: requests.Function{Name: "cows"}, Namespace: "staging"},
+		{Function: requests.Function{Name: "echo"}, Namespace: "staging"},
+		{Function: requests.Function{Name: "shasum"}, Namespace: "staging", Annotations: &external},
+	}
+	summary := newCycleSummary("test", time.Now())
+
+	autoscaled, unchecked := externalAutoscalers(context.Background(), gateway, functions, summary)
+	if len(autoscaled) != 3 || autoscaled["figlet"] != "hpa" || autoscaled["env"] != "keda" || autoscaled["shasum"] != "hpa" {
+		t.Errorf("Unexpected autoscaled functions: %v", autoscaled)
+	}
+	if len(unchecked) != 2 || !unchecked["cows"] || !unchecked["echo"] {
+		t.Errorf("The functions of a namespace which could not be listed should be unchecked, got: %v", unchecked)
+	}
+	if summary.failures() != 1 {
+		t.Errorf("A namespace should be looked up once per pass, failures: %d", summary.failures())
+	}
+
+	keda = false
+	autoscaled, _ = externalAutoscalers(context.Background(), gateway, functions[:3], summary)
+	if len(autoscaled) != 1 || autoscaled["figlet"] != "hpa" {
+		t.Errorf("A cluster without KEDA should only have HPAs, got: %v", autoscaled)
+	}
+}
+
+func Test_externalAutoscalers_annotationOnly(t *testing.T) {
+	gateway := newGateway(types.Config{Name: "test"})
+
+	disabled := map[string]string{externalAutoscalerAnnotation: "false"}
+	keda := map[string]string{externalAutoscalerAnnotation: "keda"}
+	functions := []Function{
+		{Function: requests.Function{Name: "figlet", Labels: &keda}},
+		{Function: requests.Function{Name: "env"}, Annotations: &disabled},
+	}
+
+	autoscaled, unchecked := externalAutoscalers(context.Background(), gateway, functions, newCycleSummary("test", time.Now()))
+	if len(autoscaled) != 1 || autoscaled["figlet"] != "keda" || len(unchecked) != 0 {
+		t.Errorf("Without kubernetes_autoscalers only labels and annotations count, got: %v %v", autoscaled, unchecked)
+	}
+}
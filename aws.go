@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// awsMetadataTimeout bounds each request to the EC2 instance metadata
+// service, which is not reachable outside of EC2
+const awsMetadataTimeout = time.Second
+
+// awsCredentialsRefresh renews temporary credentials this long before they
+// expire
+const awsCredentialsRefresh = time.Minute * 5
+
+// awsCredentials sign requests to AWS, Expiration is zero for long-lived keys
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// awsCredentialSource returns credentials, ok is false when the source is
+// not configured in this environment
+type awsCredentialSource func(ctx context.Context, c *awsCredentialChain) (creds awsCredentials, ok bool, err error)
+
+// awsCredentialChain resolves credentials as the AWS SDKs do, from the first
+// source configured, and caches them until shortly before they expire
+type awsCredentialChain struct {
+	mu      sync.Mutex
+	client  *http.Client
+	region  string
+	stsURL  string
+	imdsURL string
+	sources []awsCredentialSource
+	cached  *awsCredentials
+}
+
+func newAWSCredentialChain(client *http.Client, region string) *awsCredentialChain {
+	stsURL := "https://sts.amazonaws.com"
+	if len(region) > 0 {
+		stsURL = "https://sts." + region + ".amazonaws.com"
+		if strings.HasPrefix(region, "cn-") {
+			stsURL += ".cn"
+		}
+	}
+
+	return &awsCredentialChain{
+		client:  client,
+		region:  region,
+		stsURL:  stsURL,
+		imdsURL: "http://169.254.169.254",
+		sources: []awsCredentialSource{
+			awsEnvCredentials,
+			awsSharedCredentials,
+			awsWebIdentityCredentials,
+			awsContainerCredentials,
+			awsInstanceCredentials,
+		},
+	}
+}
+
+func (c *awsCredentialChain) get(ctx context.Context) (awsCredentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cached != nil && (c.cached.Expiration.IsZero() || time.Until(c.cached.Expiration) > awsCredentialsRefresh) {
+		return *c.cached, nil
+	}
+
+	for _, source := range c.sources {
+		creds, ok, err := source(ctx, c)
+		if err != nil {
+			return awsCredentials{}, err
+		}
+		if ok {
+			c.cached = &creds
+			return creds, nil
+		}
+	}
+	return awsCredentials{}, fmt.Errorf("no AWS credentials found in the environment, shared credentials file, web identity, ECS task role or EC2 instance profile")
+}
+
+// awsEnvCredentials reads AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+func awsEnvCredentials(ctx context.Context, c *awsCredentialChain) (awsCredentials, bool, error) {
+	creds := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if len(creds.AccessKeyID) == 0 {
+		return creds, false, nil
+	}
+	if len(creds.SecretAccessKey) == 0 {
+		return creds, false, fmt.Errorf("AWS_SECRET_ACCESS_KEY must be set with AWS_ACCESS_KEY_ID")
+	}
+	return creds, true, nil
+}
+
+// awsSharedCredentials reads the keys of AWS_PROFILE, or the default
+// profile, from AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials
+func awsSharedCredentials(ctx context.Context, c *awsCredentialChain) (awsCredentials, bool, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if len(path) == 0 {
+		home := os.Getenv("HOME")
+		if len(home) == 0 {
+			return awsCredentials{}, false, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if len(profile) == 0 {
+		profile = "default"
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return awsCredentials{}, false, nil
+		}
+		return awsCredentials{}, false, err
+	}
+
+	keys := map[string]string{}
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+		case section == profile:
+			if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
+				keys[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+			}
+		}
+	}
+
+	creds := awsCredentials{
+		AccessKeyID:     keys["aws_access_key_id"],
+		SecretAccessKey: keys["aws_secret_access_key"],
+		SessionToken:    keys["aws_session_token"],
+	}
+	if len(creds.AccessKeyID) == 0 {
+		return creds, false, nil
+	}
+	if len(creds.SecretAccessKey) == 0 {
+		return creds, false, fmt.Errorf("%s: profile %s has no aws_secret_access_key", path, profile)
+	}
+	return creds, true, nil
+}
+
+// awsWebIdentityCredentials assumes AWS_ROLE_ARN with the token in
+// AWS_WEB_IDENTITY_TOKEN_FILE, as projected by IAM roles for service
+// accounts on EKS
+func awsWebIdentityCredentials(ctx context.Context, c *awsCredentialChain) (awsCredentials, bool, error) {
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	roleARN := os.Getenv("AWS_ROLE_ARN")
+	if len(tokenFile) == 0 || len(roleARN) == 0 {
+		return awsCredentials{}, false, nil
+	}
+
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return awsCredentials{}, false, err
+	}
+
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if len(sessionName) == 0 {
+		sessionName = "faas-idler"
+	}
+
+	values := url.Values{}
+	values.Set("Action", "AssumeRoleWithWebIdentity")
+	values.Set("Version", "2011-06-15")
+	values.Set("RoleArn", roleARN)
+	values.Set("RoleSessionName", sessionName)
+	values.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+
+	req, err := http.NewRequest(http.MethodPost, c.stsURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return awsCredentials{}, false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	bytesOut, err := doRequest(ctx, c.client, req, "STS")
+	if err != nil {
+		return awsCredentials{}, false, err
+	}
+
+	body := struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}{}
+	if err := xml.Unmarshal(bytesOut, &body); err != nil {
+		return awsCredentials{}, false, fmt.Errorf("Error unmarshaling result: %s, '%s'", err, string(bytesOut))
+	}
+
+	return awsCredentials{
+		AccessKeyID:     body.Credentials.AccessKeyID,
+		SecretAccessKey: body.Credentials.SecretAccessKey,
+		SessionToken:    body.Credentials.SessionToken,
+		Expiration:      body.Credentials.Expiration,
+	}, true, nil
+}
+
+// awsContainerCredentials reads the task role's credentials from the ECS
+// agent, or the endpoint in AWS_CONTAINER_CREDENTIALS_FULL_URI
+func awsContainerCredentials(ctx context.Context, c *awsCredentialChain) (awsCredentials, bool, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); len(relative) > 0 {
+		endpoint = "http://169.254.170.2" + relative
+	}
+	if len(endpoint) == 0 {
+		return awsCredentials{}, false, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return awsCredentials{}, false, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); len(token) > 0 {
+		req.Header.Set("Authorization", token)
+	}
+
+	bytesOut, err := doRequest(ctx, c.client, req, "the container credentials endpoint")
+	if err != nil {
+		return awsCredentials{}, false, err
+	}
+
+	body := struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}{}
+	if err := json.Unmarshal(bytesOut, &body); err != nil {
+		return awsCredentials{}, false, fmt.Errorf("Error unmarshaling result: %s, '%s'", err, string(bytesOut))
+	}
+
+	return awsCredentials{
+		AccessKeyID:     body.AccessKeyID,
+		SecretAccessKey: body.SecretAccessKey,
+		SessionToken:    body.Token,
+		Expiration:      body.Expiration,
+	}, true, nil
+}
+
+// awsInstanceCredentials reads the instance profile's credentials from the
+// EC2 instance metadata service with an IMDSv2 session token, it is skipped
+// when the service can't be reached or AWS_EC2_METADATA_DISABLED is true
+func awsInstanceCredentials(ctx context.Context, c *awsCredentialChain) (awsCredentials, bool, error) {
+	if disabled := os.Getenv("AWS_EC2_METADATA_DISABLED"); disabled == "1" || disabled == "true" {
+		return awsCredentials{}, false, nil
+	}
+	client := boundedClient(ctx, c.client, awsMetadataTimeout)
+
+	req, err := http.NewRequest(http.MethodPut, c.imdsURL+"/latest/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, false, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	token, err := doRequest(ctx, client, req, "the EC2 instance metadata service")
+	if err != nil {
+		return awsCredentials{}, false, nil
+	}
+
+	get := func(path string) ([]byte, error) {
+		req, err := http.NewRequest(http.MethodGet, c.imdsURL+"/latest/meta-data/iam/security-credentials/"+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+		return doRequest(ctx, client, req, "the EC2 instance metadata service")
+	}
+
+	roles, err := get("")
+	if err != nil {
+		return awsCredentials{}, false, nil
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if len(role) == 0 {
+		return awsCredentials{}, false, nil
+	}
+
+	bytesOut, err := get(role)
+	if err != nil {
+		return awsCredentials{}, false, err
+	}
+
+	body := struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}{}
+	if err := json.Unmarshal(bytesOut, &body); err != nil {
+		return awsCredentials{}, false, fmt.Errorf("Error unmarshaling result: %s, '%s'", err, string(bytesOut))
+	}
+
+	return awsCredentials{
+		AccessKeyID:     body.AccessKeyID,
+		SecretAccessKey: body.SecretAccessKey,
+		SessionToken:    body.Token,
+		Expiration:      body.Expiration,
+	}, true, nil
+}
+
+// sigV4Transport signs every request for the service in the region with
+// credentials from the AWS provider chain
+type sigV4Transport struct {
+	credentials *awsCredentialChain
+	region      string
+	service     string
+	next        http.RoundTripper
+}
+
+func newSigV4Transport(next http.RoundTripper, region string, service string, client *http.Client) *sigV4Transport {
+	return &sigV4Transport{
+		credentials: newAWSCredentialChain(client, region),
+		region:      region,
+		service:     service,
+		next:        next,
+	}
+}
+
+func (t *sigV4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	creds, err := t.credentials.get(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	body := []byte{}
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A RoundTripper must not modify the request it was given
+	clone := *req
+	clone.Header = make(http.Header, len(req.Header)+3)
+	for key, values := range req.Header {
+		clone.Header[key] = append([]string(nil), values...)
+	}
+	if req.Body != nil {
+		clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	signAWSRequest(&clone, body, creds, t.region, t.service, time.Now())
+	return t.next.RoundTrip(&clone)
+}
+
+// signAWSRequest adds a Signature Version 4 Authorization header for the
+// service in the region. The Host, Content-Type and X-Amz-* headers are
+// signed along with the body.
+func signAWSRequest(req *http.Request, body []byte, creds awsCredentials, region string, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if len(creds.SessionToken) > 0 {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	if len(req.Host) > 0 {
+		headers["host"] = req.Host
+	}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+		}
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		awsCanonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = awsHMAC(key, part)
+	}
+	signature := hex.EncodeToString(awsHMAC(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// awsCanonicalQuery sorts the query by key and value, escaped as RFC 3986
+func awsCanonicalQuery(values url.Values) string {
+	pairs := []string{}
+	for key, list := range values {
+		for _, value := range list {
+			pairs = append(pairs, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func awsEscape(value string) string {
+	return strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+}
+
+func awsHMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
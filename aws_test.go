@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test_signAWSRequest signs the GET example of the AWS General Reference
+func Test_signAWSRequest(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signAWSRequest(req, nil, creds, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization wanted: %s got: %s", want, got)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date wanted: 20150830T123600Z got: %s", got)
+	}
+}
+
+func Test_awsCredentialChain(t *testing.T) {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_CONTAINER_AUTHORIZATION_TOKEN",
+		"AWS_SHARED_CREDENTIALS_FILE", "AWS_PROFILE", "AWS_EC2_METADATA_DISABLED"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+
+	dir, _ := ioutil.TempDir("", "aws")
+	defer os.RemoveAll(dir)
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	os.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	t.Run("none", func(t *testing.T) {
+		if _, err := newAWSCredentialChain(&http.Client{}, "eu-west-1").get(context.Background()); err == nil {
+			t.Errorf("Expected an error without credentials")
+		}
+	})
+
+	t.Run("container", func(t *testing.T) {
+		requests := 0
+		agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if r.Header.Get("Authorization") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"AccessKeyId": "ASIA", "SecretAccessKey": "key", "Token": "session", "Expiration": "` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"}`))
+		}))
+		defer agent.Close()
+
+		os.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", agent.URL)
+		os.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "secret")
+		defer os.Unsetenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+
+		chain := newAWSCredentialChain(&http.Client{}, "eu-west-1")
+		for i := 0; i < 2; i++ {
+			creds, err := chain.get(context.Background())
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if creds.AccessKeyID != "ASIA" || creds.SessionToken != "session" {
+				t.Errorf("Unexpected credentials: %+v", creds)
+			}
+		}
+		if requests != 1 {
+			t.Errorf("Credentials should be cached until they expire, got %d requests", requests)
+		}
+	})
+
+	t.Run("instance", func(t *testing.T) {
+		imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut && r.URL.Path == "/latest/api/token" {
+				w.Write([]byte("imds-token"))
+				return
+			}
+			if r.Header.Get("X-Aws-Ec2-Metadata-Token") != "imds-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			switch r.URL.Path {
+			case "/latest/meta-data/iam/security-credentials/":
+				w.Write([]byte("node-role\n"))
+			case "/latest/meta-data/iam/security-credentials/node-role":
+				w.Write([]byte(`{"Code": "Success", "AccessKeyId": "ASIANODE", "SecretAccessKey": "key", "Token": "session", "Expiration": "2100-01-01T00:00:00Z"}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer imds.Close()
+
+		os.Unsetenv("AWS_EC2_METADATA_DISABLED")
+		defer os.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+		chain := newAWSCredentialChain(&http.Client{}, "eu-west-1")
+		chain.imdsURL = imds.URL
+		creds, err := chain.get(context.Background())
+		if err != nil || creds.AccessKeyID != "ASIANODE" || creds.SessionToken != "session" {
+			t.Errorf("Unexpected credentials: %+v %v", creds, err)
+		}
+	})
+
+	t.Run("shared file", func(t *testing.T) {
+		ioutil.WriteFile(filepath.Join(dir, "credentials"), []byte("[default]\naws_access_key_id = AKIDDEFAULT\naws_secret_access_key = key\n\n[idler]\naws_access_key_id=AKIDIDLER\naws_secret_access_key=key\n"), 0600)
+		defer os.Remove(filepath.Join(dir, "credentials"))
+		os.Setenv("AWS_PROFILE", "idler")
+		defer os.Unsetenv("AWS_PROFILE")
+
+		creds, err := newAWSCredentialChain(&http.Client{}, "eu-west-1").get(context.Background())
+		if err != nil || creds.AccessKeyID != "AKIDIDLER" {
+			t.Errorf("Unexpected credentials: %+v %v", creds, err)
+		}
+	})
+
+	t.Run("web identity", func(t *testing.T) {
+		tokenFile := filepath.Join(dir, "token")
+		ioutil.WriteFile(tokenFile, []byte("jwt\n"), 0600)
+
+		sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Form.Get("WebIdentityToken") != "jwt" || r.Form.Get("RoleArn") != "arn:aws:iam::1:role/idler" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+<AccessKeyId>ASIAWEB</AccessKeyId><SecretAccessKey>key</SecretAccessKey><SessionToken>session</SessionToken><Expiration>2100-01-01T00:00:00Z</Expiration>
+</Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`))
+		}))
+		defer sts.Close()
+
+		os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+		os.Setenv("AWS_ROLE_ARN", "arn:aws:iam::1:role/idler")
+		defer os.Unsetenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+
+		chain := newAWSCredentialChain(&http.Client{}, "eu-west-1")
+		chain.stsURL = sts.URL
+		creds, err := chain.get(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if creds.AccessKeyID != "ASIAWEB" || creds.Expiration.Year() != 2100 {
+			t.Errorf("Unexpected credentials: %+v", creds)
+		}
+	})
+
+	t.Run("env", func(t *testing.T) {
+		os.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+		os.Setenv("AWS_SECRET_ACCESS_KEY", "key")
+
+		creds, err := newAWSCredentialChain(&http.Client{}, "eu-west-1").get(context.Background())
+		if err != nil || creds.AccessKeyID != "AKID" || !creds.Expiration.IsZero() {
+			t.Errorf("Unexpected credentials: %+v %v", creds, err)
+		}
+	})
+}
+
+func Test_sigV4Transport(t *testing.T) {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "key")
+	os.Setenv("AWS_SESSION_TOKEN", "session")
+
+	var received *http.Request
+	workspace := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		w.Write([]byte(`{"status": "success", "data": {"resultType": "vector", "result": []}}`))
+	}))
+	defer workspace.Close()
+
+	client := newHeaderClient("", nil, nil)
+	client.Transport = newSigV4Transport(client.Transport, "eu-west-1", "aps", &http.Client{})
+
+	res, err := client.Get(workspace.URL + "/workspaces/ws-1/api/v1/query?query=up")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	res.Body.Close()
+
+	authorization := received.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(authorization, "/eu-west-1/aps/aws4_request") {
+		t.Errorf("Unexpected Authorization: %s", authorization)
+	}
+	if received.Header.Get("X-Amz-Security-Token") != "session" || !strings.Contains(authorization, "x-amz-security-token") {
+		t.Errorf("The session token should be sent and signed, got: %v", received.Header)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureTokenSource requests Azure AD access tokens as the Azure SDKs'
+// default credential does: with AKS workload identity's federated token,
+// a service principal's client secret, or else the managed identity
+type azureTokenSource struct {
+	client        *http.Client
+	scope         string
+	authorityHost string
+	imdsURL       string
+}
+
+func newAzureTokenSource(client *http.Client, scope string) *azureTokenSource {
+	authorityHost := strings.TrimSuffix(os.Getenv("AZURE_AUTHORITY_HOST"), "/")
+	if len(authorityHost) == 0 {
+		authorityHost = "https://login.microsoftonline.com"
+	}
+
+	return &azureTokenSource{
+		client:        client,
+		scope:         scope,
+		authorityHost: authorityHost,
+		imdsURL:       "http://169.254.169.254",
+	}
+}
+
+// azureTokenResponse is the token of Azure AD or the managed identity
+// endpoint, which sends expires_in as a string
+type azureTokenResponse struct {
+	AccessToken string      `json:"access_token"`
+	ExpiresIn   interface{} `json:"expires_in"`
+}
+
+func (s *azureTokenSource) token(ctx context.Context) (string, time.Time, error) {
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+
+	if tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); len(tokenFile) > 0 && len(clientID) > 0 && len(tenantID) > 0 {
+		assertion, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+
+		values := url.Values{}
+		values.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		values.Set("client_assertion", strings.TrimSpace(string(assertion)))
+		return s.clientCredentials(ctx, tenantID, clientID, values)
+	}
+
+	if secret := os.Getenv("AZURE_CLIENT_SECRET"); len(secret) > 0 && len(clientID) > 0 && len(tenantID) > 0 {
+		values := url.Values{}
+		values.Set("client_secret", secret)
+		return s.clientCredentials(ctx, tenantID, clientID, values)
+	}
+
+	return s.managedIdentity(ctx, clientID)
+}
+
+// clientCredentials requests a token for the app registration from Azure AD
+func (s *azureTokenSource) clientCredentials(ctx context.Context, tenantID string, clientID string, values url.Values) (string, time.Time, error) {
+	values.Set("grant_type", "client_credentials")
+	values.Set("client_id", clientID)
+	values.Set("scope", s.scope)
+
+	req, err := http.NewRequest(http.MethodPost, s.authorityHost+"/"+tenantID+"/oauth2/v2.0/token", strings.NewReader(values.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return s.do(ctx, req, "Azure AD")
+}
+
+// managedIdentity requests a token from the instance metadata service, for
+// the user-assigned identity of AZURE_CLIENT_ID when set
+func (s *azureTokenSource) managedIdentity(ctx context.Context, clientID string) (string, time.Time, error) {
+	values := url.Values{}
+	values.Set("api-version", "2018-02-01")
+	values.Set("resource", strings.TrimSuffix(s.scope, "/.default"))
+	if len(clientID) > 0 {
+		values.Set("client_id", clientID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s.imdsURL+"/metadata/identity/oauth2/token?"+values.Encode(), nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	return s.do(ctx, req, "the Azure managed identity endpoint")
+}
+
+func (s *azureTokenSource) do(ctx context.Context, req *http.Request, service string) (string, time.Time, error) {
+	bytesOut, err := doRequest(ctx, s.client, req, service)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	body := azureTokenResponse{}
+	if err := json.Unmarshal(bytesOut, &body); err != nil {
+		return "", time.Time{}, fmt.Errorf("Error unmarshaling result: %s, '%s'", err, string(bytesOut))
+	}
+	if len(body.AccessToken) == 0 {
+		return "", time.Time{}, fmt.Errorf("no access_token from %s", service)
+	}
+
+	expiresIn := 0.0
+	switch value := body.ExpiresIn.(type) {
+	case float64:
+		expiresIn = value
+	case string:
+		expiresIn, _ = strconv.ParseFloat(value, 64)
+	}
+	return body.AccessToken, time.Now().Add(time.Duration(expiresIn) * time.Second), nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_azureTokenSource(t *testing.T) {
+	for _, name := range []string{"AZURE_CLIENT_ID", "AZURE_TENANT_ID", "AZURE_FEDERATED_TOKEN_FILE", "AZURE_CLIENT_SECRET", "AZURE_AUTHORITY_HOST"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+
+	t.Run("workload identity", func(t *testing.T) {
+		dir, _ := ioutil.TempDir("", "azure")
+		defer os.RemoveAll(dir)
+		tokenFile := filepath.Join(dir, "token")
+		ioutil.WriteFile(tokenFile, []byte("federated\n"), 0600)
+
+		aad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			if r.URL.Path != "/tenant/oauth2/v2.0/token" || r.Form.Get("client_assertion") != "federated" ||
+				r.Form.Get("client_id") != "client" || r.Form.Get("scope") != types.DefaultAzureTokenScope {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"token_type": "Bearer", "expires_in": 3599, "access_token": "aad-token"}`))
+		}))
+		defer aad.Close()
+
+		os.Setenv("AZURE_CLIENT_ID", "client")
+		os.Setenv("AZURE_TENANT_ID", "tenant")
+		os.Setenv("AZURE_FEDERATED_TOKEN_FILE", tokenFile)
+		os.Setenv("AZURE_AUTHORITY_HOST", aad.URL+"/")
+		defer os.Unsetenv("AZURE_FEDERATED_TOKEN_FILE")
+
+		token, expires, err := newAzureTokenSource(&http.Client{}, types.DefaultAzureTokenScope).token(context.Background())
+		if err != nil || token != "aad-token" {
+			t.Fatalf("Unexpected token: %s %v", token, err)
+		}
+		if expires.IsZero() {
+			t.Errorf("The token should expire")
+		}
+	})
+
+	t.Run("managed identity", func(t *testing.T) {
+		imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("resource") != "https://prometheus.monitor.azure.com" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token": "msi-token", "expires_in": "86399", "token_type": "Bearer"}`))
+		}))
+		defer imds.Close()
+
+		os.Unsetenv("AZURE_TENANT_ID")
+
+		source := newAzureTokenSource(&http.Client{}, types.DefaultAzureTokenScope)
+		source.imdsURL = imds.URL
+		token, _, err := source.token(context.Background())
+		if err != nil || token != "msi-token" {
+			t.Errorf("Unexpected token: %s %v", token, err)
+		}
+	})
+}
+
+type fakeTokenSource struct {
+	calls int
+}
+
+func (s *fakeTokenSource) token(ctx context.Context) (string, time.Time, error) {
+	s.calls++
+	return "token", time.Now().Add(time.Hour), nil
+}
+
+func Test_tokenTransport(t *testing.T) {
+	var authorization string
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer prometheus.Close()
+
+	source := &fakeTokenSource{}
+	client := &http.Client{Transport: &tokenTransport{source: source, next: http.DefaultTransport}}
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(prometheus.URL)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		res.Body.Close()
+	}
+
+	if authorization != "Bearer token" {
+		t.Errorf("Authorization wanted: Bearer token got: %s", authorization)
+	}
+	if source.calls != 1 {
+		t.Errorf("The token should be cached until it expires, got %d calls", source.calls)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	providerTypes "github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+// benchFleet is a synthetic gateway and Prometheus serving a fleet of
+// functions, a share of which are idle
+type benchFleet struct {
+	mu       sync.Mutex
+	names    []string
+	replicas map[string]uint64
+	idle     map[string]bool
+	latency  time.Duration
+
+	// restarted answers as a Prometheus which just restarted, with little
+	// uptime and no invocations recorded yet
+	restarted bool
+	// unsafe lists the active functions the idler tried to scale
+	unsafe []string
+
+	gatewayRequests    int64
+	prometheusRequests int64
+	scaleRequests      int64
+}
+
+var benchFunctionName = regexp.MustCompile(`function_name="([^"]+)"`)
+
+func newBenchFleet(functions int, idleShare float64, latency time.Duration) *benchFleet {
+	fleet := &benchFleet{
+		replicas: make(map[string]uint64),
+		idle:     make(map[string]bool),
+		latency:  latency,
+	}
+
+	idleCount := int(float64(functions) * idleShare)
+	for i := 0; i < functions; i++ {
+		name := fmt.Sprintf("fn-%05d", i)
+		fleet.names = append(fleet.names, name)
+		fleet.replicas[name] = 1
+		fleet.idle[name] = i < idleCount
+	}
+	return fleet
+}
+
+func (f *benchFleet) function(name string) Function {
+	return Function{
+		Function: requests.Function{
+			Name:              name,
+			Image:             "functions/" + name + ":latest",
+			Replicas:          f.replicas[name],
+			AvailableReplicas: f.replicas[name],
+		},
+		Namespace: "openfaas-fn",
+	}
+}
+
+func (f *benchFleet) gateway(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&f.gatewayRequests, 1)
+	time.Sleep(f.latency)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/healthz":
+		w.WriteHeader(http.StatusOK)
+	case r.URL.Path == "/system/info":
+		w.Write([]byte(`{"version": {"release": "bench", "sha": "bench"}}`))
+	case r.URL.Path == "/system/functions":
+		functions := make([]Function, 0, len(f.names))
+		for _, name := range f.names {
+			functions = append(functions, f.function(name))
+		}
+		json.NewEncoder(w).Encode(functions)
+	case strings.HasPrefix(r.URL.Path, "/system/function/"):
+		name := strings.TrimPrefix(r.URL.Path, "/system/function/")
+		if _, exists := f.replicas[name]; !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(f.function(name))
+	case strings.HasPrefix(r.URL.Path, "/system/scale-function/"):
+		atomic.AddInt64(&f.scaleRequests, 1)
+		scale := providerTypes.ScaleServiceRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&scale); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.replicas[scale.ServiceName] = scale.Replicas
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// scaleAttempted records a scale request for a function which is active
+func (f *benchFleet) scaleAttempted(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.idle[name] {
+		f.unsafe = append(f.unsafe, name)
+	}
+}
+
+// prometheus answers activity queries by function, every other query, such
+// as prometheus_uptime_query, with no data unless restarted
+func (f *benchFleet) prometheus(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&f.prometheusRequests, 1)
+	time.Sleep(f.latency)
+
+	f.mu.Lock()
+	restarted := f.restarted
+	f.mu.Unlock()
+
+	query := r.URL.Query().Get("query")
+	match := benchFunctionName.FindStringSubmatch(query)
+	if match == nil && strings.Contains(query, "gateway_function_invocation_total") {
+		f.batch(w, restarted)
+		return
+	}
+	if match == nil {
+		if restarted {
+			fmt.Fprintf(w, `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [%d, "60"]}]}}`, time.Now().Unix())
+			return
+		}
+		w.Write([]byte(`{"status": "success", "data": {"resultType": "vector", "result": []}}`))
+		return
+	}
+
+	f.mu.Lock()
+	rate := "0.5"
+	if f.idle[match[1]] || restarted {
+		rate = "0"
+	}
+	f.mu.Unlock()
+
+	fmt.Fprintf(w, `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {"code": "200", "function_name": %q}, "value": [%d, %q]}]}}`, match[1], time.Now().Unix(), rate)
+}
+
+// batch answers a query for the rate of every function at once
+func (f *benchFleet) batch(w http.ResponseWriter, restarted bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	results := []string{}
+	for _, name := range f.names {
+		rate := "0.5"
+		if f.idle[name] || restarted {
+			rate = "0"
+		}
+		results = append(results, fmt.Sprintf(`{"metric": {"function_name": %q}, "value": [%d, %q]}`, name, time.Now().Unix(), rate))
+	}
+
+	fmt.Fprintf(w, `{"status": "success", "data": {"resultType": "vector", "result": [%s]}}`, strings.Join(results, ", "))
+}
+
+// benchCycle measures a single reconcile pass over the fleet
+type benchCycle struct {
+	DurationSeconds    float64 `json:"duration_seconds"`
+	Allocs             uint64  `json:"allocs"`
+	AllocBytes         uint64  `json:"alloc_bytes"`
+	GatewayRequests    int64   `json:"gateway_requests"`
+	PrometheusRequests int64   `json:"prometheus_requests"`
+	ScaleRequests      int64   `json:"scale_requests"`
+	Idled              int     `json:"idled"`
+	Failed             int     `json:"failed"`
+}
+
+type benchResult struct {
+	Functions int          `json:"functions"`
+	Idle      int          `json:"idle"`
+	Cycles    []benchCycle `json:"cycles"`
+}
+
+// runBench reconciles a synthetic fleet served in-process and prints what
+// each pass cost as JSON, the first pass scales the idle functions and the
+// following ones find them already at zero
+func runBench(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	functions := flags.Int("functions", 1000, "number of synthetic functions")
+	idleShare := flags.Float64("idle", 0.5, "share of the functions which are idle, between 0 and 1")
+	cycles := flags.Int("cycles", 3, "number of reconcile passes to measure")
+	latency := flags.Duration("latency", 0, "latency added to every gateway and Prometheus response")
+	verbose := flags.Bool("v", false, "keep the reconcile output, written to stderr")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if *functions <= 0 || *cycles <= 0 || *idleShare < 0 || *idleShare > 1 {
+		fmt.Fprintf(os.Stderr, "bench: -functions and -cycles must be positive and -idle between 0 and 1\n")
+		return 1
+	}
+
+	fleet := newBenchFleet(*functions, *idleShare, *latency)
+	gatewayServer := httptest.NewServer(http.HandlerFunc(fleet.gateway))
+	defer gatewayServer.Close()
+	prometheusServer := httptest.NewServer(http.HandlerFunc(fleet.prometheus))
+	defer prometheusServer.Close()
+
+	config, err := benchConfig(gatewayServer.URL, prometheusServer.URL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bench: %s\n", err)
+		return 1
+	}
+
+	defer quietOutput(*verbose)()
+
+	gateway := newGateway(config)
+	result := benchResult{
+		Functions: *functions,
+		Idle:      int(float64(*functions) * *idleShare),
+	}
+
+	for i := 0; i < *cycles; i++ {
+		atomic.StoreInt64(&fleet.gatewayRequests, 0)
+		atomic.StoreInt64(&fleet.prometheusRequests, 0)
+		atomic.StoreInt64(&fleet.scaleRequests, 0)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+
+		start := time.Now()
+		summary := reconcile(context.Background(), gateway, fullSweep)
+		background.Wait()
+		duration := time.Since(start)
+
+		runtime.ReadMemStats(&after)
+
+		result.Cycles = append(result.Cycles, benchCycle{
+			DurationSeconds:    duration.Seconds(),
+			Allocs:             after.Mallocs - before.Mallocs,
+			AllocBytes:         after.TotalAlloc - before.TotalAlloc,
+			GatewayRequests:    atomic.LoadInt64(&fleet.gatewayRequests),
+			PrometheusRequests: atomic.LoadInt64(&fleet.prometheusRequests),
+			ScaleRequests:      atomic.LoadInt64(&fleet.scaleRequests),
+			Idled:              summary.Idled,
+			Failed:             summary.failures(),
+		})
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		fmt.Fprintf(os.Stderr, "bench: unable to write result: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// quietOutput discards the reconcile output, or sends it to stderr when
+// verbose, and returns a func restoring it
+func quietOutput(verbose bool) func() {
+	stdout := os.Stdout
+	var devNull *os.File
+
+	if verbose {
+		os.Stdout = os.Stderr
+	} else {
+		log.SetOutput(ioutil.Discard)
+		if file, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+			devNull = file
+			os.Stdout = devNull
+		}
+	}
+
+	return func() {
+		os.Stdout = stdout
+		log.SetOutput(os.Stderr)
+		if devNull != nil {
+			devNull.Close()
+		}
+	}
+}
+
+// benchConfig reads the configuration from the environment, so the bench
+// uses the same tuning as the idler, pointed at the synthetic fleet and
+// without anything reaching outside of it
+func benchConfig(gatewayURL string, prometheusURL string) (types.Config, error) {
+	host, portVal, err := net.SplitHostPort(strings.TrimPrefix(prometheusURL, "http://"))
+	if err != nil {
+		return types.Config{}, err
+	}
+
+	env := map[string]string{
+		"gateway_url":     gatewayURL + "/",
+		"prometheus_host": host,
+		"prometheus_port": portVal,
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+	for _, name := range []string{"fallback_gateway_url", "gateways_file", "hooks_file", "notify_routes_file", "notify_webhook_url", "slack_token", "smtp_host", "policy_git_url", "activity_snapshot_file"} {
+		os.Unsetenv(name)
+	}
+
+	config, err := types.ReadConfig()
+	if err != nil {
+		return config, err
+	}
+	config.Name = "bench"
+	return config, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func Test_runBench(t *testing.T) {
+	out := &bytes.Buffer{}
+	if code := runBench([]string{"-functions", "20", "-idle", "0.25", "-cycles", "2"}, out); code != 0 {
+		t.Fatalf("Exit code wanted: %d got: %d", 0, code)
+	}
+
+	result := benchResult{}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("Unable to parse result: %s", err)
+	}
+
+	if len(result.Cycles) != 2 {
+		t.Fatalf("Cycles wanted: %d got: %d", 2, len(result.Cycles))
+	}
+	// Batched activity, uptime and history, then recheck_window for each
+	// function scaled
+	if first := result.Cycles[0]; first.Idled != 5 || first.ScaleRequests != 5 || first.PrometheusRequests != 8 {
+		t.Errorf("Unexpected first cycle: %+v", first)
+	}
+	if second := result.Cycles[1]; second.Idled != 0 || second.ScaleRequests != 0 {
+		t.Errorf("Idle functions should already be at zero in the second cycle: %+v", second)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// cachedProvider reuses the activity of a function over a window for
+// query_cache_ttl, so that passes run more often than the windows change
+// don't query the backend for the same results every time
+type cachedProvider struct {
+	mu      sync.Mutex
+	results *lruCache
+	next    MetricsProvider
+}
+
+// cachedActivity is the result of a query, including one without data
+type cachedActivity struct {
+	rate  float64
+	found bool
+}
+
+func newCachedProvider(next MetricsProvider, maxEntries int, ttl time.Duration) *cachedProvider {
+	return &cachedProvider{
+		results: newLRUCache(maxEntries, ttl),
+		next:    next,
+	}
+}
+
+func cacheKey(function string, window time.Duration) string {
+	return function + "/" + window.String()
+}
+
+func (p *cachedProvider) get(function string, window time.Duration) (cachedActivity, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	value, exists := p.results.get(cacheKey(function, window))
+	if !exists {
+		return cachedActivity{}, false
+	}
+	return value.(cachedActivity), true
+}
+
+func (p *cachedProvider) set(function string, window time.Duration, activity cachedActivity) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.results.set(cacheKey(function, window), activity)
+}
+
+func (p *cachedProvider) Activity(ctx context.Context, function string, window time.Duration) (float64, bool, error) {
+	if activity, exists := p.get(function, window); exists {
+		return activity.rate, activity.found, nil
+	}
+
+	rate, found, err := p.next.Activity(ctx, function, window)
+	if err != nil {
+		return 0, false, err
+	}
+	p.set(function, window, cachedActivity{rate: rate, found: found})
+	return rate, found, nil
+}
+
+// ActivityBatch only queries the functions without a cached result, it is
+// not batched when the provider it wraps does not batch
+func (p *cachedProvider) ActivityBatch(ctx context.Context, functions []string, window time.Duration) (map[string]float64, bool, error) {
+	batcher, ok := p.next.(BatchMetricsProvider)
+	if !ok {
+		return nil, false, nil
+	}
+
+	rates := make(map[string]float64)
+	missing := []string{}
+	for _, function := range functions {
+		activity, exists := p.get(function, window)
+		switch {
+		case !exists:
+			missing = append(missing, function)
+		case activity.found:
+			rates[function] = activity.rate
+		}
+	}
+	if len(missing) == 0 {
+		return rates, true, nil
+	}
+
+	queried, ok, err := batcher.ActivityBatch(ctx, missing, window)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	for _, function := range missing {
+		rate, found := queried[function]
+		p.set(function, window, cachedActivity{rate: rate, found: found})
+		if found {
+			rates[function] = rate
+		}
+	}
+	return rates, true, nil
+}
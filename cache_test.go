@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_cachedProvider(t *testing.T) {
+	next := &fakeBatchProvider{fakeProvider: fakeProvider{
+		rates:   map[string]float64{"figlet": 0.5, "nodeinfo": 0},
+		windows: map[string]time.Duration{},
+	}}
+	provider := newCachedProvider(next, 100, time.Minute)
+	now := time.Now()
+	provider.results.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		rates, ok, err := provider.ActivityBatch(context.Background(), []string{"figlet", "nodeinfo", "missing"}, time.Minute*5)
+		if err != nil || !ok {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(rates) != 2 || rates["figlet"] != 0.5 {
+			t.Errorf("Unexpected rates: %v", rates)
+		}
+	}
+	if next.batches != 1 {
+		t.Errorf("Batches wanted: 1 got: %d", next.batches)
+	}
+
+	if _, found, _ := provider.Activity(context.Background(), "missing", time.Minute*5); found {
+		t.Errorf("A function without data should stay without data")
+	}
+	if _, exists := next.windows["missing"]; exists {
+		t.Errorf("A cached result should not be queried again")
+	}
+
+	// Another window is another query
+	provider.Activity(context.Background(), "figlet", time.Minute*10)
+	if next.windows["figlet"] != time.Minute*10 {
+		t.Errorf("A different window should be queried")
+	}
+
+	now = now.Add(time.Minute * 2)
+	provider.ActivityBatch(context.Background(), []string{"figlet", "nodeinfo"}, time.Minute*5)
+	if next.batches != 2 {
+		t.Errorf("Results older than the ttl should be queried again, batches: %d", next.batches)
+	}
+}
+
+func Test_cachedProvider_errors(t *testing.T) {
+	next := &fakeProvider{rates: map[string]float64{}, windows: map[string]time.Duration{}}
+	provider := newCachedProvider(next, 100, time.Minute)
+
+	if _, ok, _ := provider.ActivityBatch(context.Background(), []string{"figlet"}, time.Minute); ok {
+		t.Errorf("A provider which does not batch should not be batched")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := provider.Activity(context.Background(), "broken", time.Minute); err == nil {
+			t.Errorf("Failed queries should not be cached")
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Modes of a calendar entry
+const (
+	// calendarHoliday idles aggressively, ignoring no_idle_schedule
+	calendarHoliday = "holiday"
+	// calendarBlackout scales nothing down, i.e. for a product launch
+	calendarBlackout = "blackout"
+)
+
+// calendarEntry is a holiday or blackout from Start until End, excluded
+type calendarEntry struct {
+	Name  string
+	Mode  string
+	Start time.Time
+	End   time.Time
+}
+
+// calendarEntryJSON is an entry of a JSON calendar_file. Start and End are
+// dates, End being the last day of the entry, or RFC3339 times.
+type calendarEntryJSON struct {
+	Name  string `json:"name"`
+	Mode  string `json:"mode"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// idleCalendar holds the entries of calendar_file
+type idleCalendar struct {
+	entries []calendarEntry
+}
+
+// parseIdleCalendar reads calendar_file, an iCal calendar when its name ends
+// with .ics or else a JSON list of entries. Dates without a time zone are in
+// the given one, or local time. An empty path returns nil.
+func parseIdleCalendar(path string, timezone string) (*idleCalendar, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	location := time.Local
+	if len(timezone) > 0 {
+		loaded, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("calendar_timezone: %s", err)
+		}
+		location = loaded
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("calendar_file: %s", err)
+	}
+
+	var entries []calendarEntry
+	if strings.HasSuffix(strings.ToLower(path), ".ics") {
+		entries, err = parseICal(string(data), location)
+	} else {
+		entries, err = parseJSONCalendar(data, location)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("calendar_file: unable to parse %s: %s", path, err)
+	}
+	return &idleCalendar{entries: entries}, nil
+}
+
+func parseJSONCalendar(data []byte, location *time.Location) ([]calendarEntry, error) {
+	items := []calendarEntryJSON{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+
+	entries := []calendarEntry{}
+	for i, item := range items {
+		if item.Mode != calendarHoliday && item.Mode != calendarBlackout {
+			return nil, fmt.Errorf("entry %d: mode must be %s or %s, got: %q", i, calendarHoliday, calendarBlackout, item.Mode)
+		}
+
+		start, startDate, err := parseCalendarTime(item.Start, location)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: start: %s", i, err)
+		}
+
+		end := start
+		endDate := startDate
+		if len(item.End) > 0 {
+			if end, endDate, err = parseCalendarTime(item.End, location); err != nil {
+				return nil, fmt.Errorf("entry %d: end: %s", i, err)
+			}
+		}
+		// The end of a date range is its last day
+		if endDate {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !end.After(start) {
+			return nil, fmt.Errorf("entry %d: end must be after start", i)
+		}
+
+		entries = append(entries, calendarEntry{Name: item.Name, Mode: item.Mode, Start: start, End: end})
+	}
+	return entries, nil
+}
+
+// parseCalendarTime reads a date, 2006-01-02, or an RFC3339 time and
+// reports whether it was a date
+func parseCalendarTime(val string, location *time.Location) (time.Time, bool, error) {
+	if date, err := time.ParseInLocation("2006-01-02", val, location); err == nil {
+		return date, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	return t, false, err
+}
+
+// parseICal reads the VEVENTs of an iCal calendar, i.e. a public holidays
+// export. Events are holidays unless their CATEGORIES include blackout, and
+// last the day of their start without a DTEND. Recurrence rules are not
+// expanded.
+func parseICal(data string, location *time.Location) ([]calendarEntry, error) {
+	// Long lines are folded onto lines starting with a space or a tab
+	data = strings.Replace(data, "\r\n", "\n", -1)
+	data = strings.Replace(strings.Replace(data, "\n ", "", -1), "\n\t", "", -1)
+
+	entries := []calendarEntry{}
+	var event *calendarEntry
+	var endSet bool
+	for n, line := range strings.Split(data, "\n") {
+		name, params, value := splitICalLine(line)
+
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event = &calendarEntry{Mode: calendarHoliday}
+			endSet = false
+		case event == nil:
+		case name == "SUMMARY":
+			event.Name = value
+		case name == "CATEGORIES":
+			for _, category := range strings.Split(value, ",") {
+				if strings.EqualFold(strings.TrimSpace(category), calendarBlackout) {
+					event.Mode = calendarBlackout
+				}
+			}
+		case name == "DTSTART" || name == "DTEND":
+			t, err := parseICalTime(params, value, location)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %s", n+1, name, err)
+			}
+			if name == "DTSTART" {
+				event.Start = t
+			} else {
+				event.End = t
+				endSet = true
+			}
+		case name == "END" && value == "VEVENT":
+			if event.Start.IsZero() {
+				return nil, fmt.Errorf("line %d: event %q has no DTSTART", n+1, event.Name)
+			}
+			if !endSet {
+				event.End = event.Start.AddDate(0, 0, 1)
+			}
+			entries = append(entries, *event)
+			event = nil
+		}
+	}
+	return entries, nil
+}
+
+// splitICalLine splits NAME;PARAM=VALUE:value
+func splitICalLine(line string) (string, string, string) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return "", "", ""
+	}
+
+	name, params := line[:colon], ""
+	if semicolon := strings.Index(name, ";"); semicolon >= 0 {
+		name, params = name[:semicolon], name[semicolon+1:]
+	}
+	return strings.ToUpper(name), params, strings.TrimSpace(line[colon+1:])
+}
+
+// parseICalTime reads a date, 20061225, a UTC time, 20061225T150405Z, or a
+// local time in its TZID or else the calendar's time zone
+func parseICalTime(params string, value string, location *time.Location) (time.Time, error) {
+	for _, param := range strings.Split(params, ";") {
+		if strings.HasPrefix(param, "TZID=") {
+			loaded, err := time.LoadLocation(strings.Trim(param[len("TZID="):], `"`))
+			if err != nil {
+				return time.Time{}, err
+			}
+			location = loaded
+		}
+	}
+
+	switch {
+	case len(value) == len("20060102"):
+		return time.ParseInLocation("20060102", value, location)
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	default:
+		return time.ParseInLocation("20060102T150405", value, location)
+	}
+}
+
+// at returns the entry in effect at the given time, blackouts first
+func (c *idleCalendar) at(t time.Time) (calendarEntry, bool) {
+	if c == nil {
+		return calendarEntry{}, false
+	}
+
+	found := false
+	var current calendarEntry
+	for _, entry := range c.entries {
+		if t.Before(entry.Start) || !t.Before(entry.End) {
+			continue
+		}
+		if !found || entry.Mode == calendarBlackout {
+			current, found = entry, true
+		}
+	}
+	return current, found
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCalendar(t *testing.T, name string, data string) string {
+	dir, err := ioutil.TempDir("", "calendar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_idleCalendar_json(t *testing.T) {
+	path := writeCalendar(t, "calendar.json", `[
+		{"name": "Christmas", "mode": "holiday", "start": "2026-12-24", "end": "2026-12-26"},
+		{"name": "Launch", "mode": "blackout", "start": "2026-12-26T06:00:00Z", "end": "2026-12-27T18:00:00Z"},
+		{"name": "New year", "mode": "holiday", "start": "2027-01-01"}
+	]`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	calendar, err := parseIdleCalendar(path, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		at   time.Time
+		want string
+	}{
+		{"before", time.Date(2026, time.December, 23, 23, 59, 0, 0, time.UTC), ""},
+		{"first day", time.Date(2026, time.December, 24, 0, 0, 0, 0, time.UTC), "Christmas"},
+		{"last day", time.Date(2026, time.December, 26, 5, 0, 0, 0, time.UTC), "Christmas"},
+		{"blackout wins", time.Date(2026, time.December, 26, 7, 0, 0, 0, time.UTC), "Launch"},
+		{"end is excluded", time.Date(2026, time.December, 27, 18, 0, 0, 0, time.UTC), ""},
+		{"single day", time.Date(2027, time.January, 1, 23, 0, 0, 0, time.UTC), "New year"},
+		{"after", time.Date(2027, time.January, 2, 0, 0, 0, 0, time.UTC), ""},
+	}
+	for _, c := range cases {
+		if entry, _ := calendar.at(c.at); entry.Name != c.want {
+			t.Errorf("%s: entry wanted: %q got: %q", c.name, c.want, entry.Name)
+		}
+	}
+
+	var none *idleCalendar
+	if _, found := none.at(time.Now()); found {
+		t.Errorf("An empty calendar_file should have no entries")
+	}
+
+	for _, data := range []string{
+		`[{"name": "x", "mode": "party", "start": "2026-12-24"}]`,
+		`[{"name": "x", "mode": "holiday", "start": "24/12/2026"}]`,
+		`[{"name": "x", "mode": "holiday", "start": "2026-12-24", "end": "2026-12-23"}]`,
+	} {
+		invalid := writeCalendar(t, "calendar.json", data)
+		if _, err := parseIdleCalendar(invalid, ""); err == nil {
+			t.Errorf("Had to have errors due to calendar_file: %s", data)
+		}
+		os.RemoveAll(filepath.Dir(invalid))
+	}
+}
+
+func Test_idleCalendar_ical(t *testing.T) {
+	path := writeCalendar(t, "holidays.ics", "BEGIN:VCALENDAR\r\n"+
+		"VERSION:2.0\r\n"+
+		"BEGIN:VEVENT\r\n"+
+		"DTSTART;VALUE=DATE:20261225\r\n"+
+		"DTEND;VALUE=DATE:20261227\r\n"+
+		"SUMMARY:Christmas \r\n"+
+		" holidays\r\n"+
+		"END:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\n"+
+		"DTSTART;TZID=America/New_York:20261103T090000\r\n"+
+		"DTEND;TZID=America/New_York:20261103T170000\r\n"+
+		"SUMMARY:Launch\r\n"+
+		"CATEGORIES:release,Blackout\r\n"+
+		"END:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\n"+
+		"DTSTART:20270101T000000Z\r\n"+
+		"SUMMARY:New year\r\n"+
+		"END:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	calendar, err := parseIdleCalendar(path, "Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		at   time.Time
+		want string
+		mode string
+	}{
+		{"folded summary", time.Date(2026, time.December, 26, 12, 0, 0, 0, time.UTC), "Christmas holidays", calendarHoliday},
+		{"DTEND is excluded", time.Date(2026, time.December, 27, 0, 0, 0, 0, time.UTC), "", ""},
+		// 09:00 in New York is 14:00 in UTC
+		{"TZID", time.Date(2026, time.November, 3, 14, 30, 0, 0, time.UTC), "Launch", calendarBlackout},
+		{"before TZID", time.Date(2026, time.November, 3, 13, 30, 0, 0, time.UTC), "", ""},
+		{"without DTEND", time.Date(2027, time.January, 1, 20, 0, 0, 0, time.UTC), "New year", calendarHoliday},
+	}
+	for _, c := range cases {
+		if entry, _ := calendar.at(c.at); entry.Name != c.want || entry.Mode != c.mode {
+			t.Errorf("%s: entry wanted: %q %q got: %q %q", c.name, c.want, c.mode, entry.Name, entry.Mode)
+		}
+	}
+}
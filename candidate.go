@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// candidateStore holds the functions marked as idle candidates with
+// confirm_scale_down, which are only scaled when still idle on the next pass
+type candidateStore struct {
+	mu     sync.Mutex
+	marked *lruCache
+}
+
+// candidate is a function marked idle, as listed by /api/candidates
+type candidate struct {
+	Gateway  string    `json:"gateway"`
+	Function string    `json:"function"`
+	MarkedAt time.Time `json:"marked_at"`
+}
+
+func newCandidateStore(maxEntries int, ttl time.Duration) *candidateStore {
+	return &candidateStore{
+		marked: newLRUCache(maxEntries, ttl),
+	}
+}
+
+// take forgets the mark of a function at the start of its evaluation and
+// returns when it was marked. A function found idle again is marked anew or
+// confirmed, anything else leaves it unmarked.
+func (c *candidateStore) take(name string) (candidate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, exists := c.marked.get(name)
+	if !exists {
+		return candidate{}, false
+	}
+	c.marked.remove(name)
+	return value.(candidate), true
+}
+
+// mark keeps a function as an idle candidate
+func (c *candidateStore) mark(mark candidate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.marked.set(mark.Function, mark)
+}
+
+// retain drops functions which were not listed by the gateway in the last
+// reconcile pass
+func (c *candidateStore) retain(names map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.marked.retain(names)
+}
+
+// list returns the functions marked, by name
+func (c *candidateStore) list() []candidate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list := []candidate{}
+	c.marked.each(func(key string, value interface{}) {
+		list = append(list, value.(candidate))
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Function < list[j].Function
+	})
+	return list
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_candidateStore(t *testing.T) {
+	candidates := newCandidateStore(0, 0)
+	start := time.Now()
+
+	if _, marked := candidates.take("figlet"); marked {
+		t.Errorf("A function should not be marked before being found idle")
+	}
+
+	candidates.mark(candidate{Gateway: "test", Function: "figlet", MarkedAt: start})
+	candidates.mark(candidate{Gateway: "test", Function: "env", MarkedAt: start})
+	if list := candidates.list(); len(list) != 2 || list[0].Function != "env" || list[1].Function != "figlet" {
+		t.Errorf("Candidates wanted by name, got: %+v", list)
+	}
+
+	mark, marked := candidates.take("figlet")
+	if !marked || !mark.MarkedAt.Equal(start) {
+		t.Errorf("A marked function should be taken with its mark, got: %+v %v", mark, marked)
+	}
+	if _, marked = candidates.take("figlet"); marked {
+		t.Errorf("A function taken and not marked again should be unmarked")
+	}
+
+	candidates.retain(map[string]bool{})
+	if candidates.marked.len() != 0 {
+		t.Errorf("Retain should drop unlisted functions, got: %v", candidates.marked)
+	}
+}
+
+func Test_makeCandidatesHandler(t *testing.T) {
+	gateway := newGateway(types.Config{Name: "test"})
+	gateway.candidates.mark(candidate{Gateway: "test", Function: "figlet", MarkedAt: time.Now()})
+	handler := makeCandidatesHandler([]*Gateway{gateway})
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/api/candidates", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"function":"figlet"`) {
+		t.Errorf("Unexpected response: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/api/candidates?gateway=other", nil))
+	if strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("Candidates of other gateways should be filtered out, got: %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Faults injected by chaos
+const (
+	faultStatus    = "5xx"
+	faultTimeout   = "timeout"
+	faultTruncated = "truncated"
+	faultGap       = "gap"
+)
+
+// faultInjector fails a share of the requests to the synthetic fleet
+type faultInjector struct {
+	mu     sync.Mutex
+	random *rand.Rand
+	rate   float64
+	delay  time.Duration
+	counts map[string]int
+}
+
+func newFaultInjector(seed int64, rate float64, delay time.Duration) *faultInjector {
+	return &faultInjector{
+		random: rand.New(rand.NewSource(seed)),
+		rate:   rate,
+		delay:  delay,
+		counts: make(map[string]int),
+	}
+}
+
+// chance reports true with the given probability
+func (c *faultInjector) chance(probability float64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.random.Float64() < probability
+}
+
+func (c *faultInjector) intn(n int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.random.Intn(n)
+}
+
+// pick returns the fault to inject into a request, if any
+func (c *faultInjector) pick(kinds []string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.random.Float64() >= c.rate {
+		return ""
+	}
+
+	kind := kinds[c.random.Intn(len(kinds))]
+	c.counts[kind]++
+	return kind
+}
+
+// wrap injects one of the kinds of faults into requests served by next:
+// an error status, a response slower than the idler's timeouts, a body cut
+// in half or, for Prometheus, a query returning no data
+func (c *faultInjector) wrap(next http.HandlerFunc, kinds []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch c.pick(kinds) {
+		case faultStatus:
+			statuses := []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable}
+			w.WriteHeader(statuses[c.intn(len(statuses))])
+		case faultTimeout:
+			time.Sleep(c.delay)
+			next(w, r)
+		case faultTruncated:
+			recorder := httptest.NewRecorder()
+			next(recorder, r)
+			body := recorder.Body.Bytes()
+			w.WriteHeader(recorder.Code)
+			w.Write(body[:len(body)/2])
+		case faultGap:
+			w.Write([]byte(`{"status": "success", "data": {"resultType": "vector", "result": []}}`))
+		default:
+			next(w, r)
+		}
+	}
+}
+
+// flip changes a share of the functions between idle and active, an active
+// function at zero is woken as by its traffic
+func (f *benchFleet) flip(c *faultInjector, probability float64) {
+	for _, name := range f.names {
+		if !c.chance(probability) {
+			continue
+		}
+
+		f.mu.Lock()
+		f.idle[name] = !f.idle[name]
+		if !f.idle[name] && f.replicas[name] == 0 {
+			f.replicas[name] = 1
+		}
+		f.mu.Unlock()
+	}
+}
+
+type chaosResult struct {
+	Seed          int64          `json:"seed"`
+	Functions     int            `json:"functions"`
+	Cycles        int            `json:"cycles"`
+	Restarts      int            `json:"prometheus_restarts"`
+	Faults        map[string]int `json:"faults"`
+	ScaleRequests int64          `json:"scale_requests"`
+	Idled         int            `json:"idled"`
+	Failed        int            `json:"failed"`
+	Unsafe        []string       `json:"unsafe"`
+}
+
+// runChaos reconciles a synthetic fleet while injecting gateway and
+// Prometheus faults, and fails should the idler ever try to scale an active
+// function. The result is printed as JSON, -seed replays a run.
+func runChaos(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("chaos", flag.ContinueOnError)
+	functions := flags.Int("functions", 100, "number of synthetic functions")
+	cycles := flags.Int("cycles", 10, "number of reconcile passes")
+	faultRate := flags.Float64("fault-rate", 0.2, "share of requests failed, between 0 and 1")
+	restartRate := flags.Float64("restart-rate", 0.2, "share of passes during which Prometheus has just restarted")
+	flipRate := flags.Float64("flip-rate", 0.1, "share of functions changing between idle and active each pass")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "seed of the faults injected")
+	verbose := flags.Bool("v", false, "keep the reconcile output, written to stderr")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if *functions <= 0 || *cycles <= 0 || *faultRate < 0 || *faultRate > 1 {
+		fmt.Fprintf(os.Stderr, "chaos: -functions and -cycles must be positive and -fault-rate between 0 and 1\n")
+		return 1
+	}
+
+	// Timeouts are kept short so that slow responses exceed them quickly
+	timeout := time.Millisecond * 100
+	faults := newFaultInjector(*seed, *faultRate, timeout*3/2)
+
+	fleet := newBenchFleet(*functions, 0.5, 0)
+	gateway := func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/system/scale-function/") {
+			fleet.scaleAttempted(strings.TrimPrefix(r.URL.Path, "/system/scale-function/"))
+		}
+		faults.wrap(fleet.gateway, []string{faultStatus, faultTimeout, faultTruncated})(w, r)
+	}
+	gatewayServer := httptest.NewServer(http.HandlerFunc(gateway))
+	defer gatewayServer.Close()
+	prometheusServer := httptest.NewServer(faults.wrap(fleet.prometheus, []string{faultStatus, faultTimeout, faultTruncated, faultGap}))
+	defer prometheusServer.Close()
+
+	config, err := benchConfig(gatewayServer.URL, prometheusServer.URL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "chaos: %s\n", err)
+		return 1
+	}
+	config.Name = "chaos"
+	config.ListFunctionsTimeout = timeout
+	config.GetReplicasTimeout = timeout
+	config.ScaleTimeout = timeout
+	config.PrometheusTimeout = timeout
+	config.ScaleRetryBackoff = time.Millisecond * 10
+
+	defer quietOutput(*verbose)()
+
+	idler := newGateway(config)
+	result := chaosResult{
+		Seed:      *seed,
+		Functions: *functions,
+		Cycles:    *cycles,
+	}
+
+	for i := 0; i < *cycles; i++ {
+		restarted := faults.chance(*restartRate)
+		if restarted {
+			result.Restarts++
+		}
+		fleet.mu.Lock()
+		fleet.restarted = restarted
+		fleet.mu.Unlock()
+
+		summary := reconcile(context.Background(), idler, fullSweep)
+		background.Wait()
+
+		result.Idled += summary.Idled
+		result.Failed += summary.failures()
+		fleet.flip(faults, *flipRate)
+	}
+
+	fleet.mu.Lock()
+	result.Unsafe = append([]string{}, fleet.unsafe...)
+	fleet.mu.Unlock()
+	faults.mu.Lock()
+	result.Faults = faults.counts
+	faults.mu.Unlock()
+	result.ScaleRequests = atomic.LoadInt64(&fleet.scaleRequests)
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		fmt.Fprintf(os.Stderr, "chaos: unable to write result: %s\n", err)
+		return 1
+	}
+
+	if len(result.Unsafe) > 0 {
+		return 1
+	}
+	return 0
+}
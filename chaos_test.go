@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func Test_runChaos(t *testing.T) {
+	out := &bytes.Buffer{}
+	code := runChaos([]string{"-functions", "30", "-cycles", "6", "-seed", "1", "-restart-rate", "0.5"}, out)
+
+	result := chaosResult{}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("Unable to parse result: %s", err)
+	}
+
+	if code != 0 || len(result.Unsafe) > 0 {
+		t.Errorf("Active functions were scaled: %v", result.Unsafe)
+	}
+	if len(result.Faults) == 0 || result.Restarts == 0 {
+		t.Errorf("Faults should be injected: %+v", result)
+	}
+	if result.Idled == 0 {
+		t.Errorf("Idle functions should still be scaled despite the faults")
+	}
+}
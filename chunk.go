@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/types"
+)
+
+// invocationChunk is one slice of a long window, the invocations in the
+// range ending offset before now
+type invocationChunk struct {
+	length time.Duration
+	offset time.Duration
+}
+
+// chunkWindow splits a window into chunks of at most size, the most recent
+// first, so that an active function is found by the first query
+func chunkWindow(window time.Duration, size time.Duration) []invocationChunk {
+	chunks := []invocationChunk{}
+	for offset := time.Duration(0); offset < window; offset += size {
+		length := size
+		if offset+length > window {
+			length = window - offset
+		}
+		chunks = append(chunks, invocationChunk{length: length, offset: offset})
+	}
+	return chunks
+}
+
+// activityQuery is the invocation rate of a function over a window, by
+// status code
+func activityQuery(name string, window time.Duration, config types.Config) string {
+	if config.UseRecordingRules {
+		return recordedActivityQuery(name, window, config)
+	}
+	return `sum(rate(gateway_function_invocation_total{function_name="` + name + `", code=~".*"}` + rangeSelector(window, 0, config) + `)) by (code, function_name)`
+}
+
+// activityTemplateData is what prometheus_query_template is executed with
+type activityTemplateData struct {
+	FunctionName string
+	// Duration is the window, i.e. 5m, and Range the selector for it with
+	// query_resolution and query_offset applied, i.e. [5m:30s] offset 1m
+	Duration string
+	Range    string
+}
+
+// templatedActivityQuery renders prometheus_query_template for a function
+func templatedActivityQuery(name string, window time.Duration, config types.Config) (string, error) {
+	query := bytes.Buffer{}
+	err := config.PrometheusQueryTemplate.Execute(&query, activityTemplateData{
+		FunctionName: name,
+		Duration:     promDuration(window),
+		Range:        rangeSelector(window, 0, config),
+	})
+	return query.String(), err
+}
+
+// batchActivityQuery is the invocation rate over a window of every function,
+// by function name
+func batchActivityQuery(window time.Duration, config types.Config) string {
+	if config.UseRecordingRules {
+		return recordedBatchQuery(window, config)
+	}
+	return `sum(rate(gateway_function_invocation_total{code=~".*"}` + rangeSelector(window, 0, config) + `)) by (function_name)`
+}
+
+func chunkQuery(name string, chunk invocationChunk, config types.Config) string {
+	return `sum(increase(gateway_function_invocation_total{function_name="` + name + `", code=~".*"}` + rangeSelector(chunk.length, chunk.offset, config) + `))`
+}
+
+// rangeSelector selects a window ending offset before now, shifted back by
+// query_offset and sampled at query_resolution when set
+func rangeSelector(window time.Duration, offset time.Duration, config types.Config) string {
+	selector := `[` + promDuration(window)
+	if config.QueryResolution > 0 {
+		selector += `:` + promDuration(config.QueryResolution)
+	}
+	selector += `]`
+
+	if offset += config.QueryOffset; offset > 0 {
+		selector += ` offset ` + promDuration(offset)
+	}
+	return selector
+}
+
+// queryChunkedRate evaluates a function's invocation rate over a long window
+// as the invocations in each of its chunks, which Prometheus accepts where a
+// single rate over the whole window may be rejected as too expensive. It
+// stops at the first chunk with any invocations, the rate then only covers
+// the chunks queried.
+func queryChunkedRate(ctx context.Context, client *http.Client, name string, window time.Duration, config types.Config) (float64, bool, error) {
+	invocations, covered, found := float64(0), time.Duration(0), false
+	for _, chunk := range chunkWindow(window, config.QueryChunk) {
+		increase, chunkFound, err := queryValue(ctx, client, chunkQuery(name, chunk, config), config)
+		if err != nil {
+			return 0, false, err
+		}
+
+		covered += chunk.length
+		if chunkFound {
+			found = true
+			invocations += increase
+		}
+		if invocations > 0 {
+			break
+		}
+	}
+
+	if !found {
+		return 0, false, nil
+	}
+	return invocations / covered.Seconds(), true, nil
+}
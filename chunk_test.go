@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_chunkWindow(t *testing.T) {
+	got := chunkWindow(time.Hour*15, time.Hour*6)
+	want := []invocationChunk{
+		{length: time.Hour * 6, offset: 0},
+		{length: time.Hour * 6, offset: time.Hour * 6},
+		{length: time.Hour * 3, offset: time.Hour * 12},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Chunks wanted: %v got: %v", want, got)
+	}
+}
+
+func Test_chunkQuery(t *testing.T) {
+	cases := []struct {
+		chunk invocationChunk
+		want  string
+	}{
+		{
+			invocationChunk{length: time.Hour * 6},
+			`sum(increase(gateway_function_invocation_total{function_name="figlet", code=~".*"}[360m]))`,
+		},
+		{
+			invocationChunk{length: time.Hour * 6, offset: time.Hour * 6},
+			`sum(increase(gateway_function_invocation_total{function_name="figlet", code=~".*"}[360m] offset 360m))`,
+		},
+	}
+
+	for _, c := range cases {
+		if got := chunkQuery("figlet", c.chunk, types.Config{}); got != c.want {
+			t.Errorf("Query wanted: %s got: %s", c.want, got)
+		}
+	}
+}
+
+func Test_queryChunkedRate_stopsAtActivity(t *testing.T) {
+	queries := 0
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		if strings.Contains(r.URL.Query().Get("query"), "offset 360m") {
+			w.Write([]byte(`{"data": {"result": [{"metric": {}, "value": [1546300800, "216"]}]}}`))
+			return
+		}
+		w.Write([]byte(`{"data": {"result": [{"metric": {}, "value": [1546300800, "0"]}]}}`))
+	}))
+	defer prometheus.Close()
+
+	parsed, _ := url.Parse(prometheus.URL)
+	host, portVal, _ := net.SplitHostPort(parsed.Host)
+	port, _ := strconv.Atoi(portVal)
+
+	config := types.Config{
+		PrometheusHost: host,
+		PrometheusPort: port,
+		QueryChunk:     time.Hour * 6,
+	}
+
+	rate, found, err := queryChunkedRate(context.Background(), &http.Client{}, "figlet", time.Hour*24, config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if queries != 2 {
+		t.Errorf("Queries wanted: %d got: %d", 2, queries)
+	}
+	if want := float64(216) / (time.Hour * 12).Seconds(); !found || rate != want {
+		t.Errorf("Rate wanted: %f got: %f %v", want, rate, found)
+	}
+}
+
+func Test_activityQuery_resolutionAndOffset(t *testing.T) {
+	config := types.Config{QueryResolution: time.Minute, QueryOffset: time.Second * 30}
+
+	want := `sum(rate(gateway_function_invocation_total{function_name="figlet", code=~".*"}[5m:1m] offset 30s)) by (code, function_name)`
+	if got := activityQuery("figlet", time.Minute*5, config); got != want {
+		t.Errorf("Query wanted: %s got: %s", want, got)
+	}
+
+	want = `sum(increase(gateway_function_invocation_total{function_name="figlet", code=~".*"}[360m:1m] offset 21630s))`
+	if got := chunkQuery("figlet", invocationChunk{length: time.Hour * 6, offset: time.Hour * 6}, config); got != want {
+		t.Errorf("Query wanted: %s got: %s", want, got)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/types"
+)
+
+// cloudWatchMaxQueries is the most metrics a GetMetricData call accepts
+const cloudWatchMaxQueries = 500
+
+// cloudWatchProvider reads the gateway's invocation counter forwarded to
+// CloudWatch, i.e. by the CloudWatch agent's Prometheus scraping on EKS or
+// ECS, which publishes counters as the delta between scrapes
+type cloudWatchProvider struct {
+	client      *http.Client
+	config      types.Config
+	credentials *awsCredentialChain
+}
+
+// newCloudWatchProvider ignores the client carrying the Prometheus headers
+func newCloudWatchProvider(config types.Config, _ *http.Client) MetricsProvider {
+	return &cloudWatchProvider{
+		client:      newHeaderClient(config.UserAgent, nil, nil),
+		config:      config,
+		credentials: newAWSCredentialChain(credentialClient(config), config.CloudWatchRegion),
+	}
+}
+
+// cloudWatchResponse is the body of GetMetricData
+type cloudWatchResponse struct {
+	Results []struct {
+		ID         string    `xml:"Id"`
+		Values     []float64 `xml:"Values>member"`
+		StatusCode string    `xml:"StatusCode"`
+	} `xml:"GetMetricDataResult>MetricDataResults>member"`
+	Messages []struct {
+		Code  string `xml:"Code"`
+		Value string `xml:"Value"`
+	} `xml:"GetMetricDataResult>Messages>member"`
+	NextToken string `xml:"GetMetricDataResult>NextToken"`
+}
+
+func (p *cloudWatchProvider) Activity(ctx context.Context, function string, window time.Duration) (float64, bool, error) {
+	rates, err := p.rates(ctx, []string{function}, window)
+	if err != nil {
+		return 0, false, err
+	}
+	rate, found := rates[function]
+	return rate, found, nil
+}
+
+func (p *cloudWatchProvider) ActivityBatch(ctx context.Context, functions []string, window time.Duration) (map[string]float64, bool, error) {
+	rates := make(map[string]float64)
+	for start := 0; start < len(functions); start += cloudWatchMaxQueries {
+		end := start + cloudWatchMaxQueries
+		if end > len(functions) {
+			end = len(functions)
+		}
+
+		chunk, err := p.rates(ctx, functions[start:end], window)
+		if err != nil {
+			return nil, true, err
+		}
+		for name, rate := range chunk {
+			rates[name] = rate
+		}
+	}
+	return rates, true, nil
+}
+
+// cloudWatchForm is a GetMetricData call summing the counter of each function
+// over the window in a single period, a multiple of a minute
+func cloudWatchForm(functions []string, window time.Duration, now time.Time, config types.Config) map[string]string {
+	end := now.Add(-config.QueryOffset).UTC()
+	period := int64(math.Ceil(window.Minutes())) * 60
+
+	form := map[string]string{
+		"Action":    "GetMetricData",
+		"Version":   "2010-08-01",
+		"StartTime": end.Add(-window).Format(time.RFC3339),
+		"EndTime":   end.Format(time.RFC3339),
+	}
+
+	dimensions := []string{}
+	for name := range config.CloudWatchDimensions {
+		dimensions = append(dimensions, name)
+	}
+	sort.Strings(dimensions)
+
+	for i, function := range functions {
+		prefix := "MetricDataQueries.member." + strconv.Itoa(i+1) + "."
+		form[prefix+"Id"] = "m" + strconv.Itoa(i)
+		form[prefix+"ReturnData"] = "true"
+		form[prefix+"MetricStat.Period"] = strconv.FormatInt(period, 10)
+		form[prefix+"MetricStat.Stat"] = "Sum"
+		form[prefix+"MetricStat.Metric.Namespace"] = config.CloudWatchNamespace
+		form[prefix+"MetricStat.Metric.MetricName"] = config.CloudWatchMetricName
+		form[prefix+"MetricStat.Metric.Dimensions.member.1.Name"] = config.CloudWatchFunctionDimension
+		form[prefix+"MetricStat.Metric.Dimensions.member.1.Value"] = function
+		for j, name := range dimensions {
+			dimension := prefix + "MetricStat.Metric.Dimensions.member." + strconv.Itoa(j+2) + "."
+			form[dimension+"Name"] = name
+			form[dimension+"Value"] = config.CloudWatchDimensions[name]
+		}
+	}
+	return form
+}
+
+// rates returns the invocation rate over the window of the functions which
+// have datapoints, following GetMetricData's pages
+func (p *cloudWatchProvider) rates(ctx context.Context, functions []string, window time.Duration) (map[string]float64, error) {
+	form := cloudWatchForm(functions, window, time.Now(), p.config)
+
+	rates := make(map[string]float64)
+	for {
+		body, err := p.getMetricData(ctx, form)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, result := range body.Results {
+			if result.StatusCode == "Forbidden" || result.StatusCode == "InternalError" {
+				return nil, fmt.Errorf("CloudWatch query failed: %s", result.StatusCode)
+			}
+
+			i, err := strconv.Atoi(strings.TrimPrefix(result.ID, "m"))
+			if err != nil || i < 0 || i >= len(functions) || len(result.Values) == 0 {
+				continue
+			}
+			for _, value := range result.Values {
+				rates[functions[i]] += value / window.Seconds()
+			}
+		}
+
+		if len(body.NextToken) == 0 {
+			return rates, nil
+		}
+		form["NextToken"] = body.NextToken
+	}
+}
+
+func (p *cloudWatchProvider) getMetricData(ctx context.Context, form map[string]string) (*cloudWatchResponse, error) {
+	creds, err := p.credentials.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, len(form))
+	for key, value := range form {
+		values = append(values, awsEscape(key)+"="+awsEscape(value))
+	}
+	sort.Strings(values)
+	payload := []byte(strings.Join(values, "&"))
+
+	req, err := http.NewRequest(http.MethodPost, p.config.CloudWatchEndpoint+"/", strings.NewReader(string(payload)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWSRequest(req, payload, creds, p.config.CloudWatchRegion, "monitoring", time.Now())
+
+	bytesOut, err := doRequest(ctx, boundedClient(ctx, p.client, p.config.PrometheusTimeout), req, "CloudWatch")
+	if err != nil {
+		return nil, err
+	}
+
+	body := cloudWatchResponse{}
+	if err := xml.Unmarshal(bytesOut, &body); err != nil {
+		return nil, fmt.Errorf("Error unmarshaling result: %s, '%s'", err, string(bytesOut))
+	}
+	return &body, nil
+}
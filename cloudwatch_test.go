@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_cloudWatchForm(t *testing.T) {
+	config := types.Config{
+		CloudWatchNamespace:         "ContainerInsights/Prometheus",
+		CloudWatchMetricName:        "gateway_function_invocation_total",
+		CloudWatchFunctionDimension: "function_name",
+		CloudWatchDimensions:        map[string]string{"ClusterName": "prod"},
+		QueryOffset:                 time.Minute,
+	}
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	form := cloudWatchForm([]string{"figlet", "nodeinfo"}, time.Second*90, now, config)
+
+	want := map[string]string{
+		"StartTime":                     "2020-01-01T11:57:30Z",
+		"EndTime":                       "2020-01-01T11:59:00Z",
+		"MetricDataQueries.member.2.Id": "m1",
+		"MetricDataQueries.member.2.MetricStat.Period":                           "120",
+		"MetricDataQueries.member.2.MetricStat.Stat":                             "Sum",
+		"MetricDataQueries.member.2.MetricStat.Metric.Dimensions.member.1.Value": "nodeinfo",
+		"MetricDataQueries.member.2.MetricStat.Metric.Dimensions.member.2.Name":  "ClusterName",
+		"MetricDataQueries.member.2.MetricStat.Metric.Dimensions.member.2.Value": "prod",
+	}
+	for key, value := range want {
+		if form[key] != value {
+			t.Errorf("%s wanted: %q got: %q", key, value, form[key])
+		}
+	}
+}
+
+func Test_cloudWatchProvider(t *testing.T) {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "key")
+	os.Unsetenv("AWS_SESSION_TOKEN")
+
+	calls := 0
+	cloudWatch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/monitoring/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		r.ParseForm()
+		if r.Form.Get("Action") != "GetMetricData" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if len(r.Form.Get("NextToken")) == 0 {
+			w.Write([]byte(`<GetMetricDataResponse><GetMetricDataResult><MetricDataResults>
+<member><Id>m0</Id><Timestamps><member>2020-01-01T11:55:00Z</member></Timestamps><Values><member>60</member></Values><StatusCode>Complete</StatusCode></member>
+</MetricDataResults><NextToken>page2</NextToken></GetMetricDataResult></GetMetricDataResponse>`))
+			return
+		}
+		w.Write([]byte(`<GetMetricDataResponse><GetMetricDataResult><MetricDataResults>
+<member><Id>m1</Id><Timestamps><member>2020-01-01T11:55:00Z</member></Timestamps><Values><member>0</member></Values><StatusCode>Complete</StatusCode></member>
+<member><Id>m2</Id><Timestamps></Timestamps><Values></Values><StatusCode>Complete</StatusCode></member>
+</MetricDataResults></GetMetricDataResult></GetMetricDataResponse>`))
+	}))
+	defer cloudWatch.Close()
+
+	config := types.Config{
+		MetricsProvider:             types.MetricsProviderCloudWatch,
+		CloudWatchRegion:            "eu-west-1",
+		CloudWatchNamespace:         "ContainerInsights/Prometheus",
+		CloudWatchMetricName:        "gateway_function_invocation_total",
+		CloudWatchFunctionDimension: "function_name",
+		CloudWatchEndpoint:          cloudWatch.URL,
+	}
+	provider := newMetricsProvider(config, &http.Client{})
+
+	rates, ok, err := provider.(BatchMetricsProvider).ActivityBatch(context.Background(), []string{"figlet", "nodeinfo", "missing"}, time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rates) != 2 || rates["figlet"] != 1 || rates["nodeinfo"] != 0 {
+		t.Errorf("Rates wanted figlet: 1 nodeinfo: 0 got: %v", rates)
+	}
+	if calls != 2 {
+		t.Errorf("Both pages should be read, got %d calls", calls)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// counterStore follows the invocation count the gateway lists for each
+// function across reconcile passes, so that activity can still be told
+// apart from idleness while the metrics provider is unavailable
+type counterStore struct {
+	mu       sync.Mutex
+	counters *lruCache
+}
+
+// invocationCounter is the last count listed for a function, when it was
+// listed, and when and how fast the count last moved
+type invocationCounter struct {
+	count   float64
+	seen    time.Time
+	changed time.Time
+	rate    float64
+}
+
+func newCounterStore(maxEntries int, ttl time.Duration) *counterStore {
+	return &counterStore{
+		counters: newLRUCache(maxEntries, ttl),
+	}
+}
+
+// observe records the count listed for a function. A function listed for
+// the first time is taken for active now, as its count is not known to have
+// stood still before.
+func (c *counterStore) observe(name string, count float64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, exists := c.counters.get(name)
+	if !exists {
+		c.counters.set(name, invocationCounter{count: count, seen: now, changed: now})
+		return
+	}
+
+	counter := value.(invocationCounter)
+	if count != counter.count {
+		// A count going down was reset, i.e. by a restart of the gateway
+		increase := count - counter.count
+		if increase < 0 {
+			increase = count
+		}
+		if elapsed := now.Sub(counter.seen).Seconds(); elapsed > 0 {
+			counter.rate = increase / elapsed
+		}
+		counter.changed = now
+	}
+	counter.count = count
+	counter.seen = now
+	c.counters.set(name, counter)
+}
+
+// activity returns a function's invocation rate when it moved within the
+// window, or zero once its count stood still for all of it. found is false
+// until either is known, i.e. for a function listed for less than the window.
+func (c *counterStore) activity(name string, window time.Duration, now time.Time) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, exists := c.counters.get(name)
+	if !exists {
+		return 0, false
+	}
+
+	counter := value.(invocationCounter)
+	if now.Sub(counter.changed) >= window {
+		return 0, true
+	}
+	return counter.rate, counter.rate > 0
+}
+
+// retain drops functions which were not listed by the gateway in the last
+// reconcile pass
+func (c *counterStore) retain(names map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counters.retain(names)
+}
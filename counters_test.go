@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_counterStore_activity(t *testing.T) {
+	counters := newCounterStore(0, 0)
+	now := time.Now()
+	window := time.Minute * 5
+
+	if _, found := counters.activity("figlet", window, now); found {
+		t.Errorf("A function not listed yet should have no activity")
+	}
+
+	counters.observe("figlet", 100, now)
+	if _, found := counters.activity("figlet", window, now.Add(time.Minute)); found {
+		t.Errorf("A function listed for less than the window should have no activity")
+	}
+
+	counters.observe("figlet", 160, now.Add(time.Minute))
+	if rate, found := counters.activity("figlet", window, now.Add(time.Minute)); !found || rate != 1 {
+		t.Errorf("Rate wanted: 1 got: %f %t", rate, found)
+	}
+
+	counters.observe("figlet", 160, now.Add(time.Minute*6))
+	if rate, found := counters.activity("figlet", window, now.Add(time.Minute*6)); !found || rate != 0 {
+		t.Errorf("A count standing still for the window should be idle, got: %f %t", rate, found)
+	}
+
+	// A reset of the gateway's counters is activity
+	counters.observe("figlet", 30, now.Add(time.Minute*7))
+	if rate, found := counters.activity("figlet", window, now.Add(time.Minute*7)); !found || rate != 0.5 {
+		t.Errorf("Rate wanted: 0.5 got: %f %t", rate, found)
+	}
+}
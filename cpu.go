@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/types"
+)
+
+// cpuQuery renders cpu_query for a function, $function, $namespace and
+// $window are replaced by its name, namespace and inactivity window
+func cpuQuery(fn Function, window time.Duration, config types.Config) string {
+	query := strings.Replace(config.CPUQuery, "$function", fn.Name, -1)
+	query = strings.Replace(query, "$namespace", fn.Namespace, -1)
+	return strings.Replace(query, "$window", promDuration(window), -1)
+}
+
+// queryCPU returns the cores used by a function over its window, the sum of
+// every series returned by cpu_query. Background work such as processing an
+// async request keeps a function busy without any invocation being counted.
+func queryCPU(ctx context.Context, client *http.Client, fn Function, window time.Duration, config types.Config) (float64, error) {
+	cpu, _, err := queryValue(ctx, client, cpuQuery(fn, window, config), config)
+	return cpu, err
+}
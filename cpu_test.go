@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_cpuQuery(t *testing.T) {
+	config := types.Config{CPUQuery: types.DefaultCPUQuery}
+	fn := Function{Name: "figlet", Namespace: "openfaas-fn"}
+
+	want := `sum(rate(container_cpu_usage_seconds_total{namespace="openfaas-fn", pod=~"figlet-[a-z0-9]+-[a-z0-9]+", container!="", container!="POD"}[5m]))`
+	if got := cpuQuery(fn, time.Minute*5, config); got != want {
+		t.Errorf("Query wanted: %s got: %s", want, got)
+	}
+}
+
+func Test_queryCPU(t *testing.T) {
+	var received string
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.URL.Query().Get("query")
+		w.Write([]byte(`{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1546300800, "0.25"]}]}}`))
+	}))
+	defer prometheus.Close()
+
+	parsed, _ := url.Parse(prometheus.URL)
+	host, portVal, _ := net.SplitHostPort(parsed.Host)
+	port, _ := strconv.Atoi(portVal)
+
+	config := types.Config{PrometheusHost: host, PrometheusPort: port, CPUQuery: `cpu{function="$function"}`}
+	cpu, err := queryCPU(context.Background(), newHeaderClient("", nil, nil), Function{Name: "figlet"}, time.Minute, config)
+	if err != nil || cpu != 0.25 {
+		t.Errorf("Cores wanted: 0.25 got: %f %v", cpu, err)
+	}
+	if received != `cpu{function="figlet"}` {
+		t.Errorf("Unexpected query: %s", received)
+	}
+}
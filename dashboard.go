@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+)
+
+// dashboardHTML is the read-only dashboard served on the admin port. It holds
+// no state of its own and refreshes from /api/status, /api/cycles and
+// /api/history.
+const dashboardHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>faas-idler</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 2em; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9em; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #ddd; }
+th { background: #f4f4f4; }
+.idle { color: #b35900; }
+.active { color: #2a7a2a; }
+.failed { color: #b00020; }
+.muted { color: #888; }
+</style>
+</head>
+<body>
+<h1>faas-idler</h1>
+<p class="muted">Refreshed every 10s, last at <span id="refreshed">-</span></p>
+
+<h2>Cycles</h2>
+<table>
+<thead><tr><th>Gateway</th><th>Started</th><th>Seconds</th><th>Evaluated</th><th>Active</th><th>Idle</th><th>Idled</th><th>Skipped</th><th>Failed</th></tr></thead>
+<tbody id="cycles"></tbody>
+</table>
+
+<h2>Functions</h2>
+<table>
+<thead><tr><th>Gateway</th><th>Function</th><th>Namespace</th><th>Replicas</th><th>Invocations/s</th><th>Status</th><th>Countdown</th><th>Updated</th></tr></thead>
+<tbody id="functions"></tbody>
+</table>
+
+<h2>Recent events</h2>
+<table>
+<thead><tr><th>Time</th><th>Kind</th><th>Gateway</th><th>Function</th><th>Replicas</th><th>Result</th></tr></thead>
+<tbody id="events"></tbody>
+</table>
+
+<script>
+"use strict";
+
+var countdowns = [];
+
+function row(tbody, cells) {
+  var tr = document.createElement("tr");
+  cells.forEach(function (cell) {
+    var td = document.createElement("td");
+    if (typeof cell === "object" && cell !== null) {
+      td.textContent = cell.text;
+      if (cell.className) { td.className = cell.className; }
+      if (cell.until) { countdowns.push({ el: td, until: new Date(cell.until), prefix: cell.text }); }
+    } else {
+      td.textContent = cell === undefined || cell === null ? "" : cell;
+    }
+    tr.appendChild(td);
+  });
+  tbody.appendChild(tr);
+}
+
+function empty(tbody, columns, text) {
+  var tr = document.createElement("tr");
+  var td = document.createElement("td");
+  td.colSpan = columns;
+  td.className = "muted";
+  td.textContent = text;
+  tr.appendChild(td);
+  tbody.appendChild(tr);
+}
+
+function counts(m) {
+  return Object.keys(m || {}).sort().map(function (k) { return k + " " + m[k]; }).join(", ");
+}
+
+function time(t) {
+  return t ? new Date(t).toLocaleString() : "";
+}
+
+function remaining(until) {
+  var s = Math.max(0, Math.round((until - Date.now()) / 1000));
+  var h = Math.floor(s / 3600), m = Math.floor(s % 3600 / 60);
+  return (h > 0 ? h + "h " : "") + (h > 0 || m > 0 ? m + "m " : "") + s % 60 + "s";
+}
+
+function tick() {
+  countdowns.forEach(function (c) {
+    c.el.textContent = c.prefix + " " + remaining(c.until);
+  });
+}
+
+function load(path, tbody, columns, render) {
+  return fetch(path).then(function (res) {
+    if (!res.ok) {
+      return res.text().then(function (text) { throw new Error(text || res.statusText); });
+    }
+    return res.json();
+  }).then(function (body) {
+    tbody.textContent = "";
+    render(body);
+  }).catch(function (err) {
+    tbody.textContent = "";
+    empty(tbody, columns, "Unavailable: " + err.message);
+  });
+}
+
+function refresh() {
+  countdowns = [];
+
+  var cycles = document.getElementById("cycles");
+  var functions = document.getElementById("functions");
+  var events = document.getElementById("events");
+
+  Promise.all([
+    load("api/cycles", cycles, 9, function (list) {
+      if (list.length === 0) { empty(cycles, 9, "No reconcile pass completed yet"); }
+      list.forEach(function (c) {
+        var failed = counts(c.failed);
+        row(cycles, [c.gateway, time(c.started_at), c.durations.total.toFixed(2), c.evaluated, c.active, c.idle, c.idled,
+          counts(c.skipped), { text: failed, className: failed ? "failed" : "" }]);
+      });
+    }),
+    load("api/status", functions, 8, function (list) {
+      if (list.length === 0) { empty(functions, 8, "No functions observed yet"); }
+      list.forEach(function (f) {
+        var countdown = "";
+        if (f.pending_scale_at) { countdown = { text: "scale in", until: f.pending_scale_at }; }
+        else if (f.cooldown_until) { countdown = { text: "cooldown", until: f.cooldown_until }; }
+        row(functions, [f.gateway, f.function, f.namespace, f.replicas, f.invocation_rate.toFixed(3),
+          f.scale_to_zero ? { text: "idle", className: "idle" } : { text: f.replicas > 0 ? "active" : "scaled to zero", className: "active" },
+          countdown, time(f.updated_at)]);
+      });
+    }),
+    load("api/history?per_page=25", events, 6, function (page) {
+      if (page.events.length === 0) { empty(events, 6, "No events yet"); }
+      page.events.forEach(function (e) {
+        var result = e.kind === "decision" ? (e.idle ? "idle" : "active") : (e.success ? "ok" : e.error);
+        row(events, [time(e.timestamp), e.kind, e.gateway, e.function, e.kind === "decision" ? "" : e.replicas,
+          { text: result, className: e.kind === "error" ? "failed" : "" }]);
+      });
+    })
+  ]).then(function () {
+    tick();
+    document.getElementById("refreshed").textContent = new Date().toLocaleTimeString();
+  });
+}
+
+refresh();
+setInterval(refresh, 10000);
+setInterval(tick, 1000);
+</script>
+</body>
+</html>
+`
+
+// makeDashboardHandler serves the dashboard on the root of the admin port
+func makeDashboardHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		w.Write([]byte(dashboardHTML))
+	}
+}
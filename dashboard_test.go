@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_dashboardHandler(t *testing.T) {
+	handler := makeDashboardHandler()
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "api/cycles") {
+		t.Errorf("Dashboard should be served on /, got: %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Unknown paths should not serve the dashboard, got: %d", rr.Code)
+	}
+}
+
+func Test_cyclesHandler(t *testing.T) {
+	gateways := []*Gateway{
+		newGateway(types.Config{Name: "prod"}),
+		newGateway(types.Config{Name: "staging"}),
+	}
+	gateways[0].status.setCycle(newCycleSummary("prod", time.Now()))
+
+	rr := httptest.NewRecorder()
+	makeCyclesHandler(gateways)(rr, httptest.NewRequest(http.MethodGet, "/api/cycles", nil))
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"gateway":"prod"`) || strings.Contains(rr.Body.String(), "staging") {
+		t.Errorf("Only gateways with a completed pass should be listed, got: %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/types"
+)
+
+// Verdicts of decision_webhook_url
+const (
+	verdictAllow = "allow"
+	verdictDeny  = "deny"
+	verdictDefer = "defer"
+)
+
+// DecisionRequest is the scale event decision_webhook_url is asked about
+type DecisionRequest struct {
+	ScaleAction
+	Rate float64 `json:"invocation_rate"`
+}
+
+// DecisionVerdict is the JSON reply of decision_webhook_url, an empty reply
+// allows the scale event. DeferFor is a duration such as 1h the function is
+// then left alone for.
+type DecisionVerdict struct {
+	Verdict  string `json:"verdict"`
+	Reason   string `json:"reason"`
+	DeferFor string `json:"defer_for"`
+}
+
+// askDecisionWebhook posts a scale event to decision_webhook_url and returns
+// its verdict, defer_for being parsed
+func askDecisionWebhook(ctx context.Context, config types.Config, request DecisionRequest) (DecisionVerdict, time.Duration, error) {
+	request.Schema = schemaDecisionRequest
+	body, err := json.Marshal(request)
+	if err != nil {
+		return DecisionVerdict{}, 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, config.DecisionWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return DecisionVerdict{}, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	decisionCtx, cancel := withTimeout(ctx, config.DecisionWebhookTimeout)
+	defer cancel()
+
+	res, err := doRequest(decisionCtx, newHeaderClient(config.UserAgent, nil, nil), req, "decision webhook")
+	if err != nil {
+		return DecisionVerdict{}, 0, err
+	}
+
+	verdict := DecisionVerdict{Verdict: verdictAllow}
+	if len(bytes.TrimSpace(res)) > 0 {
+		if err := json.Unmarshal(res, &verdict); err != nil {
+			return DecisionVerdict{}, 0, err
+		}
+	}
+
+	verdict.Verdict = strings.ToLower(strings.TrimSpace(verdict.Verdict))
+	switch verdict.Verdict {
+	case "":
+		verdict.Verdict = verdictAllow
+	case verdictAllow, verdictDeny, verdictDefer:
+	default:
+		return verdict, 0, fmt.Errorf("unknown verdict %q", verdict.Verdict)
+	}
+
+	var deferFor time.Duration
+	if verdict.Verdict == verdictDefer && len(verdict.DeferFor) > 0 {
+		if deferFor, err = time.ParseDuration(verdict.DeferFor); err != nil || deferFor < 0 {
+			return verdict, 0, fmt.Errorf("invalid defer_for %q", verdict.DeferFor)
+		}
+	}
+	return verdict, deferFor, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_askDecisionWebhook(t *testing.T) {
+	var received DecisionRequest
+	reply := ``
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte(reply))
+	}))
+	defer webhook.Close()
+
+	config := types.Config{DecisionWebhookURL: webhook.URL, DecisionWebhookTimeout: time.Second}
+	request := DecisionRequest{ScaleAction: ScaleAction{Function: "figlet", Namespace: "dev", Replicas: 2}, Rate: 0.01}
+
+	cases := []struct {
+		reply    string
+		verdict  string
+		deferFor time.Duration
+		err      bool
+	}{
+		{``, verdictAllow, 0, false},
+		{`{"verdict": "deny", "reason": "release freeze"}`, verdictDeny, 0, false},
+		{`{"verdict": "Defer", "defer_for": "1h"}`, verdictDefer, time.Hour, false},
+		{`{"verdict": "later"}`, "", 0, true},
+		{`{"verdict": "defer", "defer_for": "soon"}`, "", 0, true},
+	}
+
+	for _, c := range cases {
+		reply = c.reply
+		verdict, deferFor, err := askDecisionWebhook(context.Background(), config, request)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: had to have an error", c.reply)
+			}
+			continue
+		}
+		if err != nil || verdict.Verdict != c.verdict || deferFor != c.deferFor {
+			t.Errorf("%s: wanted: %s %s got: %s %s %v", c.reply, c.verdict, c.deferFor, verdict.Verdict, deferFor, err)
+		}
+	}
+
+	if received.Schema != schemaDecisionRequest || received.Function != "figlet" || received.Rate != 0.01 {
+		t.Errorf("Unexpected request: %+v", received)
+	}
+}
+
+func Test_scaleDown_decisionWebhook(t *testing.T) {
+	reply := `{"verdict": "defer", "defer_for": "30m"}`
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(reply))
+	}))
+	defer webhook.Close()
+
+	scales := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scales++
+	}))
+	defer server.Close()
+
+	gateway := newGateway(types.Config{
+		Name:                         "test",
+		GatewayURL:                   server.URL + "/",
+		DecisionWebhookURL:           webhook.URL,
+		DecisionWebhookFailurePolicy: types.FailurePolicyFail,
+	})
+	fn := Function{Function: requests.Function{Name: "figlet"}}
+	summary := newCycleSummary("test", time.Now())
+
+	scaleDown(context.Background(), gateway, fn, 0, 0, 1, 0, summary)
+	if scales != 0 || summary.Skipped[skipDeferred] != 1 {
+		t.Errorf("A deferred function should not be scaled, scales: %d skipped: %v", scales, summary.Skipped)
+	}
+	if _, cooling := gateway.wakes.coolingDown("figlet", time.Now().Add(time.Minute*29)); !cooling {
+		t.Errorf("A deferred function should be left alone for defer_for")
+	}
+
+	webhook.Close()
+	scaleDown(context.Background(), gateway, fn, 0, 0, 1, 0, summary)
+	if scales != 0 || summary.failures() != 1 {
+		t.Errorf("A failing webhook should cancel the scale event with the fail policy, scales: %d", scales)
+	}
+
+	gateway.Config.DecisionWebhookFailurePolicy = types.FailurePolicyIgnore
+	scaleDown(context.Background(), gateway, fn, 0, 0, 1, 0, summary)
+	if scales != 1 {
+		t.Errorf("A failing webhook should be ignored with the ignore policy, scales: %d", scales)
+	}
+}
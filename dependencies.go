@@ -0,0 +1,39 @@
+package main
+
+import "strings"
+
+// dependsOnAnnotation lists the functions a function calls, i.e.
+// com.openfaas.depends-on=funcA,funcB on an orchestrator, which are kept warm
+// while it is active
+const dependsOnAnnotation = "com.openfaas.depends-on"
+
+// dependsOn returns the functions listed in com.openfaas.depends-on
+func dependsOn(fn Function) []string {
+	dependencies := []string{}
+	for _, name := range strings.Split(settingValue(fn, dependsOnAnnotation), ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 && name != fn.Name {
+			dependencies = append(dependencies, name)
+		}
+	}
+	return dependencies
+}
+
+// activeCallers maps each dependency of an active function to one of its
+// active callers. A caller is active above its idle threshold, or when its
+// activity could not be read. Callers outside of the pass are not known.
+func activeCallers(functions []Function, policies map[string]Policy, metrics map[string]float64, unknown map[string]bool) map[string]string {
+	callers := make(map[string]string)
+	for _, fn := range functions {
+		v, found := metrics[fn.Name]
+		if !unknown[fn.Name] && (!found || v <= policies[fn.Name].IdleThreshold) {
+			continue
+		}
+
+		for _, dependency := range dependsOn(fn) {
+			if _, exists := callers[dependency]; !exists {
+				callers[dependency] = fn.Name
+			}
+		}
+	}
+	return callers
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+func Test_activeCallers(t *testing.T) {
+	orchestrator := map[string]string{dependsOnAnnotation: "resize, thumbnail,orchestrator"}
+	nightly := map[string]string{dependsOnAnnotation: "report"}
+	unread := map[string]string{dependsOnAnnotation: "export"}
+	functions := []Function{
+		{Function: requests.Function{Name: "orchestrator"}, Annotations: &orchestrator},
+		{Function: requests.Function{Name: "nightly", Labels: &nightly}},
+		{Function: requests.Function{Name: "unread"}, Annotations: &unread},
+		{Function: requests.Function{Name: "resize"}},
+	}
+	policies := map[string]Policy{
+		"orchestrator": {IdleThreshold: 0.1},
+		"nightly":      {IdleThreshold: 0.1},
+	}
+	metrics := map[string]float64{"orchestrator": 2, "nightly": 0.1, "resize": 0}
+	unknown := map[string]bool{"unread": true}
+
+	callers := activeCallers(functions, policies, metrics, unknown)
+	want := map[string]string{"resize": "orchestrator", "thumbnail": "orchestrator", "export": "unread"}
+	if len(callers) != len(want) {
+		t.Fatalf("Callers wanted: %v got: %v", want, callers)
+	}
+	for dependency, caller := range want {
+		if callers[dependency] != caller {
+			t.Errorf("%s: caller wanted: %s got: %s", dependency, caller, callers[dependency])
+		}
+	}
+}
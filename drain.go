@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// drainStore tracks when each function decided to be idle may be scaled,
+// once its drain delay has passed
+type drainStore struct {
+	mu  sync.Mutex
+	due *lruCache
+}
+
+func newDrainStore(maxEntries int, ttl time.Duration) *drainStore {
+	return &drainStore{
+		due: newLRUCache(maxEntries, ttl),
+	}
+}
+
+// wait starts the delay for a function the first time it is decided idle and
+// returns when it may be scaled, ready once that time has passed
+func (d *drainStore) wait(name string, delay time.Duration, now time.Time) (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	due := now.Add(delay)
+	if value, exists := d.due.get(name); exists {
+		due = value.(time.Time)
+	}
+	d.due.set(name, due)
+
+	return due, !now.Before(due)
+}
+
+// cancel forgets a pending scale, i.e. when the function became active again
+// or was scaled
+func (d *drainStore) cancel(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.due.remove(name)
+}
+
+// retain drops functions which were not listed by the gateway in the last
+// reconcile pass
+func (d *drainStore) retain(names map[string]bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.due.retain(names)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_drainStore(t *testing.T) {
+	drains := newDrainStore(0, 0)
+	start := time.Now()
+
+	due, ready := drains.wait("figlet", time.Minute, start)
+	if ready || !due.Equal(start.Add(time.Minute)) {
+		t.Errorf("First wait should start the delay, got due: %s ready: %v", due, ready)
+	}
+
+	if due, ready = drains.wait("figlet", time.Minute, start.Add(time.Second*30)); ready || !due.Equal(start.Add(time.Minute)) {
+		t.Errorf("Wait within the delay should keep the due time, got due: %s ready: %v", due, ready)
+	}
+
+	if _, ready = drains.wait("figlet", time.Minute, start.Add(time.Minute)); !ready {
+		t.Errorf("Wait after the delay should be ready")
+	}
+
+	drains.cancel("figlet")
+	if _, ready = drains.wait("figlet", time.Minute, start.Add(time.Minute*2)); ready {
+		t.Errorf("Wait after cancel should restart the delay")
+	}
+
+	drains.retain(map[string]bool{})
+	if drains.due.len() != 0 {
+		t.Errorf("Retain should drop unlisted functions, got: %v", drains.due)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// idleExclusion holds the cron expressions of no_idle_schedule, each minute
+// matched by any of them, in its time zone, is one during which idle
+// functions are not scaled down
+type idleExclusion struct {
+	exprs    []cronExpr
+	location *time.Location
+}
+
+// parseIdleExclusion reads no_idle_schedule, one or more cron expressions
+// separated by ;, i.e. "* 8-17 * * 1-5" for 08:00 to 18:00 on weekdays. An
+// empty value excludes nothing and returns nil.
+func parseIdleExclusion(val string, timezone string) (*idleExclusion, error) {
+	val = strings.TrimSpace(val)
+	if len(val) == 0 {
+		return nil, nil
+	}
+
+	exclusion := &idleExclusion{location: time.Local}
+	if len(timezone) > 0 {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("no_idle_timezone: %s", err)
+		}
+		exclusion.location = location
+	}
+
+	for _, item := range strings.Split(val, ";") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		expr, err := parseCron(item)
+		if err != nil {
+			return nil, fmt.Errorf("no_idle_schedule: %q: %s", item, err)
+		}
+		exclusion.exprs = append(exclusion.exprs, expr)
+	}
+
+	if len(exclusion.exprs) == 0 {
+		return nil, fmt.Errorf("no_idle_schedule: no cron expression in %q", val)
+	}
+	return exclusion, nil
+}
+
+// excludes reports whether scaling down is excluded at the given time
+func (e *idleExclusion) excludes(t time.Time) bool {
+	if e == nil {
+		return false
+	}
+
+	local := t.In(e.location)
+	for _, expr := range e.exprs {
+		if expr.matches(local) {
+			return true
+		}
+	}
+	return false
+}
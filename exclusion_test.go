@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_idleExclusion(t *testing.T) {
+	exclusion, err := parseIdleExclusion("* 8-17 * * 1-5; * * 24-26 12 *", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"weekday morning", time.Date(2019, time.April, 1, 8, 0, 0, 0, time.UTC), true},
+		{"weekday evening", time.Date(2019, time.April, 1, 18, 0, 0, 0, time.UTC), false},
+		{"weekend", time.Date(2019, time.April, 6, 12, 0, 0, 0, time.UTC), false},
+		{"holidays", time.Date(2019, time.December, 25, 3, 0, 0, 0, time.UTC), true},
+		// 17:30 at UTC-5 is 22:30 in UTC
+		{"in the time zone", time.Date(2019, time.April, 1, 17, 30, 0, 0, time.FixedZone("EST", -5*3600)), false},
+	}
+	for _, c := range cases {
+		if got := exclusion.excludes(c.at); got != c.want {
+			t.Errorf("%s: excluded wanted: %v got: %v", c.name, c.want, got)
+		}
+	}
+
+	var none *idleExclusion
+	if none.excludes(time.Now()) {
+		t.Errorf("An empty no_idle_schedule should exclude nothing")
+	}
+
+	for _, val := range []string{"8-18 * * 1-5", ";"} {
+		if _, err := parseIdleExclusion(val, ""); err == nil {
+			t.Errorf("Had to have errors due to no_idle_schedule: %q", val)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/types"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// PolicyExport is the effective policy of every function on a gateway, it
+// is rendered as YAML so it can be committed and diffed between environments
+type PolicyExport struct {
+	Gateway   string           `yaml:"gateway"`
+	Functions []FunctionPolicy `yaml:"functions"`
+}
+
+// FunctionPolicy is the effective policy of a single function
+type FunctionPolicy struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+	Policy    `yaml:",inline"`
+}
+
+// exportPolicies lists the gateway's functions and resolves their policies
+func exportPolicies(ctx context.Context, gateway *Gateway) (PolicyExport, error) {
+	export := PolicyExport{
+		Gateway:   gateway.Name,
+		Functions: []FunctionPolicy{},
+	}
+
+	listCtx, cancel := withTimeout(ctx, gateway.Config.ListFunctionsTimeout)
+	defer cancel()
+
+	functions, err := queryFunctions(listCtx, gateway.Client, gateway.URL(), gateway.Credentials)
+	if err != nil {
+		return export, err
+	}
+
+	for _, fn := range functions {
+		export.Functions = append(export.Functions, FunctionPolicy{
+			Name:      fn.Name,
+			Namespace: fn.Namespace,
+			Policy:    resolvePolicy(fn, gateway.Config),
+		})
+	}
+
+	sort.Slice(export.Functions, func(i, j int) bool {
+		if export.Functions[i].Namespace != export.Functions[j].Namespace {
+			return export.Functions[i].Namespace < export.Functions[j].Namespace
+		}
+		return export.Functions[i].Name < export.Functions[j].Name
+	})
+	return export, nil
+}
+
+// runExportPolicies prints the effective policies of every gateway as a
+// YAML stream and returns the process exit code
+func runExportPolicies(config types.Config) int {
+	for _, gatewayConfig := range config.Targets() {
+		gateway := newGateway(gatewayConfig)
+		gateway.checkHealth(context.Background())
+
+		export, err := exportPolicies(context.Background(), gateway)
+		if err != nil {
+			log.Printf("[%s] Unable to export policies: %s\n", gateway.Name, err)
+			return 1
+		}
+
+		out, _ := yaml.Marshal(export)
+		fmt.Fprintf(os.Stdout, "---\n%s", out)
+	}
+	return 0
+}
+
+// makePoliciesHandler renders the effective policies of a gateway, chosen
+// with ?gateway= when several are configured
+func makePoliciesHandler(gateways []*Gateway) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		filter := r.URL.Query().Get("gateway")
+
+		w.Header().Set("Content-Type", "application/x-yaml")
+		for _, gateway := range gateways {
+			if len(filter) > 0 && filter != gateway.Name {
+				continue
+			}
+
+			export, err := exportPolicies(r.Context(), gateway)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+
+			out, _ := yaml.Marshal(export)
+			fmt.Fprintf(w, "---\n%s", out)
+		}
+	}
+}
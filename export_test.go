@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/types"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func Test_exportPolicies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+	{"name": "figlet", "namespace": "dev", "labels": {"com.openfaas.scale.zero": "true", "com.openfaas.tier": "gold"}},
+	{"name": "echo", "namespace": "dev", "labels": {}}
+]`))
+	}))
+	defer server.Close()
+
+	gateway := newGateway(types.Config{
+		Name:               "test",
+		GatewayURL:         server.URL + "/",
+		InactivityDuration: time.Minute * 5,
+		TierPolicies: map[string]types.TierPolicy{
+			"gold": {InactivityDuration: time.Hour},
+		},
+	})
+
+	export, err := exportPolicies(context.Background(), gateway)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	out, _ := yaml.Marshal(export)
+	want := `gateway: test
+functions:
+- name: echo
+  namespace: dev
+  enabled: false
+  inactivity_duration: 5m0s
+- name: figlet
+  namespace: dev
+  enabled: true
+  inactivity_duration: 1h0m0s
+  tier: gold
+`
+	if string(out) != want {
+		t.Errorf("Export wanted:\n%s\ngot:\n%s", want, out)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	reconcileDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "faas_idler_reconcile_duration_seconds",
+		Help: "Duration of the last reconcile pass per gateway",
+	}, []string{"gateway"})
+
+	reconcileInterval = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "faas_idler_reconcile_interval_seconds",
+		Help: "Effective interval between reconcile passes",
+	})
+
+	functionsTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "faas_idler_functions",
+		Help: "Functions seen in the last reconcile pass per gateway",
+	}, []string{"gateway"})
+
+	functionsIdle = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "faas_idler_functions_idle",
+		Help: "Functions found idle in the last reconcile pass per gateway",
+	}, []string{"gateway"})
+
+	scaleEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "faas_idler_scale_events_total",
+		Help: "Scale events sent per gateway by response code",
+	}, []string{"gateway", "code"})
+
+	wakeEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "faas_idler_wake_events_total",
+		Help: "Functions scaled down by the idler and found scaled up outside of it, per gateway",
+	}, []string{"gateway"})
+
+	functionFlaps = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "faas_idler_function_flaps",
+		Help: "Times a function was woken after the idler scaled it down within the last hour",
+	}, []string{"gateway", "function_name"})
+
+	idleCost = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "faas_idler_idle_cost_per_hour",
+		Help: "Cost per hour of the replicas of functions found idle and left running by the last reconcile pass per gateway",
+	}, []string{"gateway"})
+
+	reconcilePaused = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "faas_idler_paused",
+		Help: "1 when pause_file suspended scale events in the last reconcile pass per gateway",
+	}, []string{"gateway"})
+
+	recommendation = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "faas_idler_function_scale_to_zero",
+		Help: "1 when the idler recommends scaling a function to zero, 0 when it is active",
+	}, []string{"gateway", "function_name"})
+)
+
+func init() {
+	prometheus.MustRegister(reconcileDuration, reconcileInterval, functionsTotal, functionsIdle, scaleEvents, wakeEvents, functionFlaps, idleCost, reconcilePaused, recommendation)
+}
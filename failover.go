@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// failover tracks which of a primary and fallback gateway URL is in use
+type failover struct {
+	primary  string
+	fallback string
+
+	mu     sync.RWMutex
+	active string
+}
+
+func newFailover(primary, fallback string) failover {
+	return failover{
+		primary:  primary,
+		fallback: fallback,
+		active:   primary,
+	}
+}
+
+// URL returns the gateway URL requests should currently be sent to
+func (g *Gateway) URL() string {
+	g.failover.mu.RLock()
+	defer g.failover.mu.RUnlock()
+
+	return g.failover.active
+}
+
+// checkHealth probes the primary gateway and fails over to the fallback while
+// it is unhealthy, failing back as soon as the primary recovers. Without a
+// fallback configured no probe is made. Each probe has its own timeout, so a
+// primary which hangs does not leave the fallback probe without time.
+func (g *Gateway) checkHealth(ctx context.Context) {
+	f := &g.failover
+	if len(f.fallback) == 0 {
+		return
+	}
+
+	next := f.primary
+	if err := g.probe(ctx, f.primary); err != nil {
+		log.Printf("[%s] Primary gateway %s is unhealthy: %s\n", g.Name, f.primary, err)
+
+		next = f.fallback
+		if fallbackErr := g.probe(ctx, f.fallback); fallbackErr != nil {
+			log.Printf("[%s] Fallback gateway %s is unhealthy: %s\n", g.Name, f.fallback, fallbackErr)
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if next != f.active {
+		if next == f.primary {
+			log.Printf("[%s] Failing back to primary gateway %s\n", g.Name, next)
+		} else {
+			log.Printf("[%s] Failing over to fallback gateway %s\n", g.Name, next)
+		}
+		f.active = next
+	}
+}
+
+// probe checks the health of a gateway URL within list_functions_timeout
+func (g *Gateway) probe(ctx context.Context, gatewayURL string) error {
+	ctx, cancel := withTimeout(ctx, g.Config.ListFunctionsTimeout)
+	defer cancel()
+
+	return probeGateway(ctx, g.Client, gatewayURL)
+}
+
+func probeGateway(ctx context.Context, client *http.Client, gatewayURL string) error {
+	req, _ := http.NewRequest(http.MethodGet, gatewayURL+"healthz", nil)
+	req = req.WithContext(ctx)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_checkHealth_failsOverAndBack(t *testing.T) {
+	primaryHealthy := true
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !primaryHealthy {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer primary.Close()
+
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fallback.Close()
+
+	gateway := newGateway(types.Config{
+		Name:               "test",
+		GatewayURL:         primary.URL + "/",
+		FallbackGatewayURL: fallback.URL + "/",
+	})
+
+	gateway.checkHealth(context.Background())
+	if gateway.URL() != primary.URL+"/" {
+		t.Errorf("Active gateway wanted: %s got: %s", primary.URL+"/", gateway.URL())
+	}
+
+	primaryHealthy = false
+	gateway.checkHealth(context.Background())
+	if gateway.URL() != fallback.URL+"/" {
+		t.Errorf("Active gateway wanted: %s got: %s", fallback.URL+"/", gateway.URL())
+	}
+
+	primaryHealthy = true
+	gateway.checkHealth(context.Background())
+	if gateway.URL() != primary.URL+"/" {
+		t.Errorf("Active gateway wanted: %s got: %s", primary.URL+"/", gateway.URL())
+	}
+}
+
+func Test_checkHealth_staysWhenBothUnhealthy(t *testing.T) {
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+
+	gateway := newGateway(types.Config{
+		Name:               "test",
+		GatewayURL:         unhealthy.URL + "/primary/",
+		FallbackGatewayURL: unhealthy.URL + "/fallback/",
+	})
+
+	gateway.checkHealth(context.Background())
+	if gateway.URL() != unhealthy.URL+"/primary/" {
+		t.Errorf("Active gateway wanted: %s got: %s", unhealthy.URL+"/primary/", gateway.URL())
+	}
+}
+
+func Test_checkHealth_failsOverFromHangingPrimary(t *testing.T) {
+	release := make(chan struct{})
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(time.Second):
+		}
+	}))
+	defer primary.Close()
+	defer close(release)
+
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fallback.Close()
+
+	gateway := newGateway(types.Config{
+		Name:                 "test",
+		GatewayURL:           primary.URL + "/",
+		FallbackGatewayURL:   fallback.URL + "/",
+		ListFunctionsTimeout: time.Millisecond * 100,
+	})
+
+	gateway.checkHealth(context.Background())
+	if gateway.URL() != fallback.URL+"/" {
+		t.Errorf("A primary which hangs past the timeout should fail over, active gateway wanted: %s got: %s", fallback.URL+"/", gateway.URL())
+	}
+}
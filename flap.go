@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// flapWindow is the period flaps are counted over
+const flapWindow = time.Hour
+
+// maxFlapPenalty bounds the factor applied to the inactivity duration of a
+// function which keeps flapping
+const maxFlapPenalty = 16
+
+// flapStore counts the times each function was woken after the idler scaled
+// it down, within flapWindow. Past flap_threshold flaps, every further flap
+// doubles a penalty factor on its inactivity duration, which decays back
+// towards 1 by half every flap_penalty_half_life.
+type flapStore struct {
+	mu      sync.Mutex
+	gateway string
+	flaps   *lruCache
+}
+
+// flapState is the flaps of a function within flapWindow, and its penalty
+// as of updatedAt
+type flapState struct {
+	flaps     []time.Time
+	penalty   float64
+	updatedAt time.Time
+}
+
+func newFlapStore(gateway string, maxEntries int, ttl time.Duration) *flapStore {
+	flaps := newLRUCache(maxEntries, ttl)
+	flaps.onEvict = func(name string, value interface{}) {
+		functionFlaps.DeleteLabelValues(gateway, name)
+	}
+
+	return &flapStore{
+		gateway: gateway,
+		flaps:   flaps,
+	}
+}
+
+// flapped records a wake of a function the idler scaled down and returns
+// how many times it flapped within flapWindow, with its penalty factor
+func (f *flapStore) flapped(name string, now time.Time, threshold int, halfLife time.Duration) (int, float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	state := f.state(name, now, halfLife)
+	state.flaps = append(state.flaps, now)
+	if threshold > 0 && len(state.flaps) > threshold {
+		state.penalty = math.Min(state.penalty*2, maxFlapPenalty)
+	}
+	f.flaps.set(name, state)
+
+	functionFlaps.WithLabelValues(f.gateway, name).Set(float64(len(state.flaps)))
+	return len(state.flaps), state.penalty
+}
+
+// count returns how many times a function flapped within flapWindow, which
+// are the cold starts caused by idling it
+func (f *flapStore) count(name string, now time.Time) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value, exists := f.flaps.get(name)
+	if !exists {
+		return 0
+	}
+
+	count := 0
+	for _, at := range value.(flapState).flaps {
+		if now.Sub(at) < flapWindow {
+			count++
+		}
+	}
+	return count
+}
+
+// penalty returns the factor applied to the inactivity duration of a
+// function, 1 when it has not flapped, and refreshes its flap count
+func (f *flapStore) penalty(name string, now time.Time, halfLife time.Duration) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value, exists := f.flaps.get(name)
+	if !exists {
+		return 1
+	}
+
+	state := f.state(name, now, halfLife)
+	if len(state.flaps) == 0 && state.penalty < 1.01 {
+		f.flaps.remove(name)
+		functionFlaps.DeleteLabelValues(f.gateway, name)
+		return 1
+	}
+	if len(state.flaps) != len(value.(flapState).flaps) {
+		f.flaps.set(name, state)
+		functionFlaps.WithLabelValues(f.gateway, name).Set(float64(len(state.flaps)))
+	}
+	return state.penalty
+}
+
+// state returns the flaps of a function within flapWindow of now, with its
+// penalty decayed since it was last updated
+func (f *flapStore) state(name string, now time.Time, halfLife time.Duration) flapState {
+	state := flapState{penalty: 1, updatedAt: now}
+
+	value, exists := f.flaps.get(name)
+	if !exists {
+		return state
+	}
+
+	previous := value.(flapState)
+	for _, at := range previous.flaps {
+		if now.Sub(at) < flapWindow {
+			state.flaps = append(state.flaps, at)
+		}
+	}
+
+	state.penalty = previous.penalty
+	if elapsed := now.Sub(previous.updatedAt); elapsed > 0 {
+		state.penalty = 1 + (previous.penalty-1)*math.Pow(0.5, elapsed.Seconds()/halfLife.Seconds())
+	}
+	return state
+}
+
+// retain drops functions which were not listed by the gateway in the last
+// reconcile pass
+func (f *flapStore) retain(names map[string]bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.flaps.retain(names)
+}
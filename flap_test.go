@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func Test_flapStore(t *testing.T) {
+	flaps := newFlapStore("test", 10, time.Hour*24)
+	start := time.Date(2019, time.April, 1, 8, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if count, penalty := flaps.flapped("figlet", start.Add(time.Duration(i)*time.Minute), 2, time.Hour); count != i+1 || penalty != 1 {
+			t.Errorf("Flap %d: wanted: %d 1 got: %d %f", i+1, i+1, count, penalty)
+		}
+	}
+
+	count, penalty := flaps.flapped("figlet", start.Add(time.Minute*2), 2, time.Hour)
+	if count != 3 || penalty != 2 {
+		t.Errorf("Flapping past the threshold should double the penalty, got: %d %f", count, penalty)
+	}
+	if _, penalty := flaps.flapped("figlet", start.Add(time.Minute*2), 2, time.Hour); penalty != 4 {
+		t.Errorf("Every further flap should double the penalty, got: %f", penalty)
+	}
+
+	if penalty := flaps.penalty("figlet", start.Add(time.Minute*62), time.Hour); math.Abs(penalty-2.5) > 0.001 {
+		t.Errorf("The penalty should halve back towards 1 in a half life, wanted: 2.5 got: %f", penalty)
+	}
+	if count, _ := flaps.flapped("figlet", start.Add(time.Minute*62), 2, time.Hour); count != 1 {
+		t.Errorf("Flaps older than an hour should not be counted, got: %d", count)
+	}
+
+	if count := flaps.count("figlet", start.Add(time.Minute*62)); count != 1 {
+		t.Errorf("Cold starts wanted: 1 got: %d", count)
+	}
+
+	if penalty := flaps.penalty("figlet", start.Add(time.Hour*24), time.Hour); penalty != 1 {
+		t.Errorf("The penalty should wear off, got: %f", penalty)
+	}
+	if penalty := flaps.penalty("env", start, time.Hour); penalty != 1 {
+		t.Errorf("A function which never flapped should have no penalty, got: %f", penalty)
+	}
+
+	if count, penalty := flaps.flapped("env", start, 0, time.Hour); count != 1 || penalty != 1 {
+		t.Errorf("Without a threshold flaps should only be counted, got: %d %f", count, penalty)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+const hoursPerMonth = 24 * 30
+
+// namespaceForecast estimates what enabling the idler would save in a
+// namespace, extrapolated from the recommendations observed so far
+type namespaceForecast struct {
+	Gateway   string `json:"gateway"`
+	Namespace string `json:"namespace"`
+	Functions int    `json:"functions"`
+
+	// IdleReplicas is the average number of replicas which would have been
+	// scaled to zero over the observed period
+	IdleReplicas         float64 `json:"idle_replicas"`
+	ObservedReplicaHours float64 `json:"observed_idle_replica_hours"`
+	ReplicaHoursPerMonth float64 `json:"replica_hours_per_month"`
+	CostPerMonth         float64 `json:"cost_per_month"`
+}
+
+// forecastSavings groups the observed statuses of a gateway by namespace
+func forecastSavings(gateway *Gateway) []namespaceForecast {
+	byNamespace := make(map[string]*namespaceForecast)
+
+	for _, status := range gateway.status.list() {
+		forecast, exists := byNamespace[status.Namespace]
+		if !exists {
+			forecast = &namespaceForecast{
+				Gateway:   gateway.Name,
+				Namespace: status.Namespace,
+			}
+			byNamespace[status.Namespace] = forecast
+		}
+
+		forecast.Functions++
+		forecast.ObservedReplicaHours += status.IdleReplicaSeconds / 3600
+
+		if status.ObservedSeconds <= 0 {
+			continue
+		}
+
+		idleReplicas := status.IdleReplicaSeconds / status.ObservedSeconds
+		forecast.IdleReplicas += idleReplicas
+		forecast.ReplicaHoursPerMonth += idleReplicas * hoursPerMonth
+		forecast.CostPerMonth += idleReplicas * hoursPerMonth * replicaCostPerHour(status.Requests, gateway.Config.CostPerCPUHour, gateway.Config.CostPerGBHour)
+	}
+
+	forecasts := make([]namespaceForecast, 0, len(byNamespace))
+	for _, forecast := range byNamespace {
+		forecasts = append(forecasts, *forecast)
+	}
+
+	sort.Slice(forecasts, func(i, j int) bool {
+		return forecasts[i].Namespace < forecasts[j].Namespace
+	})
+	return forecasts
+}
+
+// replicaCostPerHour prices a single replica by its resource requests,
+// functions without requests cost nothing
+func replicaCostPerHour(resources *requests.FunctionResources, costPerCPUHour, costPerGBHour float64) float64 {
+	if resources == nil {
+		return 0
+	}
+
+	cost := float64(0)
+	if cpu, err := parseCPU(resources.CPU); err == nil {
+		cost += cpu * costPerCPUHour
+	}
+	if memory, err := parseMemory(resources.Memory); err == nil {
+		cost += memory / (1 << 30) * costPerGBHour
+	}
+	return cost
+}
+
+// sortMostExpensive orders functions by what the replicas idling them would
+// free cost per hour, those above their idle target, the most expensive
+// first, keeping the order of those costing the same
+func sortMostExpensive(functions []Function, policies map[string]Policy, config types.Config) {
+	costs := make(map[string]float64, len(functions))
+	for _, fn := range functions {
+		target := policies[fn.Name].idleTarget()
+		if fn.Replicas <= target {
+			continue
+		}
+		costs[fn.Name] = float64(fn.Replicas-target) * replicaCostPerHour(fn.Requests, config.CostPerCPUHour, config.CostPerGBHour)
+	}
+
+	sort.SliceStable(functions, func(i, j int) bool {
+		return costs[functions[i].Name] > costs[functions[j].Name]
+	})
+}
+
+// parseCPU converts a Kubernetes CPU quantity such as 100m or 0.5 to cores
+func parseCPU(quantity string) (float64, error) {
+	quantity = strings.TrimSpace(quantity)
+	if len(quantity) == 0 {
+		return 0, fmt.Errorf("empty CPU quantity")
+	}
+
+	if strings.HasSuffix(quantity, "m") {
+		millis, err := strconv.ParseFloat(strings.TrimSuffix(quantity, "m"), 64)
+		return millis / 1000, err
+	}
+	return strconv.ParseFloat(quantity, 64)
+}
+
+var memorySuffixes = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+	{"k", 1e3},
+	{"K", 1e3},
+	{"M", 1e6},
+	{"G", 1e9},
+	{"T", 1e12},
+}
+
+// parseMemory converts a Kubernetes or Docker memory quantity such as 128Mi
+// or 1G to bytes
+func parseMemory(quantity string) (float64, error) {
+	quantity = strings.TrimSpace(quantity)
+	if len(quantity) == 0 {
+		return 0, fmt.Errorf("empty memory quantity")
+	}
+
+	for _, unit := range memorySuffixes {
+		if strings.HasSuffix(quantity, unit.suffix) {
+			val, err := strconv.ParseFloat(strings.TrimSuffix(quantity, unit.suffix), 64)
+			return val * unit.multiplier, err
+		}
+	}
+	return strconv.ParseFloat(quantity, 64)
+}
+
+// makeForecastHandler reports the savings forecast per namespace, optionally
+// filtered with ?gateway=
+func makeForecastHandler(gateways []*Gateway) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		filter := r.URL.Query().Get("gateway")
+
+		forecasts := []namespaceForecast{}
+		for _, gateway := range gateways {
+			if len(filter) > 0 && filter != gateway.Name {
+				continue
+			}
+			forecasts = append(forecasts, forecastSavings(gateway)...)
+		}
+
+		writeJSON(w, http.StatusOK, forecasts)
+	}
+}
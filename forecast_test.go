@@ -0,0 +1,125 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_parseCPU(t *testing.T) {
+	cases := []struct {
+		quantity string
+		want     float64
+	}{
+		{"100m", 0.1},
+		{"1", 1},
+		{"0.5", 0.5},
+		{"2500m", 2.5},
+	}
+
+	for _, c := range cases {
+		got, err := parseCPU(c.quantity)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.quantity, err)
+		}
+		if math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s: wanted: %f got: %f", c.quantity, c.want, got)
+		}
+	}
+
+	if _, err := parseCPU("lots"); err == nil {
+		t.Errorf("Had to have errors due to bad quantity")
+	}
+}
+
+func Test_parseMemory(t *testing.T) {
+	cases := []struct {
+		quantity string
+		want     float64
+	}{
+		{"128Mi", 128 * 1024 * 1024},
+		{"1Gi", 1024 * 1024 * 1024},
+		{"20M", 20e6},
+		{"1024", 1024},
+	}
+
+	for _, c := range cases {
+		got, err := parseMemory(c.quantity)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.quantity, err)
+		}
+		if got != c.want {
+			t.Errorf("%s: wanted: %f got: %f", c.quantity, c.want, got)
+		}
+	}
+}
+
+func Test_forecastSavings(t *testing.T) {
+	gateway := newGateway(types.Config{
+		Name:           "test",
+		CostPerCPUHour: 0.04,
+		CostPerGBHour:  0.005,
+	})
+
+	start := time.Now()
+	resources := &requests.FunctionResources{CPU: "500m", Memory: "1Gi"}
+
+	// figlet is idle for the whole hour, echo is never idle and markdown is
+	// idle but already at its idle target
+	gateway.status.set(functionStatus{Function: "figlet", Namespace: "dev", Replicas: 2, ScaleToZero: true, Requests: resources, UpdatedAt: start})
+	gateway.status.set(functionStatus{Function: "figlet", Namespace: "dev", Replicas: 2, ScaleToZero: true, Requests: resources, UpdatedAt: start.Add(time.Hour)})
+	gateway.status.set(functionStatus{Function: "echo", Namespace: "dev", Replicas: 1, UpdatedAt: start})
+	gateway.status.set(functionStatus{Function: "echo", Namespace: "dev", Replicas: 1, UpdatedAt: start.Add(time.Hour)})
+	gateway.status.set(functionStatus{Function: "markdown", Namespace: "dev", Replicas: 1, Target: 1, ScaleToZero: true, Requests: resources, UpdatedAt: start})
+	gateway.status.set(functionStatus{Function: "markdown", Namespace: "dev", Replicas: 1, Target: 1, ScaleToZero: true, Requests: resources, UpdatedAt: start.Add(time.Hour)})
+
+	forecasts := forecastSavings(gateway)
+	if len(forecasts) != 1 {
+		t.Fatalf("Namespaces wanted: %d got: %d", 1, len(forecasts))
+	}
+
+	forecast := forecasts[0]
+	if forecast.Functions != 3 {
+		t.Errorf("Functions wanted: %d got: %d", 3, forecast.Functions)
+	}
+	if forecast.IdleReplicas != 2 {
+		t.Errorf("Idle replicas wanted: %f got: %f", 2.0, forecast.IdleReplicas)
+	}
+	if forecast.ReplicaHoursPerMonth != 2*hoursPerMonth {
+		t.Errorf("Replica hours wanted: %f got: %f", 2.0*hoursPerMonth, forecast.ReplicaHoursPerMonth)
+	}
+
+	wantCost := 2 * hoursPerMonth * (0.5*0.04 + 1*0.005)
+	if math.Abs(forecast.CostPerMonth-wantCost) > 1e-9 {
+		t.Errorf("Cost wanted: %f got: %f", wantCost, forecast.CostPerMonth)
+	}
+}
+
+func Test_sortMostExpensive(t *testing.T) {
+	config := types.Config{CostPerCPUHour: 0.04, CostPerGBHour: 0.005}
+	functions := []Function{
+		{Function: requests.Function{Name: "echo", Replicas: 1}},
+		{Function: requests.Function{Name: "figlet", Replicas: 1}, Requests: &requests.FunctionResources{CPU: "500m", Memory: "1Gi"}},
+		{Function: requests.Function{Name: "nodeinfo", Replicas: 4}, Requests: &requests.FunctionResources{CPU: "250m"}},
+		{Function: requests.Function{Name: "markdown", Replicas: 4}, Requests: &requests.FunctionResources{CPU: "500m"}},
+		{Function: requests.Function{Name: "env", Replicas: 1}},
+	}
+	// Idling markdown only frees the replica above its scale.min of 3
+	policies := map[string]Policy{
+		"markdown": {ScaleMin: 3},
+	}
+
+	sortMostExpensive(functions, policies, config)
+	got := []string{}
+	for _, fn := range functions {
+		got = append(got, fn.Name)
+	}
+	// echo and env cost nothing and keep their order
+	if want := "nodeinfo figlet markdown echo env"; strings.Join(got, " ") != want {
+		t.Errorf("Order wanted: %s got: %s", want, strings.Join(got, " "))
+	}
+}
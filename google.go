@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// googleTokenSource requests access tokens of the Google service account
+// bound to the pod by GKE workload identity, or of the node, from the
+// metadata server
+type googleTokenSource struct {
+	client      *http.Client
+	scope       string
+	metadataURL string
+}
+
+func newGoogleTokenSource(client *http.Client, scope string) *googleTokenSource {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if len(host) == 0 {
+		host = "metadata.google.internal"
+	}
+
+	return &googleTokenSource{
+		client:      client,
+		scope:       scope,
+		metadataURL: "http://" + host,
+	}
+}
+
+func (s *googleTokenSource) token(ctx context.Context) (string, time.Time, error) {
+	tokenURL := s.metadataURL + "/computeMetadata/v1/instance/service-accounts/default/token"
+	if len(s.scope) > 0 {
+		tokenURL += "?scopes=" + url.QueryEscape(s.scope)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	bytesOut, err := doRequest(ctx, s.client, req, "the GCE metadata server")
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	body := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{}
+	if err := json.Unmarshal(bytesOut, &body); err != nil {
+		return "", time.Time{}, fmt.Errorf("Error unmarshaling result: %s, '%s'", err, string(bytesOut))
+	}
+	if len(body.AccessToken) == 0 {
+		return "", time.Time{}, fmt.Errorf("no access_token from the GCE metadata server")
+	}
+	return body.AccessToken, time.Now().Add(time.Duration(body.ExpiresIn) * time.Second), nil
+}
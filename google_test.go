@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_googleTokenSource(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Query().Get("scopes") != "https://www.googleapis.com/auth/monitoring.read" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"access_token": "gke-token", "expires_in": 3599, "token_type": "Bearer"}`))
+	}))
+	defer metadata.Close()
+
+	source := newGoogleTokenSource(&http.Client{}, "https://www.googleapis.com/auth/monitoring.read")
+	source.metadataURL = metadata.URL
+
+	token, expires, err := source.token(context.Background())
+	if err != nil || token != "gke-token" {
+		t.Fatalf("Unexpected token: %s %v", token, err)
+	}
+	if expires.IsZero() {
+		t.Errorf("The token should expire")
+	}
+}
+
+func Test_prometheusTokenSource(t *testing.T) {
+	if source := prometheusTokenSource(types.Config{}); source != nil {
+		t.Errorf("No token should be sent without prometheus_token_provider")
+	}
+	if _, ok := prometheusTokenSource(types.Config{PrometheusTokenProvider: types.TokenProviderGoogle}).(*googleTokenSource); !ok {
+		t.Errorf("google should read tokens from the metadata server")
+	}
+	if source, ok := prometheusTokenSource(types.Config{PrometheusTokenProvider: types.TokenProviderAzure}).(*azureTokenSource); !ok || source.scope != types.DefaultAzureTokenScope {
+		t.Errorf("azure should request tokens for Azure Monitor by default")
+	}
+}
+
+func Test_credentialClient_timeout(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 500)
+	}))
+	defer metadata.Close()
+
+	source := prometheusTokenSource(types.Config{PrometheusTokenProvider: types.TokenProviderGoogle, PrometheusTimeout: time.Millisecond * 50}).(*googleTokenSource)
+	source.metadataURL = metadata.URL
+
+	start := time.Now()
+	if _, _, err := source.token(context.Background()); err == nil {
+		t.Errorf("A token request exceeding prometheus_timeout should fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*250 {
+		t.Errorf("The token request should be abandoned after prometheus_timeout, took: %s", elapsed)
+	}
+}
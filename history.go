@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultHistoryPageSize = 100
+	maxHistoryPageSize     = 1000
+)
+
+// historyQuery filters the events read back from the audit log
+type historyQuery struct {
+	Function string
+	Gateway  string
+	Kind     string
+	Since    time.Time
+	Until    time.Time
+	Page     int
+	PerPage  int
+}
+
+func (q historyQuery) matches(notification Notification) bool {
+	switch {
+	case len(q.Function) > 0 && q.Function != notification.Function:
+		return false
+	case len(q.Gateway) > 0 && q.Gateway != notification.Gateway:
+		return false
+	case len(q.Kind) > 0 && q.Kind != notification.Kind:
+		return false
+	case !q.Since.IsZero() && notification.Timestamp.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !notification.Timestamp.Before(q.Until):
+		return false
+	}
+	return true
+}
+
+// HistoryPage is a page of events, the most recent first
+type HistoryPage struct {
+	Page    int            `json:"page"`
+	PerPage int            `json:"per_page"`
+	Total   int            `json:"total"`
+	Events  []Notification `json:"events"`
+}
+
+// parseHistoryQuery reads ?function=&gateway=&kind=&since=&until=&page=&per_page=,
+// since and until being RFC3339 times or durations back from now such as 24h
+func parseHistoryQuery(r *http.Request, now time.Time) (historyQuery, error) {
+	values := r.URL.Query()
+	q := historyQuery{
+		Function: values.Get("function"),
+		Gateway:  values.Get("gateway"),
+		Kind:     values.Get("kind"),
+		Page:     1,
+		PerPage:  defaultHistoryPageSize,
+	}
+
+	times := []struct {
+		name  string
+		value *time.Time
+	}{
+		{"since", &q.Since},
+		{"until", &q.Until},
+	}
+	for _, t := range times {
+		val := values.Get(t.name)
+		if len(val) == 0 {
+			continue
+		}
+		if parsed, err := time.Parse(time.RFC3339, val); err == nil {
+			*t.value = parsed
+		} else if ago, durationErr := time.ParseDuration(val); durationErr == nil {
+			*t.value = now.Add(-ago)
+		} else {
+			return q, fmt.Errorf("%s must be an RFC3339 time or a duration, got: %q", t.name, val)
+		}
+	}
+
+	numbers := []struct {
+		name  string
+		value *int
+		max   int
+	}{
+		{"page", &q.Page, 0},
+		{"per_page", &q.PerPage, maxHistoryPageSize},
+	}
+	for _, n := range numbers {
+		val := values.Get(n.name)
+		if len(val) == 0 {
+			continue
+		}
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 1 || (n.max > 0 && parsed > n.max) {
+			return q, fmt.Errorf("%s must be a number from 1, got: %q", n.name, val)
+		}
+		*n.value = parsed
+	}
+	return q, nil
+}
+
+// readHistory reads the events of the audit log matching the query and
+// returns the requested page, the most recent first
+func readHistory(path string, q historyQuery) (HistoryPage, error) {
+	page := HistoryPage{Page: q.Page, PerPage: q.PerPage, Events: []Notification{}}
+
+	lines, err := readAuditLines(path)
+	if err != nil {
+		return page, err
+	}
+
+	matched := []Notification{}
+	for _, line := range lines {
+		if q.matches(line.notification) {
+			matched = append(matched, line.notification)
+		}
+	}
+
+	page.Total = len(matched)
+	for i := len(matched) - 1 - (q.Page-1)*q.PerPage; i >= 0 && len(page.Events) < q.PerPage; i-- {
+		page.Events = append(page.Events, matched[i])
+	}
+	return page, nil
+}
+
+// makeHistoryHandler serves past decisions and scale events from the audit
+// log on /api/history
+func makeHistoryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if audit == nil {
+			http.Error(w, "audit_log_file is not set", http.StatusNotFound)
+			return
+		}
+
+		q, err := parseHistoryQuery(r, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := readHistory(audit.path, q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_readHistory(t *testing.T) {
+	file, err := ioutil.TempFile("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	log, err := openAuditLog(file.Name(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		log.write(Notification{Kind: kindScale, Gateway: "prod", Function: "figlet", Timestamp: start.Add(time.Hour * time.Duration(i))})
+		log.write(Notification{Kind: kindDecision, Gateway: "prod", Function: "nodeinfo", Timestamp: start.Add(time.Hour * time.Duration(i))})
+	}
+	log.file.WriteString("{\"kind\": \"sca")
+
+	page, err := readHistory(file.Name(), historyQuery{Function: "figlet", Since: start.Add(time.Hour), Page: 2, PerPage: 3})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if page.Total != 4 || len(page.Events) != 1 {
+		t.Fatalf("Unexpected page: %+v", page)
+	}
+	if want := start.Add(time.Hour); !page.Events[0].Timestamp.Equal(want) {
+		t.Errorf("Oldest event wanted on the last page: %s got: %s", want, page.Events[0].Timestamp)
+	}
+}
+
+func Test_parseHistoryQuery(t *testing.T) {
+	now := time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	q, err := parseHistoryQuery(httptest.NewRequest(http.MethodGet, "/api/history?function=figlet&since=24h&page=3", nil), now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if q.Function != "figlet" || !q.Since.Equal(now.Add(-time.Hour*24)) || q.Page != 3 || q.PerPage != defaultHistoryPageSize {
+		t.Errorf("Unexpected query: %+v", q)
+	}
+
+	for _, bad := range []string{"since=yesterday", "page=0", "per_page=5000"} {
+		if _, err := parseHistoryQuery(httptest.NewRequest(http.MethodGet, "/api/history?"+bad, nil), now); err == nil {
+			t.Errorf("%s should be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/types"
+)
+
+// ScaleAction is the scale event a hook is asked about, a pre-scale hook may
+// change its Target
+type ScaleAction struct {
+	Schema      string            `json:"schema"`
+	Gateway     string            `json:"gateway"`
+	Function    string            `json:"function"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Replicas    uint64            `json:"replicas"`
+	Target      uint64            `json:"target"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ScaleOutcome is sent to post-scale hooks once a scale event completed
+type ScaleOutcome struct {
+	ScaleAction
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HookResponse is the optional JSON body returned by a hook, an empty body
+// allows the action unchanged
+type HookResponse struct {
+	Allow    *bool   `json:"allow"`
+	Replicas *uint64 `json:"replicas"`
+	Reason   string  `json:"reason"`
+}
+
+// vetoError is returned when a hook denies the action or fails with the
+// fail policy
+type vetoError struct {
+	hook   string
+	reason string
+}
+
+func (e vetoError) Error() string {
+	return fmt.Sprintf("vetoed by hook %s: %s", e.hook, e.reason)
+}
+
+func newScaleAction(gateway *Gateway, fn Function, replicas uint64, target uint64) ScaleAction {
+	action := ScaleAction{
+		Schema:    schemaScaleAction,
+		Gateway:   gateway.Name,
+		Function:  fn.Name,
+		Namespace: fn.Namespace,
+		Replicas:  replicas,
+		Target:    target,
+	}
+	if fn.Labels != nil {
+		action.Labels = *fn.Labels
+	}
+	if fn.Annotations != nil {
+		action.Annotations = *fn.Annotations
+	}
+	return action
+}
+
+// runPreScaleHooks passes the action through each matching hook in order,
+// the returned action carries any target set by the hooks
+func runPreScaleHooks(ctx context.Context, hooks []types.Hook, fn Function, action ScaleAction) (ScaleAction, error) {
+	for _, hook := range hooks {
+		if !selectorMatches(hook.Namespace, hook.Labels, fn) {
+			continue
+		}
+
+		response, err := runHook(ctx, hook, fn, action)
+		if err != nil {
+			if hook.FailurePolicy == types.FailurePolicyIgnore {
+				continue
+			}
+			return action, vetoError{hook: hook.Name, reason: err.Error()}
+		}
+
+		if response.Allow != nil && !*response.Allow {
+			reason := response.Reason
+			if len(reason) == 0 {
+				reason = "denied"
+			}
+			return action, vetoError{hook: hook.Name, reason: reason}
+		}
+
+		if response.Replicas != nil {
+			action.Target = *response.Replicas
+		}
+	}
+	return action, nil
+}
+
+// runPostScaleHooks sends the outcome to each matching hook in order, a
+// failing hook is logged and does not stop the chain
+func runPostScaleHooks(ctx context.Context, hooks []types.Hook, fn Function, outcome ScaleOutcome) {
+	for _, hook := range hooks {
+		if !selectorMatches(hook.Namespace, hook.Labels, fn) {
+			continue
+		}
+
+		if _, err := runHook(ctx, hook, fn, outcome); err != nil {
+			log.Printf("[%s] Post-scale hook %s failed for %s: %s\n", outcome.Gateway, hook.Name, outcome.Function, err)
+		}
+	}
+}
+
+// runHook sends the payload, rendered by the hook's template if it has one,
+// to a webhook or to a command's stdin and parses the response from the body
+// or stdout
+func runHook(ctx context.Context, hook types.Hook, fn Function, payload interface{}) (HookResponse, error) {
+	response := HookResponse{}
+
+	ctx, cancel := withTimeout(ctx, hook.Timeout)
+	defer cancel()
+
+	body, err := renderPayload(hook.Template, fn, payload)
+	if err != nil {
+		return response, fmt.Errorf("unable to render template: %s", err)
+	}
+
+	var out []byte
+	if len(hook.URL) > 0 {
+		req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+		if err != nil {
+			return response, err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return response, err
+		}
+		defer res.Body.Close()
+
+		out, _ = ioutil.ReadAll(res.Body)
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return response, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+		}
+	} else {
+		cmd := exec.CommandContext(ctx, hook.Exec[0], hook.Exec[1:]...)
+		cmd.Stdin = bytes.NewReader(body)
+
+		out, err = cmd.Output()
+		if err != nil {
+			return response, err
+		}
+	}
+
+	if len(strings.TrimSpace(string(out))) == 0 {
+		return response, nil
+	}
+
+	if err := json.Unmarshal(out, &response); err != nil {
+		return response, fmt.Errorf("invalid response: %s", err)
+	}
+	return response, nil
+}
+
+// selectorMatches is true when the function is in the namespace, if set, and
+// carries all of the labels
+func selectorMatches(namespace string, labels map[string]string, fn Function) bool {
+	if len(namespace) > 0 && namespace != fn.Namespace {
+		return false
+	}
+
+	for key, value := range labels {
+		if labelValue(fn, key) != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_runPreScaleHooks(t *testing.T) {
+	var received ScaleAction
+	keepOne := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte(`{"replicas": 1}`))
+	}))
+	defer keepOne.Close()
+
+	deny := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"allow": false, "reason": "release in progress"}`))
+	}))
+	defer deny.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	fn := Function{
+		Function:  requests.Function{Name: "figlet", Labels: &map[string]string{"cdn": "true"}},
+		Namespace: "dev",
+	}
+	action := ScaleAction{Function: "figlet", Replicas: 3, Target: 0}
+
+	cases := []struct {
+		name       string
+		hooks      []types.Hook
+		wantTarget uint64
+		wantVeto   bool
+	}{
+		{
+			name:       "no hooks",
+			wantTarget: 0,
+		},
+		{
+			name: "webhook mutates target",
+			hooks: []types.Hook{
+				{Name: "keep-one", URL: keepOne.URL, Timeout: time.Second, FailurePolicy: types.FailurePolicyFail},
+			},
+			wantTarget: 1,
+		},
+		{
+			name: "exec allows with empty output",
+			hooks: []types.Hook{
+				{Name: "drain", Exec: []string{"sh", "-c", "cat > /dev/null"}, Timeout: time.Second, FailurePolicy: types.FailurePolicyFail},
+			},
+			wantTarget: 0,
+		},
+		{
+			name: "webhook denies",
+			hooks: []types.Hook{
+				{Name: "keep-one", URL: keepOne.URL, Timeout: time.Second, FailurePolicy: types.FailurePolicyFail},
+				{Name: "calendar", URL: deny.URL, Timeout: time.Second, FailurePolicy: types.FailurePolicyFail},
+			},
+			wantVeto: true,
+		},
+		{
+			name: "failure ignored",
+			hooks: []types.Hook{
+				{Name: "broken", URL: broken.URL, Timeout: time.Second, FailurePolicy: types.FailurePolicyIgnore},
+			},
+			wantTarget: 0,
+		},
+		{
+			name: "failure fails",
+			hooks: []types.Hook{
+				{Name: "broken", Exec: []string{"sh", "-c", "exit 1"}, Timeout: time.Second, FailurePolicy: types.FailurePolicyFail},
+			},
+			wantVeto: true,
+		},
+		{
+			name: "hook for another namespace",
+			hooks: []types.Hook{
+				{Name: "calendar", URL: deny.URL, Namespace: "prod", Timeout: time.Second, FailurePolicy: types.FailurePolicyFail},
+			},
+			wantTarget: 0,
+		},
+	}
+
+	for _, c := range cases {
+		got, err := runPreScaleHooks(context.Background(), c.hooks, fn, action)
+		if c.wantVeto {
+			if _, ok := err.(vetoError); !ok {
+				t.Errorf("%s: wanted veto got: %v", c.name, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+			continue
+		}
+		if got.Target != c.wantTarget {
+			t.Errorf("%s: target wanted: %d got: %d", c.name, c.wantTarget, got.Target)
+		}
+	}
+
+	if received.Function != "figlet" || received.Replicas != 3 {
+		t.Errorf("Hook received unexpected action: %+v", received)
+	}
+}
+
+func Test_runPostScaleHooks(t *testing.T) {
+	outcomes := make(chan ScaleOutcome, 2)
+	statusPage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outcome := ScaleOutcome{}
+		json.NewDecoder(r.Body).Decode(&outcome)
+		outcomes <- outcome
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer statusPage.Close()
+
+	hooks := []types.Hook{
+		{Name: "status-page", URL: statusPage.URL, Timeout: time.Second},
+		{Name: "cmdb", URL: statusPage.URL, Timeout: time.Second},
+	}
+
+	fn := Function{Function: requests.Function{Name: "figlet"}}
+	runPostScaleHooks(context.Background(), hooks, fn, ScaleOutcome{
+		ScaleAction: ScaleAction{Function: "figlet", Replicas: 2, Target: 0},
+		Error:       "unexpected status code scaling figlet: 500",
+	})
+
+	for i := 0; i < len(hooks); i++ {
+		outcome := <-outcomes
+		if outcome.Function != "figlet" || outcome.Success || len(outcome.Error) == 0 {
+			t.Errorf("Hook %d received unexpected outcome: %+v", i, outcome)
+		}
+	}
+}
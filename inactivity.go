@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// inactivityWindow is an entry of inactivity_schedule, the inactivity
+// duration during the minutes matched by any of its cron expressions
+type inactivityWindow struct {
+	exprs    []cronExpr
+	duration time.Duration
+}
+
+// inactivitySchedule holds the windows of inactivity_schedule, the first one
+// matching a time sets the inactivity duration in its time zone
+type inactivitySchedule struct {
+	windows  []inactivityWindow
+	location *time.Location
+}
+
+// parseInactivitySchedule reads inactivity_schedule, a JSON list of
+// {"window": "* 8-17 * * 1-5", "duration": "60m"}, each window being one or
+// more cron expressions separated by ;. An empty value returns nil.
+func parseInactivitySchedule(val string, timezone string) (*inactivitySchedule, error) {
+	val = strings.TrimSpace(val)
+	if len(val) == 0 {
+		return nil, nil
+	}
+
+	schedule := &inactivitySchedule{location: time.Local}
+	if len(timezone) > 0 {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("inactivity_timezone: %s", err)
+		}
+		schedule.location = location
+	}
+
+	entries := []struct {
+		Window   string `json:"window"`
+		Duration string `json:"duration"`
+	}{}
+	if err := json.Unmarshal([]byte(val), &entries); err != nil {
+		return nil, fmt.Errorf("inactivity_schedule: %s", err)
+	}
+
+	for i, entry := range entries {
+		duration, err := time.ParseDuration(entry.Duration)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("inactivity_schedule: entry %d: duration must be greater than 0, got: %q", i, entry.Duration)
+		}
+
+		window := inactivityWindow{duration: duration}
+		for _, item := range strings.Split(entry.Window, ";") {
+			if item = strings.TrimSpace(item); len(item) == 0 {
+				continue
+			}
+			expr, err := parseCron(item)
+			if err != nil {
+				return nil, fmt.Errorf("inactivity_schedule: entry %d: %q: %s", i, item, err)
+			}
+			window.exprs = append(window.exprs, expr)
+		}
+		if len(window.exprs) == 0 {
+			return nil, fmt.Errorf("inactivity_schedule: entry %d: no cron expression in %q", i, entry.Window)
+		}
+		schedule.windows = append(schedule.windows, window)
+	}
+
+	if len(schedule.windows) == 0 {
+		return nil, fmt.Errorf("inactivity_schedule: no window in %q", val)
+	}
+	return schedule, nil
+}
+
+// at returns the inactivity duration of the first window matching the given
+// time, or the default when none does
+func (s *inactivitySchedule) at(t time.Time, defaultVal time.Duration) time.Duration {
+	if s == nil {
+		return defaultVal
+	}
+
+	local := t.In(s.location)
+	for _, window := range s.windows {
+		for _, expr := range window.exprs {
+			if expr.matches(local) {
+				return window.duration
+			}
+		}
+	}
+	return defaultVal
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_inactivitySchedule(t *testing.T) {
+	schedule, err := parseInactivitySchedule(`[
+		{"window": "* 8-17 * * 1-5", "duration": "60m"},
+		{"window": "* 0-6 * * *; * 20-23 * * *", "duration": "5m"},
+		{"window": "* * * * 1-5", "duration": "30m"}
+	]`, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		at   time.Time
+		want time.Duration
+	}{
+		{"working hours", time.Date(2019, time.April, 1, 9, 0, 0, 0, time.UTC), time.Minute * 60},
+		{"overnight", time.Date(2019, time.April, 1, 3, 0, 0, 0, time.UTC), time.Minute * 5},
+		{"second expression", time.Date(2019, time.April, 6, 21, 0, 0, 0, time.UTC), time.Minute * 5},
+		{"first match wins", time.Date(2019, time.April, 1, 18, 30, 0, 0, time.UTC), time.Minute * 30},
+		{"no window", time.Date(2019, time.April, 6, 12, 0, 0, 0, time.UTC), time.Minute * 15},
+		// 09:00 at UTC-5 is 14:00 in UTC
+		{"in the time zone", time.Date(2019, time.April, 6, 9, 0, 0, 0, time.FixedZone("EST", -5*3600)), time.Minute * 15},
+	}
+	for _, c := range cases {
+		if got := schedule.at(c.at, time.Minute*15); got != c.want {
+			t.Errorf("%s: duration wanted: %s got: %s", c.name, c.want, got)
+		}
+	}
+
+	var none *inactivitySchedule
+	if got := none.at(time.Now(), time.Minute*15); got != time.Minute*15 {
+		t.Errorf("An empty inactivity_schedule should keep the default, got: %s", got)
+	}
+
+	for _, val := range []string{
+		`{"window": "* * * * *"}`,
+		`[{"window": "* * * * *", "duration": "soon"}]`,
+		`[{"window": "* * * * *", "duration": "0s"}]`,
+		`[{"window": "8-18 * * 1-5", "duration": "5m"}]`,
+		`[{"window": ";", "duration": "5m"}]`,
+		`[]`,
+	} {
+		if _, err := parseInactivitySchedule(val, ""); err == nil {
+			t.Errorf("Had to have errors due to inactivity_schedule: %s", val)
+		}
+	}
+}
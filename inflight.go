@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/types"
+)
+
+// inflightQuery renders inflight_query for a function, $function and
+// $namespace are replaced by its name and namespace
+func inflightQuery(fn Function, config types.Config) string {
+	query := strings.Replace(config.InflightQuery, "$function", fn.Name, -1)
+	return strings.Replace(query, "$namespace", fn.Namespace, -1)
+}
+
+// queryInflight returns the requests still executing for a function, the sum
+// of every series returned by inflight_query
+func queryInflight(ctx context.Context, client *http.Client, fn Function, config types.Config) (float64, error) {
+	inflight, _, err := queryValue(ctx, client, inflightQuery(fn, config), config)
+	return inflight, err
+}
+
+// queryValue runs an instant query and returns the sum of every series it
+// returned, found is false when it returned none
+func queryValue(ctx context.Context, client *http.Client, promQL string, config types.Config) (float64, bool, error) {
+	res, err := fetchPrometheus(ctx, client, promQL, config)
+	if err != nil {
+		return 0, false, err
+	}
+
+	sum, found := float64(0), false
+	for _, v := range res.Data.Result {
+		f, ok, err := vectorValue(v.Value)
+		if err != nil {
+			return 0, false, err
+		}
+		if ok {
+			sum += f
+			found = true
+		}
+	}
+	return sum, found, nil
+}
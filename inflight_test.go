@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_queryInflight(t *testing.T) {
+	var received string
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.URL.Query().Get("query")
+		w.Write([]byte(`{"data": {"result": [{"metric": {}, "value": [1546300800, "1"]}, {"metric": {}, "value": [1546300800, "2"]}]}}`))
+	}))
+	defer prometheus.Close()
+
+	parsed, _ := url.Parse(prometheus.URL)
+	host, portVal, _ := net.SplitHostPort(parsed.Host)
+	port, _ := strconv.Atoi(portVal)
+
+	config := types.Config{
+		PrometheusHost: host,
+		PrometheusPort: port,
+		InflightQuery:  `sum(http_requests_in_flight{function="$function", namespace="$namespace"})`,
+	}
+	fn := Function{Function: requests.Function{Name: "figlet"}, Namespace: "dev"}
+
+	inflight, err := queryInflight(context.Background(), &http.Client{}, fn, config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if want := `sum(http_requests_in_flight{function="figlet", namespace="dev"})`; received != want {
+		t.Errorf("Query wanted: %s got: %s", want, received)
+	}
+	if inflight != 3 {
+		t.Errorf("In-flight wanted: %f got: %f", float64(3), inflight)
+	}
+}
+
+func Test_scaleDown_inflight(t *testing.T) {
+	inflight := "2"
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"result": [{"metric": {}, "value": [1546300800, "` + inflight + `"]}]}}`))
+	}))
+	defer prometheus.Close()
+
+	scales := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scales++
+	}))
+	defer server.Close()
+
+	parsed, _ := url.Parse(prometheus.URL)
+	host, portVal, _ := net.SplitHostPort(parsed.Host)
+	port, _ := strconv.Atoi(portVal)
+
+	gateway := newGateway(types.Config{
+		Name:                "test",
+		GatewayURL:          server.URL + "/",
+		PrometheusHost:      host,
+		PrometheusPort:      port,
+		RequireZeroInflight: true,
+		InflightQuery:       types.DefaultInflightQuery,
+	})
+	fn := Function{Function: requests.Function{Name: "figlet"}}
+	summary := newCycleSummary("test", time.Now())
+
+	scaleDown(context.Background(), gateway, fn, 0, 0, 1, 0, summary)
+	if scales != 0 || summary.Skipped[skipInflight] != 1 {
+		t.Errorf("A function with requests in flight should not be scaled, scales: %d skipped: %v", scales, summary.Skipped)
+	}
+
+	inflight = "0"
+	scaleDown(context.Background(), gateway, fn, 0, 0, 1, 0, summary)
+	if scales != 1 || summary.Idled != 1 {
+		t.Errorf("A function without requests in flight should be scaled, scales: %d", scales)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/types"
+)
+
+// influxProvider reads the gateway's invocation counters written to InfluxDB,
+// i.e. by Telegraf scraping the gateway, through its InfluxQL query API
+type influxProvider struct {
+	client *http.Client
+	config types.Config
+}
+
+// newInfluxProvider ignores the client carrying the Prometheus headers
+func newInfluxProvider(config types.Config, _ *http.Client) MetricsProvider {
+	return &influxProvider{
+		client: newHeaderClient(config.UserAgent, nil, nil),
+		config: config,
+	}
+}
+
+// influxResponse is the body of /query
+type influxResponse struct {
+	Results []struct {
+		Series []struct {
+			Tags   map[string]string `json:"tags"`
+			Values [][]interface{}   `json:"values"`
+		} `json:"series"`
+		Error string `json:"error"`
+	} `json:"results"`
+	Error string `json:"error"`
+}
+
+func (p *influxProvider) Activity(ctx context.Context, function string, window time.Duration) (float64, bool, error) {
+	rates, err := p.rates(ctx, window, function)
+	if err != nil {
+		return 0, false, err
+	}
+	rate, found := rates[function]
+	return rate, found, nil
+}
+
+func (p *influxProvider) ActivityBatch(ctx context.Context, functions []string, window time.Duration) (map[string]float64, bool, error) {
+	rates, err := p.rates(ctx, window, "")
+	return rates, true, err
+}
+
+// influxQuery is the growth of the invocation counter over the window by
+// function and status code, of a single function unless it is empty. SPREAD
+// does not account for counter resets, which only make a function look more
+// active than it was.
+func influxQuery(function string, window time.Duration, config types.Config) string {
+	where := fmt.Sprintf(`time > now() - %ds`, int64((window + config.QueryOffset).Seconds()))
+	if config.QueryOffset > 0 {
+		where += fmt.Sprintf(` AND time <= now() - %ds`, int64(config.QueryOffset.Seconds()))
+	}
+	if len(function) > 0 {
+		where += ` AND "function_name" = '` + strings.Replace(function, `'`, `\'`, -1) + `'`
+	}
+
+	return `SELECT SPREAD("` + config.InfluxDBField + `") FROM "` + config.InfluxDBMeasurement + `" WHERE ` + where + ` GROUP BY "function_name", "code"`
+}
+
+// rates returns the invocation rate over the window by function
+func (p *influxProvider) rates(ctx context.Context, window time.Duration, function string) (map[string]float64, error) {
+	values := url.Values{}
+	values.Set("db", p.config.InfluxDBDatabase)
+	values.Set("q", influxQuery(function, window, p.config))
+	values.Set("epoch", "s")
+
+	req, err := http.NewRequest(http.MethodGet, p.config.InfluxDBURL+"/query?"+values.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.config.InfluxDBToken) > 0 {
+		req.Header.Set("Authorization", "Token "+p.config.InfluxDBToken)
+	} else if len(p.config.InfluxDBUsername) > 0 {
+		req.SetBasicAuth(p.config.InfluxDBUsername, p.config.InfluxDBPassword)
+	}
+
+	res, err := boundedClient(ctx, p.client, p.config.PrometheusTimeout).Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	bytesOut, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status code from InfluxDB want: %d, got: %d, body: %s", http.StatusOK, res.StatusCode, string(bytesOut))
+	}
+
+	body := influxResponse{}
+	if err := json.Unmarshal(bytesOut, &body); err != nil {
+		return nil, fmt.Errorf("Error unmarshaling result: %s, '%s'", err, string(bytesOut))
+	}
+	if len(body.Error) > 0 {
+		return nil, fmt.Errorf("InfluxDB query failed: %s", body.Error)
+	}
+
+	rates := make(map[string]float64)
+	for _, result := range body.Results {
+		if len(result.Error) > 0 {
+			return nil, fmt.Errorf("InfluxDB query failed: %s", result.Error)
+		}
+
+		for _, series := range result.Series {
+			name := series.Tags["function_name"]
+			if len(name) == 0 || (len(function) > 0 && name != function) {
+				continue
+			}
+
+			for _, row := range series.Values {
+				if len(row) < 2 {
+					continue
+				}
+				if spread, ok := row[1].(float64); ok {
+					rates[name] += spread / window.Seconds()
+				}
+			}
+		}
+	}
+	return rates, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_influxQuery(t *testing.T) {
+	config := types.Config{InfluxDBMeasurement: "gateway_function_invocation_total", InfluxDBField: "counter", QueryOffset: time.Minute}
+
+	want := `SELECT SPREAD("counter") FROM "gateway_function_invocation_total" WHERE time > now() - 360s AND time <= now() - 60s AND "function_name" = 'fig\'let' GROUP BY "function_name", "code"`
+	if got := influxQuery("fig'let", time.Minute*5, config); got != want {
+		t.Errorf("Query wanted: %s got: %s", want, got)
+	}
+}
+
+func Test_influxProvider(t *testing.T) {
+	var received *http.Request
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		w.Write([]byte(`{"results": [{"statement_id": 0, "series": [
+			{"name": "gateway_function_invocation_total", "tags": {"code": "200", "function_name": "figlet"}, "columns": ["time", "spread"], "values": [[0, 60]]},
+			{"name": "gateway_function_invocation_total", "tags": {"code": "500", "function_name": "figlet"}, "columns": ["time", "spread"], "values": [[0, 30]]},
+			{"name": "gateway_function_invocation_total", "tags": {"code": "200", "function_name": "nodeinfo"}, "columns": ["time", "spread"], "values": [[0, 0]]}]}]}`))
+	}))
+	defer influx.Close()
+
+	config := types.Config{
+		MetricsProvider:     types.MetricsProviderInfluxDB,
+		InfluxDBURL:         influx.URL,
+		InfluxDBDatabase:    "telegraf",
+		InfluxDBToken:       "token",
+		InfluxDBMeasurement: "gateway_function_invocation_total",
+		InfluxDBField:       "counter",
+	}
+	provider := newMetricsProvider(config, &http.Client{})
+
+	rates, ok, err := provider.(BatchMetricsProvider).ActivityBatch(context.Background(), []string{"figlet", "nodeinfo"}, time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rates) != 2 || rates["figlet"] != 1.5 || rates["nodeinfo"] != 0 {
+		t.Errorf("Rates wanted figlet: 1.5 nodeinfo: 0 got: %v", rates)
+	}
+	if received.URL.Query().Get("db") != "telegraf" || received.Header.Get("Authorization") != "Token token" {
+		t.Errorf("Unexpected request: %s %v", received.URL, received.Header)
+	}
+
+	if _, found, _ := provider.Activity(context.Background(), "missing", time.Minute); found {
+		t.Errorf("A function without series should have no data")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/types"
+)
+
+// topicAnnotation lists the topics the Kafka connector invokes a function
+// for, comma-separated
+const topicAnnotation = "topic"
+
+// kafkaLags is the response of the Kafka REST Proxy for the lag of each
+// partition consumed by a consumer group
+type kafkaLags struct {
+	Data []struct {
+		TopicName string  `json:"topic_name"`
+		Lag       float64 `json:"lag"`
+	} `json:"data"`
+}
+
+// functionTopics returns the topics of a function's topic annotation
+func functionTopics(fn Function) map[string]bool {
+	topics := make(map[string]bool)
+	for _, topic := range strings.Split(annotationValue(fn, topicAnnotation), ",") {
+		if topic = strings.TrimSpace(topic); len(topic) > 0 {
+			topics[topic] = true
+		}
+	}
+	return topics
+}
+
+// queryKafkaLag returns the messages the connector's consumer group has not
+// consumed yet from the topics of a function, zero for a function without
+// topics, which the connector does not invoke
+func queryKafkaLag(ctx context.Context, fn Function, config types.Config) (float64, error) {
+	topics := functionTopics(fn)
+	if len(topics) == 0 {
+		return 0, nil
+	}
+
+	endpoint := strings.TrimSuffix(config.KafkaAdminURL, "/") + "/v3/clusters/" + url.PathEscape(config.KafkaClusterID) +
+		"/consumer-groups/" + url.PathEscape(config.KafkaConsumerGroup) + "/lags"
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	queryCtx, cancel := withTimeout(ctx, config.PrometheusTimeout)
+	defer cancel()
+
+	body, err := doRequest(queryCtx, newHeaderClient(config.UserAgent, nil, nil), req, "Kafka")
+	if err != nil {
+		return 0, err
+	}
+
+	lags := kafkaLags{}
+	if err := json.Unmarshal(body, &lags); err != nil {
+		return 0, err
+	}
+
+	lag := float64(0)
+	for _, partition := range lags.Data {
+		if topics[partition.TopicName] {
+			lag += partition.Lag
+		}
+	}
+	return lag, nil
+}
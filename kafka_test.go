@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_queryKafkaLag(t *testing.T) {
+	requested := 0
+	kafka := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested++
+		if r.URL.Path != "/v3/clusters/lkc-1/consumer-groups/connector/lags" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"kind": "KafkaConsumerLagList", "data": [
+			{"topic_name": "payment.received", "partition_id": 0, "lag": 3},
+			{"topic_name": "payment.received", "partition_id": 1, "lag": 2},
+			{"topic_name": "order.placed", "partition_id": 0, "lag": 0}]}`))
+	}))
+	defer kafka.Close()
+
+	config := types.Config{KafkaAdminURL: kafka.URL, KafkaClusterID: "lkc-1", KafkaConsumerGroup: "connector"}
+
+	cases := []struct {
+		name   string
+		topics string
+		want   float64
+	}{
+		{"lag of each partition", "payment.received", 5},
+		{"lag of each topic", "order.placed, payment.received", 5},
+		{"consumed topic", "order.placed", 0},
+	}
+	for _, c := range cases {
+		fn := Function{Function: requests.Function{Name: "figlet"}, Annotations: &map[string]string{topicAnnotation: c.topics}}
+		lag, err := queryKafkaLag(context.Background(), fn, config)
+		if err != nil || lag != c.want {
+			t.Errorf("%s: lag wanted: %f got: %f %v", c.name, c.want, lag, err)
+		}
+	}
+
+	requested = 0
+	if lag, err := queryKafkaLag(context.Background(), Function{Function: requests.Function{Name: "figlet"}}, config); err != nil || lag != 0 || requested != 0 {
+		t.Errorf("A function without topics should not be looked up, got: %f %v", lag, err)
+	}
+}
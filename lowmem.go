@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// lowMemory trades CPU for memory on small devices such as a Raspberry Pi
+// running faasd
+var lowMemory bool
+
+// lowMemoryGCPercent collects garbage once the heap grew by a fifth rather
+// than doubled
+const lowMemoryGCPercent = 20
+
+// enableLowMemory makes the garbage collector run more often and returns it
+// to the OS sooner
+func enableLowMemory() {
+	lowMemory = true
+	debug.SetGCPercent(lowMemoryGCPercent)
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			debug.FreeOSMemory()
+		}
+	}()
+
+	log.Printf("Low-memory mode enabled\n")
+}
+
+// newTransport keeps at most one idle connection per host in low-memory mode,
+// tlsConfig replaces the default verification of TLS connections when set
+func newTransport(tlsConfig *tls.Config) http.RoundTripper {
+	if !lowMemory && tlsConfig == nil {
+		return http.DefaultTransport
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+	if lowMemory {
+		transport.MaxIdleConns = 2
+		transport.MaxIdleConnsPerHost = 1
+		transport.IdleConnTimeout = time.Second * 30
+	}
+	return transport
+}
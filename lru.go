@@ -0,0 +1,124 @@
+package main
+
+import (
+	"container/list"
+	"time"
+)
+
+// lruCache is a map bounded in size and age, evicting the least recently set
+// entry once full and any entry not set for longer than its ttl. It is not
+// safe for concurrent use, the stores using it hold their own lock.
+type lruCache struct {
+	maxEntries int
+	ttl        time.Duration
+
+	// onEvict is called for entries evicted for size, age or by retain, not
+	// for those removed explicitly
+	onEvict func(key string, value interface{})
+
+	order   *list.List
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	updated time.Time
+}
+
+// newLRUCache creates a cache, a maxEntries or ttl of zero leaves it
+// unbounded in that dimension
+func newLRUCache(maxEntries int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.expire()
+
+	element, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+	return element.Value.(*lruEntry).value, true
+}
+
+// set stores the value as the most recently used entry
+func (c *lruCache) set(key string, value interface{}) {
+	now := c.now()
+
+	if element, exists := c.entries[key]; exists {
+		entry := element.Value.(*lruEntry)
+		entry.value = value
+		entry.updated = now
+		c.order.MoveToFront(element)
+	} else {
+		c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, updated: now})
+	}
+
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.evict(c.order.Back())
+	}
+	c.expire()
+}
+
+func (c *lruCache) remove(key string) {
+	if element, exists := c.entries[key]; exists {
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+// retain evicts every entry whose key is not in names
+func (c *lruCache) retain(names map[string]bool) {
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		if !names[element.Value.(*lruEntry).key] {
+			c.evict(element)
+		}
+		element = next
+	}
+}
+
+// each visits the live entries from the most to the least recently used
+func (c *lruCache) each(visit func(key string, value interface{})) {
+	c.expire()
+
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*lruEntry)
+		visit(entry.key, entry.value)
+	}
+}
+
+func (c *lruCache) len() int {
+	c.expire()
+	return c.order.Len()
+}
+
+// expire evicts entries older than the ttl, which are at the back
+func (c *lruCache) expire() {
+	if c.ttl <= 0 {
+		return
+	}
+
+	cutoff := c.now().Add(-c.ttl)
+	for element := c.order.Back(); element != nil && element.Value.(*lruEntry).updated.Before(cutoff); element = c.order.Back() {
+		c.evict(element)
+	}
+}
+
+func (c *lruCache) evict(element *list.Element) {
+	entry := element.Value.(*lruEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.key)
+
+	if c.onEvict != nil {
+		c.onEvict(entry.key, entry.value)
+	}
+}
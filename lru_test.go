@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_lruCache(t *testing.T) {
+	now := time.Now()
+	cache := newLRUCache(2, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	evicted := []string{}
+	cache.onEvict = func(key string, value interface{}) {
+		evicted = append(evicted, key)
+	}
+
+	cache.set("a", 1)
+	cache.set("b", 2)
+	cache.set("a", 3)
+	cache.set("c", 4)
+
+	if _, exists := cache.get("b"); exists {
+		t.Errorf("The least recently set entry should be evicted once full")
+	}
+	if value, _ := cache.get("a"); value != 3 {
+		t.Errorf("Value wanted: %d got: %v", 3, value)
+	}
+
+	now = now.Add(time.Second * 30)
+	cache.set("a", 5)
+	now = now.Add(time.Second * 45)
+
+	if _, exists := cache.get("c"); exists {
+		t.Errorf("Entries older than the ttl should expire")
+	}
+	if cache.len() != 1 {
+		t.Errorf("Length wanted: %d got: %d", 1, cache.len())
+	}
+
+	cache.retain(map[string]bool{})
+	cache.set("d", 6)
+	cache.remove("d")
+
+	if want := []string{"b", "c", "a"}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("Evicted wanted: %v got: %v", want, evicted)
+	}
+	if cache.len() != 0 {
+		t.Errorf("Length wanted: %d got: %d", 0, cache.len())
+	}
+}
@@ -2,22 +2,25 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
 	"net/http"
-	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/types"
 
 	providerTypes "github.com/openfaas/faas-provider/types"
-	"github.com/openfaas/faas/gateway/metrics"
 	"github.com/openfaas/faas/gateway/requests"
 )
 
@@ -25,68 +28,377 @@ const scaleLabel = "com.openfaas.scale.zero"
 
 var dryRun bool
 
+var observe bool
+
 var writeDebug bool
 
+var once bool
+
+// scaleTarget overrides scale_target when set to zero or more
+var scaleTarget int
+
+// background tracks notifications and post-scale hooks still being delivered
+var background sync.WaitGroup
+
 type Credentials struct {
 	Username string
 	Password string
 }
 
-func main() {
-	config, configErr := types.ReadConfig()
-	if configErr != nil {
-		log.Panic(configErr.Error())
-		os.Exit(1)
-	}
+// Function is a function's status as listed by the gateway, including fields
+// only reported by newer providers
+type Function struct {
+	requests.Function
 
-	flag.BoolVar(&dryRun, "dry-run", false, "use dry-run for scaling events")
-	flag.Parse()
+	Namespace   string                      `json:"namespace"`
+	Annotations *map[string]string          `json:"annotations"`
+	Requests    *requests.FunctionResources `json:"requests"`
+}
 
-	if val, ok := os.LookupEnv("write_debug"); ok && (val == "1" || val == "true") {
-		writeDebug = true
-	}
+// Gateway is a gateway reconciled by the idler along with the Prometheus
+// source and policy from its configuration
+type Gateway struct {
+	Name        string
+	Config      types.Config
+	Credentials *Credentials
+	Client      *http.Client
+	// PrometheusClient carries the headers configured for Prometheus
+	PrometheusClient *http.Client
+
+	failover failover
+	status   *statusStore
+	drains   *drainStore
+	wakes    *wakeStore
+	prewarms *prewarmStore
+	// noIdle excludes the times of no_idle_schedule from scaling down
+	noIdle   *idleExclusion
+	calendar *idleCalendar
+	activity *activityStore
+	counters *counterStore
+	streaks  *streakStore
+	flaps    *flapStore
+	tuning   *tuneStore
+	replicas *replicaStore
+	notifier *notifier
+	metrics  MetricsProvider
+
+	// kubernetes finds the functions scaled by an HPA or ScaledObject
+	kubernetes *kubernetesClient
+	// inactivity sets inactivity_duration by time of day
+	inactivity *inactivitySchedule
+	// candidates holds the functions marked idle with confirm_scale_down
+	candidates *candidateStore
+}
 
+func newGateway(config types.Config) *Gateway {
 	credentials := Credentials{}
 
-	val, err := readFile("/var/secrets/basic-auth-user")
+	val, err := readFile(config.BasicAuthUserFile)
 	if err == nil {
 		credentials.Username = val
 	} else {
 		log.Printf("Unable to read username: %s", err)
 	}
 
-	passwordVal, passErr := readFile("/var/secrets/basic-auth-password")
+	passwordVal, passErr := readFile(config.BasicAuthPasswordFile)
 	if passErr == nil {
 		credentials.Password = passwordVal
 	} else {
 		log.Printf("Unable to read password: %s", err)
 	}
 
-	client := &http.Client{}
-	version, err := getVersion(client, config.GatewayURL, &credentials)
+	// Statuses are only read through the admin server
+	status := newStatusStore(config.StateMaxFunctions, config.StateTTL)
+	status.disabled = lowMemory && config.AdminPort == 0
 
-	if err != nil {
-		panic(err)
+	prometheusClient := newHeaderClient(config.UserAgent, prometheusHeaders(config), prometheusTLSConfig(config))
+	if len(config.PrometheusSigV4Region) > 0 {
+		// Amazon Managed Service for Prometheus
+		prometheusClient.Transport = newSigV4Transport(prometheusClient.Transport, config.PrometheusSigV4Region, "aps", credentialClient(config))
+	}
+	if source := prometheusTokenSource(config); source != nil {
+		prometheusClient.Transport = &tokenTransport{source: source, next: prometheusClient.Transport}
+	}
+
+	return &Gateway{
+		Name:             config.Name,
+		Config:           config,
+		Credentials:      &credentials,
+		Client:           newHeaderClient(config.UserAgent, config.GatewayHeaders, nil),
+		PrometheusClient: prometheusClient,
+		failover:         newFailover(config.GatewayURL, config.FallbackGatewayURL),
+		status:           status,
+		drains:           newDrainStore(config.StateMaxFunctions, config.StateTTL),
+		wakes:            newWakeStore(config.StateMaxFunctions, config.StateTTL),
+		prewarms:         newPrewarmStore(config.StateMaxFunctions, config.StateTTL),
+		activity:         newActivityStore(config.StateMaxFunctions, config.StateTTL),
+		counters:         newCounterStore(config.StateMaxFunctions, config.StateTTL),
+		streaks:          newStreakStore(config.StateMaxFunctions, config.StateTTL),
+		flaps:            newFlapStore(config.Name, config.StateMaxFunctions, config.StateTTL),
+		tuning:           newTuneStore(config.StateMaxFunctions, config.StateTTL),
+		replicas:         newReplicaStore(config.StateMaxFunctions, config.StateTTL),
+		candidates:       newCandidateStore(config.StateMaxFunctions, config.StateTTL),
+		notifier:         newNotifier(config),
+		metrics:          newMetricsProvider(config, prometheusClient),
+	}
+}
+
+func main() {
+	flag.BoolVar(&dryRun, "dry-run", false, "use dry-run for scaling events")
+	flag.BoolVar(&observe, "observe", false, "never scale, only export scale to zero recommendations")
+	flag.BoolVar(&once, "once", false, "run a single reconcile pass, print a JSON summary and exit")
+	flag.IntVar(&scaleTarget, "scale-target", -1, "replicas to scale idle functions down to, overrides scale_target")
+	flag.Parse()
+
+	// bench and chaos point the configuration at their own synthetic fleet
+	switch flag.Arg(0) {
+	case "bench":
+		os.Exit(runBench(flag.Args()[1:], os.Stdout))
+	case "chaos":
+		os.Exit(runChaos(flag.Args()[1:], os.Stdout))
 	}
 
-	log.Printf("Gateway version: %s, SHA: %s\n", version.Version.Release, version.Version.SHA)
+	config, configErr := types.ReadConfig()
+	if configErr != nil {
+		log.Panic(configErr.Error())
+		os.Exit(1)
+	}
+	if scaleTarget >= 0 {
+		config.ScaleTarget = uint64(scaleTarget)
+		for i := range config.Gateways {
+			config.Gateways[i].ScaleTarget = config.ScaleTarget
+		}
+	}
+
+	// In -once mode stdout only carries the JSON summary
+	summaryOut := os.Stdout
+	if once {
+		os.Stdout = os.Stderr
+	}
+
+	rand.Seed(time.Now().UnixNano())
+
+	if val, ok := os.LookupEnv("write_debug"); ok && (val == "1" || val == "true") {
+		writeDebug = true
+	}
+
+	if config.LowMemory {
+		enableLowMemory()
+	}
+
+	if len(config.AuditLogFile) > 0 {
+		auditLog, auditErr := openAuditLog(config.AuditLogFile, config.AuditRetention, config.AuditMaxSize)
+		if auditErr != nil {
+			log.Panic(auditErr.Error())
+		}
+		audit = auditLog
+		if (config.AuditRetention > 0 || config.AuditMaxSize > 0) && !once {
+			go runAuditCompaction(audit)
+		}
+	}
+
+	var policySource *gitPolicySource
+	if len(config.PolicyGitURL) > 0 {
+		policyFiles = &policyStore{}
+		policySource = newGitPolicySource(config, policyFiles)
+		if err := policySource.sync(); err != nil {
+			log.Printf("Unable to sync policies from %s: %s\n", redactURL(config.PolicyGitURL), err)
+		}
+	}
+
+	reconcileSchedule, scheduleErr := parseSchedule(config.ReconcileSchedule, config.ReconcileInterval)
+	if scheduleErr != nil {
+		log.Panic(scheduleErr.Error())
+	}
+
+	noIdle, exclusionErr := parseIdleExclusion(config.NoIdleSchedule, config.NoIdleTimezone)
+	if exclusionErr != nil {
+		log.Panic(exclusionErr.Error())
+	}
+
+	calendar, calendarErr := parseIdleCalendar(config.CalendarFile, config.CalendarTimezone)
+	if calendarErr != nil {
+		log.Panic(calendarErr.Error())
+	}
+
+	inactivity, inactivityErr := parseInactivitySchedule(config.InactivitySchedule, config.InactivityTimezone)
+	if inactivityErr != nil {
+		log.Panic(inactivityErr.Error())
+	}
+
+	var kubernetes *kubernetesClient
+	if config.KubernetesAutoscalers || len(config.ReplicasConfigMap) > 0 {
+		kubernetes = newKubernetesClient(config)
+	}
+
+	var adaptive *adaptiveSchedule
+	if interval, fixed := reconcileSchedule.(intervalSchedule); fixed {
+		reconcileInterval.Set(interval.interval.Seconds())
+		if config.MaxReconcileInterval > 0 {
+			adaptive = newAdaptiveSchedule(interval.interval, config.MinReconcileInterval, config.MaxReconcileInterval, config.ReconcileIntervalPerFunction)
+			reconcileSchedule = adaptive
+		}
+	} else if config.MaxReconcileInterval > 0 {
+		log.Printf("max_reconcile_interval is ignored with a cron reconcile_schedule\n")
+	}
+
+	switch flag.Arg(0) {
+	case "":
+	case "export-policies":
+		os.Exit(runExportPolicies(config))
+	case "gen-rules":
+		os.Exit(runGenRules(config))
+	case "smoke":
+		os.Exit(runSmoke(config, flag.Args()[1:], os.Stdout))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", flag.Arg(0))
+		os.Exit(1)
+	}
+
+	gateways := []*Gateway{}
+	for _, gatewayConfig := range config.Targets() {
+		gateway := newGateway(gatewayConfig)
+		gateway.noIdle = noIdle
+		gateway.calendar = calendar
+		gateway.inactivity = inactivity
+		gateway.kubernetes = kubernetes
+		gateway.checkHealth(context.Background())
+
+		version, err := getVersion(gateway.Client, gateway.URL(), gateway.Credentials)
+
+		if err != nil {
+			panic(err)
+		}
+
+		log.Printf("Gateway %s version: %s, SHA: %s\n", gateway.Name, version.Version.Release, version.Version.SHA)
+
+		gateways = append(gateways, gateway)
+	}
 
 	fmt.Printf(`dry_run: %t
+observe: %t`, dryRun, observe)
+	for _, gateway := range gateways {
+		fmt.Printf(`
 gateway_url: %s
-inactivity_duration: %s `, dryRun, config.GatewayURL, config.InactivityDuration)
+fallback_gateway_url: %s
+inactivity_duration: %s `, gateway.Config.GatewayURL, gateway.Config.FallbackGatewayURL, gateway.Config.InactivityDuration)
+	}
 
-	if len(config.GatewayURL) == 0 {
-		fmt.Println("gateway_url (faas-netes/faas-swarm) is required.")
-		os.Exit(1)
+	if len(config.ActivitySnapshotFile) > 0 {
+		if err := loadActivity(config.ActivitySnapshotFile, gateways); err != nil {
+			log.Printf("Unable to load activity snapshot from %s: %s\n", config.ActivitySnapshotFile, err)
+		}
+	}
+
+	if len(config.AutoTuneFile) > 0 {
+		if err := loadTuning(config.AutoTuneFile, gateways); err != nil {
+			log.Printf("Unable to load learned inactivity durations from %s: %s\n", config.AutoTuneFile, err)
+		}
 	}
 
+	if once {
+		fmt.Printf("\n")
+		code := runOnce(summaryOut, gateways)
+		if len(config.ActivitySnapshotFile) > 0 {
+			if err := saveActivity(config.ActivitySnapshotFile, gateways); err != nil {
+				log.Printf("Unable to save activity snapshot to %s: %s\n", config.ActivitySnapshotFile, err)
+			}
+		}
+		if len(config.AutoTuneFile) > 0 {
+			if err := saveTuning(config.AutoTuneFile, gateways); err != nil {
+				log.Printf("Unable to save learned inactivity durations to %s: %s\n", config.AutoTuneFile, err)
+			}
+		}
+		os.Exit(code)
+	}
+
+	if len(config.ActivitySnapshotFile) > 0 && config.ActivitySnapshotInterval > 0 {
+		go runActivitySnapshots(config.ActivitySnapshotFile, config.ActivitySnapshotInterval, gateways)
+	}
+
+	if policySource != nil {
+		go policySource.run()
+	}
+
+	if config.AdminPort > 0 {
+		go serveAdmin(config.AdminPort, gateways, policySource)
+	}
+
+	var usage *telemetry
+	if len(config.TelemetryURL) > 0 {
+		usage = newTelemetry(config, len(gateways))
+		go usage.run()
+	}
+
+	scheduler := newSweepScheduler(reconcileSchedule, config.NamespaceSchedules)
 	for {
-		reconcile(client, config, &credentials)
-		time.Sleep(config.ReconcileInterval)
+		due := scheduler.due(time.Now())
+		if adaptive != nil {
+			due.timeout = adaptive.interval
+		}
+
+		summaries := reconcileAll(gateways, due)
+		if len(config.AutoTuneFile) > 0 {
+			if err := saveTuning(config.AutoTuneFile, gateways); err != nil {
+				log.Printf("Unable to save learned inactivity durations to %s: %s\n", config.AutoTuneFile, err)
+			}
+		}
+		if usage != nil && due.global {
+			usage.record(summaries)
+		}
+		if adaptive != nil && due.global {
+			longest, functions := time.Duration(0), 0
+			for _, summary := range summaries {
+				if d := time.Duration(summary.Durations.Total * float64(time.Second)); d > longest {
+					longest = d
+				}
+				functions += summary.Evaluated
+			}
+			adaptive.record(longest, functions)
+		}
+		scheduler.advance(due, time.Now())
+
+		next := scheduler.wakeAt()
+		if next.IsZero() {
+			log.Panic("reconcile_schedule never matches again")
+		}
+		time.Sleep(time.Until(next))
 		fmt.Printf("\n")
 	}
 }
 
+// reconcileAll runs a reconcile pass against every gateway concurrently and
+// waits for all of them to finish, returning their summaries in order.
+func reconcileAll(gateways []*Gateway, due sweep) []*cycleSummary {
+	summaries := make([]*cycleSummary, len(gateways))
+
+	wg := sync.WaitGroup{}
+	for i, gateway := range gateways {
+		wg.Add(1)
+
+		go func(i int, gateway *Gateway) {
+			defer wg.Done()
+
+			timeout := gateway.Config.ReconcileInterval
+			if due.timeout > 0 {
+				timeout = due.timeout
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			summary := reconcile(ctx, gateway, due)
+			reconcileDuration.WithLabelValues(gateway.Name).Set(summary.Durations.Total)
+			summary.log()
+			gateway.status.setCycle(summary)
+			summaries[i] = summary
+		}(i, gateway)
+	}
+	wg.Wait()
+
+	return summaries
+}
+
 func readFile(path string) (string, error) {
 	if _, err := os.Stat(path); err == nil {
 		data, readErr := ioutil.ReadFile(path)
@@ -95,100 +407,570 @@ func readFile(path string) (string, error) {
 	return "", nil
 }
 
-func buildMetricsMap(client *http.Client, functions []requests.Function, config types.Config) map[string]float64 {
-	query := metrics.NewPrometheusQuery(config.PrometheusHost, config.PrometheusPort, client)
-	metrics := make(map[string]float64)
+// buildMetricsMap asks the gateway's MetricsProvider for the activity of every
+// function over its window, functions it holds no data for are left out
+func buildMetricsMap(ctx context.Context, provider MetricsProvider, functions []Function, policies map[string]Policy, history time.Duration, config types.Config, summary *cycleSummary) (map[string]float64, map[string]bool) {
+	metricsMap := make(map[string]float64)
+	unknown := make(map[string]bool)
 
-	duration := fmt.Sprintf("%dm", int(config.InactivityDuration.Minutes()))
-	// duration := "5m"
+	beyondHistory := []string{}
+	defer func() {
+		if len(beyondHistory) > 0 {
+			log.Printf("[%s] inactivity_duration of %d function(s) exceeds the %s of data held by Prometheus, long_window=%s: %s\n", config.Name, len(beyondHistory), history.Truncate(time.Second), config.LongWindow, strings.Join(beyondHistory, ", "))
+		}
+	}()
 
+	windows := make(map[string]time.Duration)
+	evaluable := []Function{}
 	for _, function := range functions {
-		querySt := url.QueryEscape(`sum(rate(gateway_function_invocation_total{function_name="` + function.Name + `", code=~".*"}[` + duration + `])) by (code, function_name)`)
-		// fmt.Println(function.Name)
-		res, err := query.Fetch(querySt)
+		policy := policies[function.Name]
+		if policy.Never {
+			continue
+		}
+
+		window, ok := queryWindow(policy, history, config)
+		if window != metricsWindow(policy, config) || !ok {
+			beyondHistory = append(beyondHistory, function.Name)
+		}
+		if ok {
+			windows[function.Name] = window
+			evaluable = append(evaluable, function)
+		}
+	}
+
+	batched := make(map[string]bool)
+	if batcher, ok := provider.(BatchMetricsProvider); ok && config.BatchQueries {
+		batched = batchMetrics(ctx, batcher, evaluable, windows, metricsMap, summary)
+	}
+
+	for i, function := range evaluable {
+		if batched[function.Name] {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			logSkipped(config.Name, "querying metrics", evaluable[i:])
+			break
+		}
+
+		rate, found, err := provider.Activity(ctx, function.Name, windows[function.Name])
 		if err != nil {
 			log.Println(err)
+			summary.fail("metrics", err)
+			unknown[function.Name] = true
 			continue
 		}
+		if found {
+			metricsMap[function.Name] = rate
+		}
+	}
 
-		if len(res.Data.Result) > 0 {
-			for _, v := range res.Data.Result {
-
-				if writeDebug {
-					fmt.Println(v)
-				}
+	return metricsMap, unknown
+}
 
-				if v.Metric.FunctionName == function.Name {
-					metricValue := v.Value[1]
-					switch metricValue.(type) {
-					case string:
+// batchMetrics queries at once the functions sharing a window, returning
+// those it accounted for. Functions alone in their window or in one the
+// provider does not batch are left to be queried one by one.
+func batchMetrics(ctx context.Context, provider BatchMetricsProvider, functions []Function, windows map[string]time.Duration, metricsMap map[string]float64, summary *cycleSummary) map[string]bool {
+	groups := make(map[time.Duration][]string)
+	order := []time.Duration{}
+	for _, function := range functions {
+		window := windows[function.Name]
+		if _, exists := groups[window]; !exists {
+			order = append(order, window)
+		}
+		groups[window] = append(groups[window], function.Name)
+	}
 
-						f, strconvErr := strconv.ParseFloat(metricValue.(string), 64)
-						if strconvErr != nil {
-							log.Printf("Unable to convert value for metric: %s\n", strconvErr)
-							continue
-						}
+	batched := make(map[string]bool)
+	for _, window := range order {
+		names := groups[window]
+		if len(names) < 2 || ctx.Err() != nil {
+			continue
+		}
 
-						if _, exists := metrics[function.Name]; !exists {
-							metrics[function.Name] = 0
-						}
+		rates, ok, err := provider.ActivityBatch(ctx, names, window)
+		if err != nil {
+			log.Println(err)
+			summary.fail("metrics", err)
+		} else if !ok {
+			continue
+		}
 
-						metrics[function.Name] = metrics[function.Name] + f
-					}
-				}
+		for _, name := range names {
+			batched[name] = true
+			if rate, found := rates[name]; found {
+				metricsMap[name] = rate
 			}
-
 		}
+	}
+	return batched
+}
 
+// reconcile runs a single pass over a gateway's functions in the namespaces
+// due and accounts for each of them in the returned summary
+func reconcile(ctx context.Context, gateway *Gateway, due sweep) *cycleSummary {
+	client, config, credentials := gateway.Client, gateway.Config, gateway.Credentials
+
+	start := time.Now()
+	summary := newCycleSummary(gateway.Name, start)
+	defer func() {
+		summary.Durations.Total = time.Since(start).Seconds()
+	}()
+
+	summary.Paused = paused(config.PauseFile)
+	reconcilePaused.WithLabelValues(gateway.Name).Set(boolToFloat(summary.Paused))
+	if summary.Paused {
+		log.Printf("[%s] Paused by pause_file %s, no scale events are sent\n", gateway.Name, config.PauseFile)
 	}
 
-	return metrics
-}
+	gateway.checkHealth(ctx)
 
-func reconcile(client *http.Client, config types.Config, credentials *Credentials) {
-	functions, err := queryFunctions(client, config.GatewayURL, credentials)
+	listCtx, cancelList := withTimeout(ctx, config.ListFunctionsTimeout)
+	functions, err := queryFunctions(listCtx, client, gateway.URL(), credentials)
+	cancelList()
+	summary.Durations.List = time.Since(start).Seconds()
 
 	if err != nil {
 		log.Println(err)
-		return
+		summary.fail("list", err)
+		return summary
+	}
+	functionsTotal.WithLabelValues(gateway.Name).Set(float64(len(functions)))
+
+	listed := make(map[string]bool)
+	for _, fn := range functions {
+		listed[fn.Name] = true
+	}
+	gateway.status.retain(listed)
+	gateway.drains.retain(listed)
+	gateway.streaks.retain(listed)
+	gateway.flaps.retain(listed)
+	gateway.tuning.retain(listed)
+	gateway.replicas.retain(listed)
+	gateway.candidates.retain(listed)
+	gateway.wakes.retain(listed)
+	gateway.prewarms.retain(listed)
+	gateway.activity.retain(listed)
+	gateway.counters.retain(listed)
+
+	now := time.Now()
+	for _, fn := range functions {
+		gateway.counters.observe(fn.Name, fn.InvocationCount, now)
+	}
+	for _, fn := range functions {
+		// A wake starts its own cooldown, the replicas are still recorded
+		scaleUpCooldown := config.ScaleUpCooldown
+		if gateway.wakes.observe(fn.Name, fn.Replicas, now, config.WakeCooldown) {
+			log.Printf("[%s] %s was woken to %d replica(s) outside of the idler, cooling down for %s\n", gateway.Name, fn.Name, fn.Replicas, config.WakeCooldown)
+			wakeEvents.WithLabelValues(gateway.Name).Inc()
+			gateway.notifier.notify(fn, wakeNotification(gateway, fn))
+			if config.AutoTune {
+				gateway.tuning.woken(fn.Name, now)
+			}
+			restoreReplicas(ctx, gateway, fn, summary)
+			if flaps, penalty := gateway.flaps.flapped(fn.Name, now, config.FlapThreshold, config.FlapPenaltyHalfLife); config.FlapThreshold > 0 && flaps > config.FlapThreshold {
+				log.Printf("[%s] %s flapped %d time(s) in the last hour, its inactivity duration is extended %.1fx\n", gateway.Name, fn.Name, flaps, penalty)
+			}
+			gateway.drains.cancel(fn.Name)
+			scaleUpCooldown = 0
+		}
+		if gateway.wakes.scaledUp(fn.Name, fn.Replicas, config.ScaleTarget, now, scaleUpCooldown) {
+			log.Printf("[%s] %s was scaled up to %d replica(s), cooling down for %s\n", gateway.Name, fn.Name, fn.Replicas, scaleUpCooldown)
+			gateway.drains.cancel(fn.Name)
+		}
+	}
+
+	dueFunctions := []Function{}
+	for _, fn := range functions {
+		if due.includes(fn.Namespace) {
+			dueFunctions = append(dueFunctions, fn)
+		}
+	}
+	functions = dueFunctions
+	summary.Evaluated = len(functions)
+
+	// The time of day sets the global inactivity duration, which namespaces
+	// and labels still override
+	config.InactivityDuration = gateway.inactivity.at(start, config.InactivityDuration)
+	policies := resolvePolicies(functions, config)
+	if config.AutoTune {
+		for name, policy := range policies {
+			if policy.Enabled && !policy.Never {
+				policy.InactivityDuration = gateway.tuning.apply(name, policy.InactivityDuration, config.AutoTuneLongSleep, now)
+				policies[name] = policy
+			}
+		}
+	}
+
+	// The calendar is read once per pass, a holiday may shorten the
+	// inactivity duration of every function
+	day, onCalendar := gateway.calendar.at(start)
+	if onCalendar {
+		summary.Calendar = day.Mode + ":" + day.Name
+		if day.Mode == calendarHoliday && config.HolidayInactivityDuration > 0 {
+			for name, policy := range policies {
+				if policy.InactivityDuration > config.HolidayInactivityDuration {
+					policy.InactivityDuration = config.HolidayInactivityDuration
+					policies[name] = policy
+				}
+			}
+		}
 	}
 
-	metrics := buildMetricsMap(client, functions, config)
+	// Functions which keep being woken after they were idled wait longer
+	for name, policy := range policies {
+		if penalty := gateway.flaps.penalty(name, now, config.FlapPenaltyHalfLife); penalty > 1 {
+			policy.InactivityDuration = time.Duration(float64(policy.InactivityDuration) * penalty)
+			policies[name] = policy
+		}
+	}
+
+	// With a limit on scale downs, those which have been idle the longest
+	// are scaled first, or the most expensive with scale_down_priority=cost
+	if config.MaxScaleDownsPerCycle > 0 {
+		gateway.activity.sortLeastRecentlyActive(functions)
+		if config.ScaleDownPriority == types.PriorityCost {
+			sortMostExpensive(functions, policies, config)
+		}
+	}
+	prewarm(ctx, gateway, functions, policies, now, summary)
+
+	candidates := []Function{}
 	for _, fn := range functions {
-		if fn.Labels != nil {
-			labels := *fn.Labels
-			labelValue := labels[scaleLabel]
+		if policy := policies[fn.Name]; policy.Enabled && !policy.Never {
+			candidates = append(candidates, fn)
+		}
+	}
+	autoscaled, unchecked := externalAutoscalers(ctx, gateway, candidates, summary)
+
+	metricsStart := time.Now()
+	history := prometheusHistory(ctx, gateway)
+	metrics, unknown := buildMetricsMap(ctx, gateway.metrics, functions, policies, history, config, summary)
+	uptime := prometheusUptime(ctx, gateway)
+	summary.Durations.Metrics = time.Since(metricsStart).Seconds()
+	callers := activeCallers(functions, policies, metrics, unknown)
+	overQuota := quotaVictims(gateway, functions, policies, metrics, unknown, autoscaled, now)
+
+	defer func() {
+		functionsIdle.WithLabelValues(gateway.Name).Set(float64(summary.Idle))
+		idleCost.WithLabelValues(gateway.Name).Set(summary.IdleCostPerHour)
+	}()
+
+	if len(unknown) > 0 {
+		if config.InvocationCountFallback {
+			log.Printf("[%s] Unable to read the activity of %d function(s), falling back to the gateway's invocation counts\n", gateway.Name, len(unknown))
+		} else {
+			log.Printf("[%s] Unable to read the activity of %d function(s), none of them will be idled\n", gateway.Name, len(unknown))
+		}
+	}
+
+	for i, fn := range functions {
+		if ctx.Err() != nil {
+			logSkipped(gateway.Name, "reconciling", functions[i:])
+			summary.Skipped[skipDeadline] += len(functions[i:])
+			return summary
+		}
+
+		// A function marked idle in the last pass is confirmed when found
+		// idle again, anything else unmarks it
+		mark, marked := gateway.candidates.take(fn.Name)
+
+		policy := policies[fn.Name]
+		if !policy.Enabled {
+			if writeDebug {
+				log.Printf("Skip: %s due to missing label\n", fn.Name)
+			}
+			summary.skip(skipNotEnabled)
+			continue
+		}
+
+		if policy.Never {
+			if writeDebug {
+				log.Printf("Skip: %s due to tier %s\n", fn.Name, policy.Tier)
+			}
+			summary.skip(skipNever)
+			continue
+		}
+
+		if policy.snoozed(now) {
+			if writeDebug {
+				log.Printf("Skip: %s is snoozed until %s\n", fn.Name, policy.SnoozeUntil.Format(time.RFC3339))
+			}
+			// Waiting idle cycles and drains start over once it wakes up
+			gateway.drains.cancel(fn.Name)
+			gateway.streaks.reset(fn.Name)
+			summary.skip(skipSnoozed)
+			continue
+		}
+
+		if kind, exists := autoscaled[fn.Name]; exists {
+			if writeDebug {
+				log.Printf("Skip: %s is scaled by %s\n", fn.Name, kind)
+			}
+			summary.skip(skipAutoscaled)
+			continue
+		}
+		if unchecked[fn.Name] {
+			summary.skip(skipAutoscaleErr)
+			continue
+		}
+
+		window, evaluable := queryWindow(policy, history, config)
+		if !evaluable {
+			summary.skip(skipLongWindow)
+			continue
+		}
 
-			if labelValue != "1" && labelValue != "true" {
+		v, found := metrics[fn.Name]
+		if unknown[fn.Name] && config.InvocationCountFallback {
+			v, found = gateway.counters.activity(fn.Name, policy.InactivityDuration, now)
+		}
+
+		if !found && unknown[fn.Name] {
+			// Failed queries are not taken for a lack of activity, and
+			// leave a pending drain as it is
+			if writeDebug {
+				fmt.Printf("%s\tunknown\n", fn.Name)
+			}
+			summary.skip(skipUnknown)
+			gateway.streaks.reset(fn.Name)
+			gateway.status.set(functionStatus{
+				Gateway:   gateway.Name,
+				Function:  fn.Name,
+				Namespace: fn.Namespace,
+				Replicas:  fn.AvailableReplicas,
+				Requests:  fn.Requests,
+				Unknown:   true,
+				UpdatedAt: time.Now(),
+			})
+		} else if !found {
+			summary.skip(skipNoMetrics)
+		} else {
+			status := functionStatus{
+				Gateway:   gateway.Name,
+				Function:  fn.Name,
+				Namespace: fn.Namespace,
+				Rate:      v,
+				Replicas:  fn.AvailableReplicas,
+				Requests:  fn.Requests,
+				Target:    policy.idleTarget(),
+				UpdatedAt: time.Now(),
+			}
+			gateway.notifier.notify(fn, decisionNotification(gateway, fn, v, policy.IdleThreshold))
+
+			if v > policy.IdleThreshold {
+				gateway.activity.record(fn.Name, status.UpdatedAt)
+			} else if gateway.activity.bridge(fn.Name, window, uptime, status.UpdatedAt) {
+				fmt.Printf("%s\tbridged: Prometheus has collected for %s of %s\n", fn.Name, uptime, window)
+				summary.skip(skipPrometheusGap)
+				gateway.status.set(status)
+				continue
+			}
+
+			idle, signal, reading, signalErr := idleSignals(ctx, gateway.PrometheusClient, fn, v, policy.IdleThreshold, window, config)
+			if signalErr != nil {
+				log.Printf("Unable to read the %s signal for %s: %s\n", signal, fn.Name, signalErr)
+				summary.fail(signal, signalErr)
+				gateway.streaks.reset(fn.Name)
+				gateway.status.set(status)
+				continue
+			}
+
+			if caller, called := callers[fn.Name]; idle && called {
 				if writeDebug {
-					log.Printf("Skip: %s due to missing label\n", fn.Name)
+					fmt.Printf("%s\tkept warm for %s\n", fn.Name, caller)
 				}
+				gateway.drains.cancel(fn.Name)
+				gateway.streaks.reset(fn.Name)
+				summary.skip(skipUpstream)
+				gateway.status.set(status)
 				continue
 			}
-		}
 
-		if v, found := metrics[fn.Name]; found {
-			if v == float64(0) {
+			if !idle && signal != types.SignalInvocations {
+				if writeDebug {
+					fmt.Printf("%s\tbusy: %f %s\n", fn.Name, reading, signal)
+				}
+				gateway.drains.cancel(fn.Name)
+				gateway.streaks.reset(fn.Name)
+				summary.skip(activitySignals[signal].skip)
+				gateway.status.set(status)
+				continue
+			}
+
+			if idle && metricsWindow(policy, config) < policy.InactivityDuration {
+				if since, sustained := gateway.activity.inactiveFor(fn.Name, policy.InactivityDuration, status.UpdatedAt); !sustained {
+					if writeDebug {
+						fmt.Printf("%s\tinactive for %s of %s\n", fn.Name, since.Truncate(time.Second), policy.InactivityDuration)
+					}
+					summary.skip(skipInactiveFor)
+					gateway.status.set(status)
+					continue
+				}
+			}
+
+			if idle && config.RequiredIdleCycles > 1 {
+				if cycles := gateway.streaks.idle(fn.Name); cycles < config.RequiredIdleCycles {
+					if writeDebug {
+						fmt.Printf("%s\tidle for %d of %d cycles\n", fn.Name, cycles, config.RequiredIdleCycles)
+					}
+					summary.skip(skipIdleCycles)
+					gateway.status.set(status)
+					continue
+				}
+			}
+
+			// Functions picked to bring their namespace back under its
+			// max_replicas are idled whatever their invocations, a forecast or
+			// the recheck before scaling
+			forced := !idle && overQuota[fn.Name]
+			threshold := policy.IdleThreshold
+			if forced {
+				fmt.Printf("%s\tidled for the max_replicas of its namespace\n", fn.Name)
+				idle, threshold = true, math.Inf(1)
+			}
+
+			if idle {
 				fmt.Printf("%s\tidle\n", fn.Name)
+				summary.Idle++
+				target := policy.idleTarget()
+
+				replicasCtx, cancelReplicas := withTimeout(ctx, config.GetReplicasTimeout)
+				val, replicasErr := getReplicas(replicasCtx, client, gateway.URL(), fn.Name, credentials)
+				cancelReplicas()
+
+				if replicasErr != nil {
+					log.Printf("Unable to get replicas for %s: %s\n", fn.Name, replicasErr)
+					gateway.notifier.notify(fn, scaleNotification(gateway, fn, target, replicasErr))
+					summary.fail("replicas", replicasErr)
+				}
+
+				if val != nil && val.AvailableReplicas > target {
+					status.Replicas = val.AvailableReplicas
+					if val.Requests != nil {
+						status.Requests = val.Requests
+					}
+					status.ScaleToZero = true
+
+					scaled := false
+					if until, cooling := gateway.wakes.coolingDown(fn.Name, status.UpdatedAt); cooling {
+						status.CooldownUntil = &until
+						if writeDebug {
+							fmt.Printf("%s\tcooling down until %s\n", fn.Name, until.Format(time.RFC3339))
+						}
+						summary.skip(skipCooldown)
+					} else if coldStarts := gateway.flaps.count(fn.Name, status.UpdatedAt); policy.MaxColdStartsPerHour > 0 && coldStarts >= policy.MaxColdStartsPerHour {
+						if writeDebug {
+							fmt.Printf("%s\tnot scaled after %d cold start(s) in the last hour\n", fn.Name, coldStarts)
+						}
+						summary.skip(skipColdStarts)
+					} else if onCalendar && day.Mode == calendarBlackout {
+						if writeDebug {
+							fmt.Printf("%s\tnot scaled during blackout %s\n", fn.Name, day.Name)
+						}
+						summary.skip(skipBlackout)
+					} else if !(onCalendar && day.Mode == calendarHoliday) && gateway.noIdle.excludes(status.UpdatedAt) {
+						if writeDebug {
+							fmt.Printf("%s\tnot scaled within no_idle_schedule\n", fn.Name)
+						}
+						summary.skip(skipExcluded)
+					} else if forecast, deferred := predictDefers(ctx, gateway, fn, policy.scaleDownTarget(val.AvailableReplicas, target), status.UpdatedAt, summary); deferred && !forced {
+						fmt.Printf("%s\tinvocations expected within %s, every %s\n", fn.Name, forecast.Next, forecast.Period)
+						gateway.drains.cancel(fn.Name)
+						summary.skip(skipForecast)
+					} else if config.ConfirmScaleDown && !marked {
+						gateway.candidates.mark(candidate{Gateway: gateway.Name, Function: fn.Name, MarkedAt: status.UpdatedAt})
+						markedAt := status.UpdatedAt
+						status.MarkedIdleAt = &markedAt
+						fmt.Printf("%s\tmarked idle, scaled if still idle in the next pass\n", fn.Name)
+						summary.skip(skipMarked)
+					} else if summary.scaleLimited(config.MaxScaleDownsPerCycle) {
+						if writeDebug {
+							fmt.Printf("%s\tnot scaled after max_scale_downs_per_cycle\n", fn.Name)
+						}
+						if marked {
+							gateway.candidates.mark(mark)
+						}
+						summary.skip(skipScaleLimit)
+					} else if observe {
+						fmt.Printf("observe: %s would be scaled to %d\n", fn.Name, target)
+						if marked {
+							gateway.candidates.mark(mark)
+						}
+						summary.skip(skipObserve)
+					} else if summary.Paused {
+						fmt.Printf("paused: %s would be scaled to %d\n", fn.Name, target)
+						if marked {
+							gateway.candidates.mark(mark)
+						}
+						summary.skip(skipPaused)
+					} else if policy.DrainDelay > 0 {
+						due, ready := gateway.drains.wait(fn.Name, policy.DrainDelay, status.UpdatedAt)
+						if ready {
+							gateway.drains.cancel(fn.Name)
+							scaled = scaleDown(ctx, gateway, fn, v, threshold, val.AvailableReplicas, policy.scaleDownTarget(val.AvailableReplicas, target), summary)
+						} else {
+							status.PendingScaleAt = &due
+							fmt.Printf("%s\tdraining until %s\n", fn.Name, due.Format(time.RFC3339))
+							summary.skip(skipDraining)
+						}
+					} else {
+						scaled = scaleDown(ctx, gateway, fn, v, threshold, val.AvailableReplicas, policy.scaleDownTarget(val.AvailableReplicas, target), summary)
+					}
 
-				if val, _ := getReplicas(client, config.GatewayURL, fn.Name, credentials); val != nil && val.AvailableReplicas > 0 {
-					sendScaleEvent(client, config.GatewayURL, fn.Name, uint64(0), credentials)
+					if !scaled {
+						summary.IdleCostPerHour += float64(val.AvailableReplicas-target) * replicaCostPerHour(status.Requests, config.CostPerCPUHour, config.CostPerGBHour)
+					}
+				} else if val != nil {
+					gateway.drains.cancel(fn.Name)
+					summary.skip(skipNoReplicas)
+				} else {
+					summary.skip(skipReplicasError)
 				}
 
 			} else {
+				gateway.drains.cancel(fn.Name)
+				gateway.streaks.reset(fn.Name)
+				summary.Active++
+
 				if writeDebug {
 					fmt.Printf("%s\tactive: %f\n", fn.Name, v)
 				}
 			}
+
+			gateway.status.set(status)
 		}
 	}
+	return summary
 }
 
-func getReplicas(client *http.Client, gatewayURL string, name string, credentials *Credentials) (*requests.Function, error) {
-	item := &requests.Function{}
+// withTimeout derives a context for a single gateway operation, a timeout of
+// zero leaves it bounded only by the reconcile pass.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// logSkipped reports the functions left unprocessed when a reconcile pass
+// runs past its deadline.
+func logSkipped(gatewayName string, stage string, functions []Function) {
+	names := make([]string, len(functions))
+	for i, fn := range functions {
+		names[i] = fn.Name
+	}
+
+	log.Printf("[%s] Reconcile deadline exceeded while %s, skipped %d function(s): %s\n", gatewayName, stage, len(names), strings.Join(names, ", "))
+}
+
+func getReplicas(ctx context.Context, client *http.Client, gatewayURL string, name string, credentials *Credentials) (*Function, error) {
+	item := &Function{}
 	var err error
 
 	req, _ := http.NewRequest(http.MethodGet, gatewayURL+"system/function/"+name, nil)
+	req = req.WithContext(ctx)
 	req.SetBasicAuth(credentials.Username, credentials.Password)
 
 	res, err := client.Do(req)
@@ -207,11 +989,9 @@ func getReplicas(client *http.Client, gatewayURL string, name string, credential
 	return item, err
 }
 
-func queryFunctions(client *http.Client, gatewayURL string, credentials *Credentials) ([]requests.Function, error) {
-	list := []requests.Function{}
-	var err error
-
+func queryFunctions(ctx context.Context, client *http.Client, gatewayURL string, credentials *Credentials) ([]Function, error) {
 	req, _ := http.NewRequest(http.MethodGet, gatewayURL+"system/functions", nil)
+	req = req.WithContext(ctx)
 	req.SetBasicAuth(credentials.Username, credentials.Password)
 
 	res, err := client.Do(req)
@@ -223,17 +1003,41 @@ func queryFunctions(client *http.Client, gatewayURL string, credentials *Credent
 		defer res.Body.Close()
 	}
 
-	bytesOut, _ := ioutil.ReadAll(res.Body)
+	return decodeFunctions(res.Body)
+}
 
-	err = json.Unmarshal(bytesOut, &list)
+// decodeFunctions reads the function list one function at a time rather than
+// buffering the whole response
+func decodeFunctions(body io.Reader) ([]Function, error) {
+	list := []Function{}
+	decoder := json.NewDecoder(body)
 
+	token, err := decoder.Token()
+	if err != nil {
+		return list, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return list, fmt.Errorf("expected a list of functions, got: %v", token)
+	}
+
+	for decoder.More() {
+		fn := Function{}
+		if err := decoder.Decode(&fn); err != nil {
+			return list, err
+		}
+		list = append(list, fn)
+	}
+
+	_, err = decoder.Token()
 	return list, err
 }
 
-func sendScaleEvent(client *http.Client, gatewayURL string, name string, replicas uint64, credentials *Credentials) {
+func sendScaleEvent(ctx context.Context, gateway *Gateway, name string, replicas uint64) error {
+	client, gatewayURL, credentials := gateway.Client, gateway.URL(), gateway.Credentials
+
 	if dryRun {
 		fmt.Printf("dry-run: Scaling %s to %d replicas\n", name, replicas)
-		return
+		return nil
 	}
 
 	scaleReq := providerTypes.ScaleServiceRequest{
@@ -247,19 +1051,38 @@ func sendScaleEvent(client *http.Client, gatewayURL string, name string, replica
 	bodyReader := bytes.NewReader(bodyBytes)
 
 	req, _ := http.NewRequest(http.MethodPost, gatewayURL+"system/scale-function/"+name, bodyReader)
+	req = req.WithContext(ctx)
 	req.SetBasicAuth(credentials.Username, credentials.Password)
 
 	res, err := client.Do(req)
 
 	if err != nil {
 		log.Println(err)
-		return
+		scaleEvents.WithLabelValues(gateway.Name, "error").Inc()
+		return err
 	}
 	log.Println("Scale", name, res.StatusCode, replicas)
+	scaleEvents.WithLabelValues(gateway.Name, strconv.Itoa(res.StatusCode)).Inc()
 
 	if res.Body != nil {
 		defer res.Body.Close()
 	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return scaleStatusError{name: name, code: res.StatusCode}
+	}
+	return nil
+}
+
+// scaleStatusError is returned when the gateway answers a scale request with
+// a non-2xx status code
+type scaleStatusError struct {
+	name string
+	code int
+}
+
+func (e scaleStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code scaling %s: %d", e.name, e.code)
 }
 
 type Version struct {
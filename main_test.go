@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_decodeFunctions(t *testing.T) {
+	body := `[
+	{"name": "figlet", "namespace": "dev", "replicas": 1, "labels": {"com.openfaas.scale.zero": "true"}},
+	{"name": "echo", "namespace": "prod", "replicas": 0}
+]`
+
+	functions, err := decodeFunctions(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(functions) != 2 || functions[0].Name != "figlet" || functions[0].Namespace != "dev" || labelValue(functions[0], scaleLabel) != "true" || functions[1].Name != "echo" {
+		t.Errorf("Unexpected functions: %+v", functions)
+	}
+
+	for _, invalid := range []string{"", `{"name": "figlet"}`, `[{"name": "figlet"}`} {
+		if _, err := decodeFunctions(strings.NewReader(invalid)); err == nil {
+			t.Errorf("%q: wanted an error", invalid)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/types"
+)
+
+// jetStreamInfo is the part of the /jsz monitoring endpoint read for the
+// consumers of each stream
+type jetStreamInfo struct {
+	Accounts []struct {
+		Streams []struct {
+			Name      string `json:"name"`
+			Consumers []struct {
+				Config struct {
+					FilterSubject string `json:"filter_subject"`
+				} `json:"config"`
+				NumPending    float64 `json:"num_pending"`
+				NumAckPending float64 `json:"num_ack_pending"`
+			} `json:"consumer_detail"`
+		} `json:"stream_detail"`
+	} `json:"account_details"`
+}
+
+// streamingChannel is the part of the /streaming/channelsz monitoring
+// endpoint read for a channel and its subscriptions
+type streamingChannel struct {
+	LastSeq       float64 `json:"last_seq"`
+	Subscriptions []struct {
+		LastSent     float64 `json:"last_sent"`
+		PendingCount float64 `json:"pending_count"`
+	} `json:"subscriptions"`
+}
+
+// natsSubject renders nats_subject for a function, $function and $namespace
+// are replaced by its name and namespace
+func natsSubject(fn Function, config types.Config) string {
+	subject := strings.Replace(config.NATSSubject, "$function", fn.Name, -1)
+	return strings.Replace(subject, "$namespace", fn.Namespace, -1)
+}
+
+// queryNATS returns the asynchronous requests waiting in the queue for a
+// function, read from the NATS monitoring endpoint. Messages of a queue not
+// split by function, as with NATS Streaming or a JetStream consumer without
+// a filter subject, are counted for every function.
+func queryNATS(ctx context.Context, fn Function, config types.Config) (float64, error) {
+	client := newHeaderClient(config.UserAgent, nil, nil)
+	queryCtx, cancel := withTimeout(ctx, config.PrometheusTimeout)
+	defer cancel()
+
+	if config.NATSQueue == types.NATSQueueStreaming {
+		values := url.Values{}
+		values.Set("channel", config.NATSChannel)
+		values.Set("subs", "1")
+
+		channel := streamingChannel{}
+		if err := getNATS(queryCtx, client, "/streaming/channelsz", values, config, &channel); err != nil {
+			return 0, err
+		}
+
+		waiting, lastSent := float64(0), float64(0)
+		for _, sub := range channel.Subscriptions {
+			waiting += sub.PendingCount
+			if sub.LastSent > lastSent {
+				lastSent = sub.LastSent
+			}
+		}
+		if len(channel.Subscriptions) > 0 && channel.LastSeq > lastSent {
+			waiting += channel.LastSeq - lastSent
+		}
+		return waiting, nil
+	}
+
+	values := url.Values{}
+	for _, name := range []string{"accounts", "streams", "consumers", "config"} {
+		values.Set(name, "true")
+	}
+
+	info := jetStreamInfo{}
+	if err := getNATS(queryCtx, client, "/jsz", values, config, &info); err != nil {
+		return 0, err
+	}
+
+	subject := natsSubject(fn, config)
+	waiting := float64(0)
+	for _, account := range info.Accounts {
+		for _, stream := range account.Streams {
+			if stream.Name != config.NATSChannel {
+				continue
+			}
+			for _, consumer := range stream.Consumers {
+				filter := consumer.Config.FilterSubject
+				if len(filter) == 0 || len(subject) == 0 || filter == subject {
+					waiting += consumer.NumPending + consumer.NumAckPending
+				}
+			}
+		}
+	}
+	return waiting, nil
+}
+
+func getNATS(ctx context.Context, client *http.Client, path string, values url.Values, config types.Config, into interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(config.NATSMonitorURL, "/")+path+"?"+values.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	body, err := doRequest(ctx, client, req, "NATS")
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, into)
+}
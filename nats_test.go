@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/types"
+)
+
+func Test_queryNATS_jetStream(t *testing.T) {
+	nats := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/jsz" || r.URL.Query().Get("consumers") != "true" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"account_details": [{"name": "$G", "stream_detail": [
+			{"name": "faas-request", "consumer_detail": [
+				{"name": "figlet", "config": {"filter_subject": "faas-request.figlet"}, "num_pending": 3, "num_ack_pending": 1},
+				{"name": "nodeinfo", "config": {"filter_subject": "faas-request.nodeinfo"}, "num_pending": 0, "num_ack_pending": 0}]},
+			{"name": "other", "consumer_detail": [{"name": "figlet", "config": {}, "num_pending": 10}]}]}]}`))
+	}))
+	defer nats.Close()
+
+	config := types.Config{NATSMonitorURL: nats.URL + "/", NATSQueue: types.NATSQueueJetStream, NATSChannel: "faas-request", NATSSubject: "faas-request.$function"}
+
+	cases := []struct {
+		function string
+		want     float64
+	}{
+		{"figlet", 4},
+		{"nodeinfo", 0},
+		{"env", 0},
+	}
+	for _, c := range cases {
+		waiting, err := queryNATS(context.Background(), Function{Name: c.function}, config)
+		if err != nil || waiting != c.want {
+			t.Errorf("%s waiting wanted: %f got: %f %v", c.function, c.want, waiting, err)
+		}
+	}
+
+	// Without a subject every consumer of the stream is counted
+	config.NATSSubject = ""
+	if waiting, _ := queryNATS(context.Background(), Function{Name: "env"}, config); waiting != 4 {
+		t.Errorf("Waiting wanted: 4 got: %f", waiting)
+	}
+}
+
+func Test_queryNATS_streaming(t *testing.T) {
+	nats := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/streaming/channelsz" || r.URL.Query().Get("channel") != "faas-request" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"name": "faas-request", "msgs": 20, "last_seq": 20, "subscriptions": [
+			{"queue_name": "faas", "last_sent": 17, "pending_count": 1},
+			{"queue_name": "faas", "last_sent": 15, "pending_count": 0}]}`))
+	}))
+	defer nats.Close()
+
+	config := types.Config{NATSMonitorURL: nats.URL, NATSQueue: types.NATSQueueStreaming, NATSChannel: "faas-request"}
+	if waiting, err := queryNATS(context.Background(), Function{Name: "figlet"}, config); err != nil || waiting != 4 {
+		t.Errorf("Waiting wanted: 4 got: %f %v", waiting, err)
+	}
+}
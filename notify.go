@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/types"
+)
+
+// ownerAnnotation holds where a function's notifications are sent, a
+// comma-separated list of email addresses, Slack channels and webhook URLs
+const ownerAnnotation = "com.openfaas.owner"
+
+const slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+// Kinds of notification, from most to least frequent
+const (
+	kindDecision = "decision"
+	kindScale    = "scale"
+	kindWake     = "wake"
+	kindError    = "error"
+)
+
+// Notification is sent when the idler decides on, scales or fails to scale
+// a function
+type Notification struct {
+	Schema    string `json:"schema"`
+	Kind      string `json:"kind"`
+	Gateway   string `json:"gateway"`
+	Function  string `json:"function"`
+	Namespace string `json:"namespace,omitempty"`
+
+	// Idle and Rate are set on decisions
+	Idle bool    `json:"idle,omitempty"`
+	Rate float64 `json:"invocation_rate,omitempty"`
+
+	// Replicas is the target of a scale event, or the replicas a function
+	// was woken to
+	Replicas  uint64    `json:"replicas"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (n Notification) String() string {
+	switch {
+	case n.Kind == kindDecision && n.Idle:
+		return fmt.Sprintf("faas-idler found %s idle on %s", n.Function, n.Gateway)
+	case n.Kind == kindDecision:
+		return fmt.Sprintf("faas-idler found %s active on %s at %f invocations/s", n.Function, n.Gateway, n.Rate)
+	case n.Kind == kindWake:
+		return fmt.Sprintf("faas-idler found %s woken to %d replica(s) on %s", n.Function, n.Replicas, n.Gateway)
+	case n.Success:
+		return fmt.Sprintf("faas-idler scaled %s to %d replica(s) on %s", n.Function, n.Replicas, n.Gateway)
+	}
+	return fmt.Sprintf("faas-idler failed to scale %s to %d replica(s) on %s: %s", n.Function, n.Replicas, n.Gateway, n.Error)
+}
+
+func decisionNotification(gateway *Gateway, fn Function, rate float64, threshold float64) Notification {
+	return Notification{
+		Schema:    schemaNotification,
+		Kind:      kindDecision,
+		Gateway:   gateway.Name,
+		Function:  fn.Name,
+		Namespace: fn.Namespace,
+		Idle:      rate <= threshold,
+		Rate:      rate,
+		Success:   true,
+		Timestamp: time.Now(),
+	}
+}
+
+func wakeNotification(gateway *Gateway, fn Function) Notification {
+	return Notification{
+		Schema:    schemaNotification,
+		Kind:      kindWake,
+		Gateway:   gateway.Name,
+		Function:  fn.Name,
+		Namespace: fn.Namespace,
+		Replicas:  fn.Replicas,
+		Success:   true,
+		Timestamp: time.Now(),
+	}
+}
+
+func scaleNotification(gateway *Gateway, fn Function, replicas uint64, scaleErr error) Notification {
+	notification := Notification{
+		Schema:    schemaNotification,
+		Kind:      kindScale,
+		Gateway:   gateway.Name,
+		Function:  fn.Name,
+		Namespace: fn.Namespace,
+		Replicas:  replicas,
+		Success:   scaleErr == nil,
+		Timestamp: time.Now(),
+	}
+	if scaleErr != nil {
+		notification.Kind = kindError
+		notification.Error = scaleErr.Error()
+	}
+	return notification
+}
+
+// accepts reports whether a route with the given severity receives a kind
+// of notification: all receives everything, scale receives scale events and
+// errors, errors only receives errors
+func accepts(severity string, kind string) bool {
+	switch severity {
+	case types.SeverityAll:
+		return true
+	case types.SeverityScale:
+		return kind == kindScale || kind == kindError
+	case types.SeverityErrors:
+		return kind == kindError
+	}
+	return false
+}
+
+type notifier struct {
+	config types.Config
+	client *http.Client
+
+	// decisions holds the last decision audited per function, so that
+	// without audit_decisions only changes of recommendation are written
+	mu        sync.Mutex
+	decisions *lruCache
+}
+
+func newNotifier(config types.Config) *notifier {
+	return &notifier{
+		config:    config,
+		client:    &http.Client{},
+		decisions: newLRUCache(config.StateMaxFunctions, config.StateTTL),
+	}
+}
+
+// audited reports whether a notification goes to the audit log: scale
+// events, wakes and errors always do, a decision when it is the first for
+// the function or changes its recommendation, unless audit_decisions is set
+func (n *notifier) audited(notification Notification) bool {
+	if notification.Kind != kindDecision || n.config.AuditDecisions {
+		return true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	last, exists := n.decisions.get(notification.Function)
+	n.decisions.set(notification.Function, notification.Idle)
+	return !exists || last.(bool) != notification.Idle
+}
+
+// destination is where a notification is sent and the template rendering
+// its body, if any
+type destination struct {
+	target   string
+	template *template.Template
+}
+
+// destinations lists where a kind of notification about a function is sent:
+// every matching route accepting it plus the function's owners, who receive
+// scale events and errors. The global webhook is used when neither a route
+// nor an owner matched. Routes render with their own template, everything
+// else with notify_template.
+func (n *notifier) destinations(fn Function, kind string) []destination {
+	destinations := []destination{}
+	seen := map[string]bool{}
+	add := func(target string, tmpl *template.Template) {
+		if target = strings.TrimSpace(target); len(target) > 0 && !seen[target] {
+			seen[target] = true
+			destinations = append(destinations, destination{target: target, template: tmpl})
+		}
+	}
+
+	matched := false
+	for _, route := range n.config.NotifyRoutes {
+		if !routeMatches(route, fn) {
+			continue
+		}
+
+		matched = true
+		if accepts(route.Severity, kind) {
+			tmpl := route.Template
+			if tmpl == nil {
+				tmpl = n.config.NotifyTemplate
+			}
+			for _, target := range route.Destinations {
+				add(target, tmpl)
+			}
+		}
+	}
+
+	owners := strings.Split(annotationValue(fn, ownerAnnotation), ",")
+	for _, owner := range owners {
+		if len(strings.TrimSpace(owner)) > 0 {
+			matched = true
+			if accepts(types.SeverityScale, kind) {
+				add(owner, n.config.NotifyTemplate)
+			}
+		}
+	}
+
+	if !matched && accepts(types.SeverityScale, kind) {
+		add(n.config.NotifyWebhookURL, n.config.NotifyTemplate)
+	}
+	return destinations
+}
+
+// routeMatches is true when the function is in the route's namespace, if
+// set, and carries all of its labels
+func routeMatches(route types.NotifyRoute, fn Function) bool {
+	return selectorMatches(route.Namespace, route.Labels, fn)
+}
+
+// notify delivers the notification to every destination in the background
+func (n *notifier) notify(fn Function, notification Notification) {
+	if audit != nil && n.audited(notification) {
+		audit.write(notification)
+	}
+
+	for _, dest := range n.destinations(fn, notification.Kind) {
+		background.Add(1)
+		go func(destination destination) {
+			defer background.Done()
+
+			ctx, cancel := withTimeout(context.Background(), n.config.NotifyTimeout)
+			defer cancel()
+
+			if err := n.send(ctx, destination, fn, notification); err != nil {
+				log.Printf("[%s] Unable to notify %s about %s: %s\n", notification.Gateway, destination.target, notification.Function, err)
+			}
+		}(dest)
+	}
+}
+
+func (n *notifier) send(ctx context.Context, destination destination, fn Function, notification Notification) error {
+	target := destination.target
+	switch {
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		return n.sendWebhook(ctx, target, destination.template, fn, notification)
+	case strings.HasPrefix(target, "#"):
+		return n.sendSlack(ctx, target, notification)
+	case strings.HasPrefix(target, "mailto:") || strings.Contains(target, "@"):
+		return n.sendEmail(strings.TrimPrefix(target, "mailto:"), notification)
+	}
+	return fmt.Errorf("unsupported destination")
+}
+
+func (n *notifier) sendWebhook(ctx context.Context, webhookURL string, tmpl *template.Template, fn Function, notification Notification) error {
+	if tmpl == nil && n.config.NotifyFormat == types.NotifyFormatCloudEvents {
+		bodyBytes, _ := json.Marshal(newCloudEvent(notification))
+		return n.post(ctx, webhookURL, "", cloudEventsContentType, bodyBytes)
+	}
+
+	bodyBytes, err := renderPayload(tmpl, fn, notification)
+	if err != nil {
+		return fmt.Errorf("unable to render template: %s", err)
+	}
+	return n.post(ctx, webhookURL, "", "application/json", bodyBytes)
+}
+
+func (n *notifier) sendSlack(ctx context.Context, channel string, notification Notification) error {
+	if len(n.config.SlackToken) == 0 {
+		return fmt.Errorf("slack_token is not set")
+	}
+
+	bodyBytes, _ := json.Marshal(map[string]string{
+		"channel": channel,
+		"text":    notification.String(),
+	})
+	return n.post(ctx, slackPostMessageURL, n.config.SlackToken, "application/json", bodyBytes)
+}
+
+func (n *notifier) post(ctx context.Context, target string, token string, contentType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (n *notifier) sendEmail(address string, notification Notification) error {
+	if len(n.config.SMTPHost) == 0 {
+		return fmt.Errorf("smtp_host is not set")
+	}
+
+	var auth smtp.Auth
+	if len(n.config.SMTPUsername) > 0 {
+		auth = smtp.PlainAuth("", n.config.SMTPUsername, n.config.SMTPPassword, n.config.SMTPHost)
+	}
+
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		n.config.SMTPFrom, address, notification.String(), notification.String())
+
+	addr := fmt.Sprintf("%s:%d", n.config.SMTPHost, n.config.SMTPPort)
+	return smtp.SendMail(addr, auth, n.config.SMTPFrom, []string{address}, []byte(message))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"text/template"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_notifierDestinations_owners(t *testing.T) {
+	n := newNotifier(types.Config{NotifyWebhookURL: "http://global/"})
+
+	owned := Function{
+		Function:    requests.Function{Name: "figlet"},
+		Annotations: &map[string]string{ownerAnnotation: "team@example.com, #team-alerts,https://hooks.example.com/team"},
+	}
+
+	want := []string{"team@example.com", "#team-alerts", "https://hooks.example.com/team"}
+	if destinations := targets(n.destinations(owned, kindScale)); !reflect.DeepEqual(destinations, want) {
+		t.Errorf("Destinations wanted: %v got: %v", want, destinations)
+	}
+
+	if destinations := n.destinations(owned, kindDecision); len(destinations) != 0 {
+		t.Errorf("Owners should not receive decisions, got: %v", destinations)
+	}
+
+	unowned := Function{Function: requests.Function{Name: "echo"}}
+	if destinations := targets(n.destinations(unowned, kindScale)); !reflect.DeepEqual(destinations, []string{"http://global/"}) {
+		t.Errorf("Destinations wanted: %v got: %v", []string{"http://global/"}, destinations)
+	}
+
+	if destinations := newNotifier(types.Config{}).destinations(unowned, kindScale); len(destinations) != 0 {
+		t.Errorf("Destinations wanted none got: %v", destinations)
+	}
+}
+
+func Test_notifierDestinations_routes(t *testing.T) {
+	n := newNotifier(types.Config{
+		NotifyWebhookURL: "http://global/",
+		NotifyRoutes: []types.NotifyRoute{
+			{Namespace: "dev", Destinations: []string{"#dev-idler"}, Severity: types.SeverityAll},
+			{Labels: map[string]string{"team": "payments"}, Destinations: []string{"#payments"}, Severity: types.SeverityErrors},
+		},
+	})
+
+	dev := Function{Function: requests.Function{Name: "figlet"}, Namespace: "dev"}
+	payments := Function{
+		Function:  requests.Function{Name: "checkout", Labels: &map[string]string{"team": "payments"}},
+		Namespace: "prod",
+	}
+	other := Function{Function: requests.Function{Name: "echo"}, Namespace: "prod"}
+
+	cases := []struct {
+		fn   Function
+		kind string
+		want []string
+	}{
+		{dev, kindDecision, []string{"#dev-idler"}},
+		{dev, kindScale, []string{"#dev-idler"}},
+		{payments, kindScale, []string{}},
+		{payments, kindError, []string{"#payments"}},
+		{other, kindDecision, []string{}},
+		{other, kindScale, []string{"http://global/"}},
+	}
+
+	for _, c := range cases {
+		if destinations := targets(n.destinations(c.fn, c.kind)); !reflect.DeepEqual(destinations, c.want) {
+			t.Errorf("%s %s: destinations wanted: %v got: %v", c.fn.Name, c.kind, c.want, destinations)
+		}
+	}
+}
+
+func Test_notifierDestinations_templates(t *testing.T) {
+	global := template.Must(template.New("global").Parse(`{{ .Function }}`))
+	route := template.Must(template.New("route").Parse(`{{ .Namespace }}`))
+
+	n := newNotifier(types.Config{
+		NotifyWebhookURL: "http://global/",
+		NotifyTemplate:   global,
+		NotifyRoutes: []types.NotifyRoute{
+			{Namespace: "dev", Destinations: []string{"http://dev/"}, Severity: types.SeverityAll, Template: route},
+			{Namespace: "prod", Destinations: []string{"http://prod/"}, Severity: types.SeverityAll},
+		},
+	})
+
+	cases := []struct {
+		namespace string
+		want      *template.Template
+	}{
+		{"dev", route},
+		{"prod", global},
+		{"staging", global},
+	}
+
+	for _, c := range cases {
+		fn := Function{Function: requests.Function{Name: "figlet"}, Namespace: c.namespace}
+		destinations := n.destinations(fn, kindScale)
+		if len(destinations) != 1 || destinations[0].template != c.want {
+			t.Errorf("%s: template wanted: %s got: %v", c.namespace, c.want.Name(), destinations)
+		}
+	}
+}
+
+func targets(destinations []destination) []string {
+	targets := []string{}
+	for _, destination := range destinations {
+		targets = append(targets, destination.target)
+	}
+	return targets
+}
+
+func Test_sendWebhook_cloudEvents(t *testing.T) {
+	var contentType string
+	event := CloudEvent{}
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&event)
+	}))
+	defer webhook.Close()
+
+	n := newNotifier(types.Config{NotifyFormat: types.NotifyFormatCloudEvents})
+	fn := Function{Function: requests.Function{Name: "figlet"}}
+	notification := scaleNotification(&Gateway{Name: "prod"}, fn, 0, nil)
+
+	if err := n.sendWebhook(context.Background(), webhook.URL, nil, fn, notification); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if contentType != cloudEventsContentType {
+		t.Errorf("Content-Type wanted: %s got: %s", cloudEventsContentType, contentType)
+	}
+	if event.SpecVersion != "1.0" || event.Type != "com.openfaas.idler.scale.v1" || event.Source != "faas-idler/prod" || event.Data.Schema != schemaNotification {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func Test_notifier_audited(t *testing.T) {
+	n := newNotifier(types.Config{})
+	decision := func(name string, idle bool) Notification {
+		return Notification{Kind: kindDecision, Function: name, Idle: idle}
+	}
+
+	cases := []struct {
+		notification Notification
+		want         bool
+	}{
+		{decision("figlet", false), true},
+		{decision("figlet", false), false},
+		{decision("figlet", true), true},
+		{decision("figlet", true), false},
+		{decision("env", true), true},
+		{Notification{Kind: kindScale, Function: "figlet"}, true},
+		{Notification{Kind: kindWake, Function: "figlet"}, true},
+		{Notification{Kind: kindError, Function: "figlet"}, true},
+	}
+	for i, c := range cases {
+		if got := n.audited(c.notification); got != c.want {
+			t.Errorf("%d: %s %s audited wanted: %t got: %t", i, c.notification.Kind, c.notification.Function, c.want, got)
+		}
+	}
+
+	n = newNotifier(types.Config{AuditDecisions: true})
+	if !n.audited(decision("figlet", false)) || !n.audited(decision("figlet", false)) {
+		t.Errorf("Every decision should be audited with audit_decisions")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+)
+
+// Exit codes of -once, 1 and 2 are left to panics and flag errors
+const (
+	exitNoActions = 0
+	exitActions   = 3
+	exitErrors    = 4
+)
+
+// onceResult is printed to stdout by -once
+type onceResult struct {
+	DryRun   bool            `json:"dry_run"`
+	Observe  bool            `json:"observe"`
+	Idled    int             `json:"idled"`
+	Failed   int             `json:"failed"`
+	Gateways []*cycleSummary `json:"gateways"`
+}
+
+// runOnce reconciles every gateway a single time, waits for notifications
+// and post-scale hooks to be delivered and prints the summaries as JSON. It
+// returns exitErrors when anything failed, otherwise exitActions when a
+// function was scaled and exitNoActions when none was.
+func runOnce(out io.Writer, gateways []*Gateway) int {
+	result := onceResult{
+		DryRun:   dryRun,
+		Observe:  observe,
+		Gateways: reconcileAll(gateways, fullSweep),
+	}
+	background.Wait()
+
+	for _, summary := range result.Gateways {
+		result.Idled += summary.Idled
+		result.Failed += summary.failures()
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		log.Printf("Unable to write summary: %s\n", err)
+		return exitErrors
+	}
+
+	return onceExitCode(result)
+}
+
+func onceExitCode(result onceResult) int {
+	switch {
+	case result.Failed > 0:
+		return exitErrors
+	case result.Idled > 0:
+		return exitActions
+	}
+	return exitNoActions
+}
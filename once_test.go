@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/types"
+)
+
+func Test_onceExitCode(t *testing.T) {
+	cases := []struct {
+		result onceResult
+		want   int
+	}{
+		{onceResult{}, exitNoActions},
+		{onceResult{Idled: 2}, exitActions},
+		{onceResult{Idled: 2, Failed: 1}, exitErrors},
+	}
+
+	for _, c := range cases {
+		if code := onceExitCode(c.result); code != c.want {
+			t.Errorf("%+v: exit code wanted: %d got: %d", c.result, c.want, code)
+		}
+	}
+}
+
+func Test_runOnce_listFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	gateway := newGateway(types.Config{Name: "test", GatewayURL: server.URL + "/"})
+
+	out := bytes.Buffer{}
+	if code := runOnce(&out, []*Gateway{gateway}); code != exitErrors {
+		t.Errorf("Exit code wanted: %d got: %d", exitErrors, code)
+	}
+
+	result := onceResult{}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("Unable to parse summary: %s, %s", err, out.String())
+	}
+	if result.Failed != 1 || len(result.Gateways) != 1 || result.Gateways[0].Gateway != "test" {
+		t.Errorf("Unexpected summary: %s", out.String())
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/types"
+)
+
+// opaInput is the input of the opa_policy_path decision: the scale action,
+// after any pre-scale hooks, and the function's invocation rate
+type opaInput struct {
+	ScaleAction
+	Rate float64 `json:"rate"`
+}
+
+// opaResponse is the response of OPA's Data API, a result is read like the
+// response of a hook
+type opaResponse struct {
+	Result *HookResponse `json:"result"`
+}
+
+// opaDecision asks the opa_url server for the decision of opa_policy_path on
+// an action, the returned action carries any target it set. A denial, and a
+// failure with the fail policy, is returned as a vetoError.
+func opaDecision(ctx context.Context, config types.Config, action ScaleAction, rate float64) (ScaleAction, error) {
+	decision, err := queryOPA(ctx, config, opaInput{ScaleAction: action, Rate: rate})
+	if err != nil {
+		if config.OPAFailurePolicy == types.FailurePolicyIgnore {
+			return action, nil
+		}
+		return action, vetoError{hook: "opa", reason: err.Error()}
+	}
+
+	if decision.Allow != nil && !*decision.Allow {
+		reason := decision.Reason
+		if len(reason) == 0 {
+			reason = "denied"
+		}
+		return action, vetoError{hook: "opa", reason: reason}
+	}
+	if decision.Replicas != nil {
+		action.Target = *decision.Replicas
+	}
+	return action, nil
+}
+
+func queryOPA(ctx context.Context, config types.Config, input opaInput) (HookResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return HookResponse{}, err
+	}
+
+	endpoint := strings.TrimSuffix(config.OPAURL, "/") + "/v1/data/" + config.OPAPolicyPath
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return HookResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	opaCtx, cancel := withTimeout(ctx, config.OPATimeout)
+	defer cancel()
+
+	res, err := doRequest(opaCtx, newHeaderClient(config.UserAgent, nil, nil), req, "OPA")
+	if err != nil {
+		return HookResponse{}, err
+	}
+
+	response := opaResponse{}
+	if err := json.Unmarshal(res, &response); err != nil {
+		return HookResponse{}, err
+	}
+	// An undefined decision, i.e. a policy which is not loaded, has no result
+	if response.Result == nil {
+		return HookResponse{}, fmt.Errorf("decision %s is undefined", config.OPAPolicyPath)
+	}
+	return *response.Result, nil
+}
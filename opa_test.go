@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_opaDecision(t *testing.T) {
+	var input map[string]interface{}
+	decision := `{"result": {"allow": true, "replicas": 1}}`
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/data/faas_idler/scale" {
+			http.NotFound(w, r)
+			return
+		}
+		body := map[string]map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		input = body["input"]
+		w.Write([]byte(decision))
+	}))
+	defer opa.Close()
+
+	config := types.Config{OPAURL: opa.URL, OPAPolicyPath: "faas_idler/scale", OPAFailurePolicy: types.FailurePolicyFail, OPATimeout: time.Second}
+	action := ScaleAction{Function: "figlet", Namespace: "dev", Replicas: 3, Target: 0}
+
+	decided, err := opaDecision(context.Background(), config, action, 0.5)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if decided.Target != 1 {
+		t.Errorf("Target wanted: 1 got: %d", decided.Target)
+	}
+	if input["function"] != "figlet" || input["rate"] != 0.5 || input["replicas"] != float64(3) {
+		t.Errorf("Unexpected input: %v", input)
+	}
+
+	decision = `{"result": {"allow": false, "reason": "payments team"}}`
+	if _, err := opaDecision(context.Background(), config, action, 0); err == nil || err.Error() != "vetoed by hook opa: payments team" {
+		t.Errorf("A denial should veto the action, got: %v", err)
+	}
+
+	decision = `{}`
+	if _, err := opaDecision(context.Background(), config, action, 0); err == nil {
+		t.Errorf("An undefined decision should veto the action with the fail policy")
+	}
+
+	config.OPAFailurePolicy = types.FailurePolicyIgnore
+	if decided, err := opaDecision(context.Background(), config, action, 0); err != nil || decided.Target != 0 {
+		t.Errorf("An undefined decision should be ignored with the ignore policy, got: %d %v", decided.Target, err)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// paused reports whether pause_file suspends scale events: it exists and
+// holds anything but false or 0, so that it can be touched on a volume or be
+// a key of a mounted ConfigMap. A file which cannot be read pauses too, as
+// it is only set to stop the idler in a hurry.
+func paused(path string) bool {
+	if len(path) == 0 {
+		return false
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false
+		}
+		log.Printf("Unable to read pause_file, pausing: %s\n", err)
+		return true
+	}
+
+	value := strings.TrimSpace(string(data))
+	return value != "false" && value != "0"
+}
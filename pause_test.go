@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_paused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faas-idler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "paused")
+
+	if paused("") || paused(path) {
+		t.Errorf("The idler should not be paused without a pause_file")
+	}
+
+	cases := []struct {
+		content string
+		paused  bool
+	}{
+		{"", true},
+		{"true\n", true},
+		{"incident 42", true},
+		{"false\n", false},
+		{"0", false},
+	}
+	for _, c := range cases {
+		if err := ioutil.WriteFile(path, []byte(c.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if got := paused(path); got != c.paused {
+			t.Errorf("pause_file holding %q, paused wanted: %v got: %v", c.content, c.paused, got)
+		}
+	}
+
+	if !paused(dir) {
+		t.Errorf("A pause_file which cannot be read should pause the idler")
+	}
+}
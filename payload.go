@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"text/template"
+)
+
+// PayloadData is available to notification and hook templates, Event is the
+// JSON payload sent without a template: a Notification, ScaleAction or
+// ScaleOutcome
+type PayloadData struct {
+	Event           interface{}
+	Function        string
+	Namespace       string
+	Labels          map[string]string
+	Annotations     map[string]string
+	Image           string
+	InvocationCount float64
+	Replicas        uint64
+}
+
+func newPayloadData(fn Function, event interface{}) PayloadData {
+	data := PayloadData{
+		Event:           event,
+		Function:        fn.Name,
+		Namespace:       fn.Namespace,
+		Labels:          map[string]string{},
+		Annotations:     map[string]string{},
+		Image:           fn.Image,
+		InvocationCount: fn.InvocationCount,
+		Replicas:        fn.AvailableReplicas,
+	}
+	if fn.Labels != nil {
+		data.Labels = *fn.Labels
+	}
+	if fn.Annotations != nil {
+		data.Annotations = *fn.Annotations
+	}
+	return data
+}
+
+// renderPayload executes the template over the function and event, or
+// marshals the event as JSON when there is no template
+func renderPayload(tmpl *template.Template, fn Function, event interface{}) ([]byte, error) {
+	if tmpl == nil {
+		return json.Marshal(event)
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, newPayloadData(fn, event)); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_renderPayload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faas-idler-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "payload.tmpl")
+	body := `{"text": "{{ .Function }} in {{ .Namespace }} for {{ index .Labels "team" }}", "event": {{ json .Event }}, "owner": "{{ index .Annotations "missing" }}"}`
+	if err := ioutil.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := types.ReadTemplate(path)
+	if err != nil {
+		t.Fatalf("ReadTemplate: %s", err)
+	}
+
+	fn := Function{
+		Function:  requests.Function{Name: "figlet", Labels: &map[string]string{"team": "payments"}},
+		Namespace: "dev",
+	}
+	action := ScaleAction{Schema: schemaScaleAction, Function: "figlet", Replicas: 2}
+
+	out, err := renderPayload(tmpl, fn, action)
+	if err != nil {
+		t.Fatalf("renderPayload: %s", err)
+	}
+
+	want := `{"text": "figlet in dev for payments", "event": {"schema":"faas-idler.scale_action.v1","gateway":"","function":"figlet","replicas":2,"target":0}, "owner": ""}`
+	if string(out) != want {
+		t.Errorf("Payload wanted: %s got: %s", want, out)
+	}
+
+	out, err = renderPayload(nil, fn, action)
+	if err != nil {
+		t.Fatalf("renderPayload: %s", err)
+	}
+	if want := `{"schema":"faas-idler.scale_action.v1","gateway":"","function":"figlet","replicas":2,"target":0}`; string(out) != want {
+		t.Errorf("Payload without a template wanted: %s got: %s", want, out)
+	}
+}
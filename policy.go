@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/types"
+)
+
+const tierLabel = "com.openfaas.tier"
+
+// scaleMinLabel holds the fewest replicas the gateway's autoscaler keeps,
+// which idle functions are not scaled below
+const scaleMinLabel = "com.openfaas.scale.min"
+
+// scaleStepsAnnotation holds the replicas to scale an idle function down
+// through, i.e. 5,2,1
+const scaleStepsAnnotation = "com.openfaas.scale.steps"
+
+// idleThresholdAnnotation holds the invocations per second at or below which
+// a function counts as idle, i.e. 0.01
+const idleThresholdAnnotation = "com.openfaas.idle.threshold"
+
+// coldStartsLabel holds the most times per hour a function may be woken
+// after being idled, i.e. 4
+const coldStartsLabel = "com.openfaas.max-cold-starts-per-hour"
+
+// slaAnnotation holds the p99 latency a function promises, i.e. 200ms or
+// 200 in milliseconds, which a cold start would break
+const slaAnnotation = "com.openfaas.sla.p99"
+
+// snoozeAnnotation holds an RFC3339 time until which a function is left
+// alone, i.e. 2019-04-01T18:00:00Z during an incident or a demo
+const snoozeAnnotation = "com.openfaas.scale.zero.snooze-until"
+
+// drainDelayAnnotation holds how long to wait between deciding to idle a
+// function and scaling it, i.e. 30s
+const drainDelayAnnotation = "com.openfaas.drain.delay"
+
+// Policy is the effective idling policy of a single function
+type Policy struct {
+	// Enabled is set when the function opted in with the scale to zero label
+	Enabled bool `yaml:"enabled"`
+	// Never is set when the function must not be scaled to zero
+	Never              bool          `yaml:"never,omitempty"`
+	InactivityDuration time.Duration `yaml:"inactivity_duration"`
+	Tier               string        `yaml:"tier,omitempty"`
+	// ScaleTarget is scale_target, or the one of the function's namespace
+	ScaleTarget uint64 `yaml:"scale_target,omitempty"`
+	// ScaleMin is the function's com.openfaas.scale.min, idling scales it
+	// to no fewer replicas
+	ScaleMin uint64 `yaml:"scale_min,omitempty"`
+	// IdleThreshold is the invocation rate at or below which the function
+	// counts as idle
+	IdleThreshold float64 `yaml:"idle_threshold,omitempty"`
+	// DrainDelay lets load balancers drain connections before scaling
+	DrainDelay time.Duration `yaml:"drain_delay,omitempty"`
+	// Prewarm is cron expressions at which the function is scaled up to
+	// PrewarmReplicas, 1 when zero, ahead of known traffic
+	Prewarm         string `yaml:"prewarm,omitempty"`
+	PrewarmReplicas uint64 `yaml:"prewarm_replicas,omitempty"`
+	// ScaleDownSteps are replicas, largest first, the function is scaled
+	// down through one idle reconcile at a time
+	ScaleDownSteps []uint64 `yaml:"scale_down_steps,omitempty"`
+	// MaxColdStartsPerHour stops idling the function once it was woken this
+	// many times within the last hour, zero for no budget
+	MaxColdStartsPerHour int `yaml:"max_cold_starts_per_hour,omitempty"`
+	// SLAGuarded is set when the function's com.openfaas.sla.p99 is tighter
+	// than sla_guard_threshold, it is then never idled to zero replicas
+	SLAP99     time.Duration `yaml:"sla_p99,omitempty"`
+	SLAGuarded bool          `yaml:"sla_guarded,omitempty"`
+	// SnoozeUntil is the function's com.openfaas.scale.zero.snooze-until,
+	// it is not idled before then
+	SnoozeUntil time.Time `yaml:"snooze_until,omitempty"`
+}
+
+// resolvePolicy applies the function's labels over the gateway's global
+// configuration, then any policy files loaded from git over both, from the
+// least to the most specific
+func resolvePolicy(fn Function, config types.Config) Policy {
+	policy := Policy{
+		Enabled:            true,
+		InactivityDuration: config.InactivityDuration,
+		DrainDelay:         config.DrainDelay,
+		IdleThreshold:      config.IdleThreshold,
+		ScaleDownSteps:     config.ScaleDownSteps,
+		ScaleTarget:        config.ScaleTarget,
+
+		MaxColdStartsPerHour: config.MaxColdStartsPerHour,
+	}
+
+	label := scaleLabel
+	if namespacePolicy, exists := config.NamespacePolicies[fn.Namespace]; exists {
+		if namespacePolicy.InactivityDuration > 0 {
+			policy.InactivityDuration = namespacePolicy.InactivityDuration
+		}
+		if len(namespacePolicy.ScaleLabel) > 0 {
+			label = namespacePolicy.ScaleLabel
+		}
+		if namespacePolicy.ScaleTarget != nil {
+			policy.ScaleTarget = *namespacePolicy.ScaleTarget
+		}
+	}
+
+	// Functions without any labels have always been treated as opted in,
+	// with idle_by_default only an explicit label opts out. The label may be
+	// set as an annotation where labels are stripped.
+	if value := settingValue(fn, label); fn.Labels != nil || len(value) > 0 {
+		if config.IdleByDefault {
+			policy.Enabled = value != "0" && value != "false"
+		} else {
+			policy.Enabled = value == "1" || value == "true"
+		}
+	}
+	policy.applyTier(settingValue(fn, tierLabel), config)
+
+	if value := settingValue(fn, scaleMinLabel); len(value) > 0 {
+		if min, err := strconv.ParseUint(value, 10, 64); err == nil {
+			policy.ScaleMin = min
+		} else {
+			log.Printf("Invalid %s label on %s: %s\n", scaleMinLabel, fn.Name, err)
+		}
+	}
+
+	if value := settingValue(fn, coldStartsLabel); len(value) > 0 {
+		if budget, err := strconv.Atoi(value); err == nil && budget >= 0 {
+			policy.MaxColdStartsPerHour = budget
+		} else {
+			log.Printf("Invalid %s label on %s: %q\n", coldStartsLabel, fn.Name, value)
+		}
+	}
+
+	if value := annotationValue(fn, drainDelayAnnotation); len(value) > 0 {
+		if delay, err := time.ParseDuration(value); err == nil {
+			policy.DrainDelay = delay
+		} else {
+			log.Printf("Invalid %s annotation on %s: %s\n", drainDelayAnnotation, fn.Name, err)
+		}
+	}
+
+	if value := annotationValue(fn, idleThresholdAnnotation); len(value) > 0 {
+		if threshold, err := types.ParseIdleThreshold(value); err == nil {
+			policy.IdleThreshold = threshold
+		} else {
+			log.Printf("Invalid %s annotation on %s: %s\n", idleThresholdAnnotation, fn.Name, err)
+		}
+	}
+
+	if value := annotationValue(fn, scaleStepsAnnotation); len(value) > 0 {
+		if steps, err := types.ParseScaleSteps(value); err == nil {
+			policy.ScaleDownSteps = steps
+		} else {
+			log.Printf("Invalid %s annotation on %s: %s\n", scaleStepsAnnotation, fn.Name, err)
+		}
+	}
+
+	policy.Prewarm = annotationValue(fn, prewarmAnnotation)
+	if value := annotationValue(fn, prewarmReplicasAnnotation); len(value) > 0 {
+		if replicas, err := parsePrewarmReplicas(value); err == nil {
+			policy.PrewarmReplicas = replicas
+		} else {
+			log.Printf("Invalid %s annotation on %s: %s\n", prewarmReplicasAnnotation, fn.Name, err)
+		}
+	}
+
+	if value := annotationValue(fn, snoozeAnnotation); len(value) > 0 {
+		if until, err := time.Parse(time.RFC3339, value); err == nil {
+			policy.SnoozeUntil = until
+		} else {
+			log.Printf("Invalid %s annotation on %s: %s\n", snoozeAnnotation, fn.Name, err)
+		}
+	}
+
+	for _, override := range policyFiles.lookup(config.Name, fn) {
+		if override.Enabled != nil {
+			policy.Enabled = *override.Enabled
+		}
+		if override.Tier != nil {
+			policy.applyTier(*override.Tier, config)
+		}
+		if override.Never != nil {
+			policy.Never = *override.Never
+		}
+		if override.InactivityDuration != nil {
+			policy.InactivityDuration = *override.InactivityDuration
+		}
+		if override.DrainDelay != nil {
+			policy.DrainDelay = *override.DrainDelay
+		}
+		if override.IdleThreshold != nil && *override.IdleThreshold >= 0 {
+			policy.IdleThreshold = *override.IdleThreshold
+		}
+		if override.ScaleDownSteps != nil {
+			policy.ScaleDownSteps = sortedSteps(*override.ScaleDownSteps)
+		}
+		if override.Prewarm != nil {
+			policy.Prewarm = *override.Prewarm
+		}
+		if override.PrewarmReplicas != nil && *override.PrewarmReplicas > 0 {
+			policy.PrewarmReplicas = *override.PrewarmReplicas
+		}
+	}
+
+	// The SLA is applied last, opting in or a policy file cannot lift it
+	if value := annotationValue(fn, slaAnnotation); len(value) > 0 {
+		if p99, err := parseSLA(value); err == nil {
+			policy.SLAP99 = p99
+			policy.SLAGuarded = slaGuarded(fn, config)
+		} else {
+			log.Printf("Invalid %s annotation on %s: %s\n", slaAnnotation, fn.Name, err)
+		}
+	}
+	return policy
+}
+
+// snoozed reports whether the function is left alone at the given time by
+// its com.openfaas.scale.zero.snooze-until
+func (p Policy) snoozed(now time.Time) bool {
+	return now.Before(p.SnoozeUntil)
+}
+
+// slaGuarded reports whether the function's com.openfaas.sla.p99 is tighter
+// than sla_guard_threshold, so that it must keep a replica
+func slaGuarded(fn Function, config types.Config) bool {
+	p99, err := parseSLA(annotationValue(fn, slaAnnotation))
+	return err == nil && config.SLAGuardThreshold > 0 && p99 < config.SLAGuardThreshold
+}
+
+// parseSLA reads a latency as a duration, or a number of milliseconds
+func parseSLA(value string) (time.Duration, error) {
+	if ms, err := strconv.ParseFloat(value, 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond)), nil
+	}
+	p99, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if p99 <= 0 {
+		return 0, fmt.Errorf("must be greater than 0, got: %s", value)
+	}
+	return p99, nil
+}
+
+// idleTarget is the replicas the function is idled to, its scale target
+// unless its com.openfaas.scale.min is higher, and at least 1 when guarded
+// by its SLA
+func (p Policy) idleTarget() uint64 {
+	target := p.ScaleTarget
+	if p.ScaleMin > target {
+		target = p.ScaleMin
+	}
+	if p.SLAGuarded && target == 0 {
+		target = 1
+	}
+	return target
+}
+
+// scaleDownTarget is the replicas an idle function running the given
+// replicas is scaled to by this pass: the largest step below them and above
+// the final target, or the final target once no step is left
+func (p Policy) scaleDownTarget(replicas uint64, target uint64) uint64 {
+	for _, step := range p.ScaleDownSteps {
+		if step < replicas && step > target {
+			return step
+		}
+	}
+	return target
+}
+
+// sortedSteps orders scale down steps largest first
+func sortedSteps(steps []uint64) []uint64 {
+	sorted := append([]uint64{}, steps...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] > sorted[j]
+	})
+	return sorted
+}
+
+// applyTier sets the tier and its guard rails from tier_policies
+func (p *Policy) applyTier(tier string, config types.Config) {
+	p.Tier = tier
+	if len(tier) == 0 {
+		return
+	}
+
+	if tierPolicy, exists := config.TierPolicies[tier]; exists {
+		p.Never = tierPolicy.Never
+		if tierPolicy.InactivityDuration > 0 {
+			p.InactivityDuration = tierPolicy.InactivityDuration
+		}
+	}
+}
+
+func resolvePolicies(functions []Function, config types.Config) map[string]Policy {
+	policies := make(map[string]Policy)
+	for _, fn := range functions {
+		policies[fn.Name] = resolvePolicy(fn, config)
+	}
+	return policies
+}
+
+func labelValue(fn Function, key string) string {
+	if fn.Labels == nil {
+		return ""
+	}
+	return (*fn.Labels)[key]
+}
+
+// settingValue reads a per-function setting from the function's labels, or
+// from its annotations when no label holds it
+func settingValue(fn Function, key string) string {
+	if value := labelValue(fn, key); len(value) > 0 {
+		return value
+	}
+	return annotationValue(fn, key)
+}
+
+func annotationValue(fn Function, key string) string {
+	if fn.Annotations == nil {
+		return ""
+	}
+	return (*fn.Annotations)[key]
+}
+
+// promDuration formats a duration as a Prometheus range such as 5m or 90s
+func promDuration(duration time.Duration) string {
+	if duration%time.Minute == 0 {
+		return fmt.Sprintf("%dm", int(duration.Minutes()))
+	}
+	return fmt.Sprintf("%ds", int(duration.Seconds()))
+}
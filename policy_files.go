@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// policyFiles holds the policies loaded from git, nil when none are configured
+var policyFiles *policyStore
+
+// PolicyFile is the format of the policy files read from git, it matches the
+// output of export-policies so an export can be committed as-is
+type PolicyFile struct {
+	// Gateway restricts the file to one gateway when set
+	Gateway   string           `yaml:"gateway"`
+	Functions []PolicyOverride `yaml:"functions"`
+}
+
+// PolicyOverride overrides the resolved policy of the functions it matches,
+// an entry without a name matches every function in its namespace and one
+// without a namespace matches any namespace
+type PolicyOverride struct {
+	Name               string         `yaml:"name"`
+	Namespace          string         `yaml:"namespace"`
+	Enabled            *bool          `yaml:"enabled"`
+	Never              *bool          `yaml:"never"`
+	InactivityDuration *time.Duration `yaml:"inactivity_duration"`
+	Tier               *string        `yaml:"tier"`
+	DrainDelay         *time.Duration `yaml:"drain_delay"`
+	IdleThreshold      *float64       `yaml:"idle_threshold"`
+	Prewarm            *string        `yaml:"prewarm"`
+	PrewarmReplicas    *uint64        `yaml:"prewarm_replicas"`
+	ScaleDownSteps     *[]uint64      `yaml:"scale_down_steps"`
+
+	gateway string
+}
+
+func (o PolicyOverride) matches(gateway string, fn Function) bool {
+	return (len(o.gateway) == 0 || o.gateway == gateway) &&
+		(len(o.Namespace) == 0 || o.Namespace == fn.Namespace) &&
+		(len(o.Name) == 0 || o.Name == fn.Name)
+}
+
+// specificity orders overrides so that those naming a function win over
+// namespace-wide ones, which win over global ones
+func (o PolicyOverride) specificity() int {
+	specificity := 0
+	if len(o.Name) > 0 {
+		specificity += 2
+	}
+	if len(o.Namespace) > 0 {
+		specificity++
+	}
+	return specificity
+}
+
+type policyStore struct {
+	mu        sync.RWMutex
+	overrides []PolicyOverride
+	revision  string
+}
+
+// lookup returns every override matching the function, most specific last
+func (s *policyStore) lookup(gateway string, fn Function) []PolicyOverride {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matched := []PolicyOverride{}
+	for _, override := range s.overrides {
+		if override.matches(gateway, fn) {
+			matched = append(matched, override)
+		}
+	}
+	return matched
+}
+
+// replace swaps in a new set of overrides
+func (s *policyStore) replace(overrides []PolicyOverride, revision string) {
+	sort.SliceStable(overrides, func(i, j int) bool {
+		return overrides[i].specificity() < overrides[j].specificity()
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.overrides = overrides
+	s.revision = revision
+}
+
+// loadPolicyDir parses every .yml and .yaml file under dir, any invalid file
+// fails the whole load so a bad commit never applies partially
+func loadPolicyDir(dir string) ([]PolicyOverride, error) {
+	overrides := []PolicyOverride{}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext != ".yml" && ext != ".yaml" {
+			return nil
+		}
+
+		data, readErr := ioutil.ReadFile(path)
+		if readErr != nil {
+			return readErr
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		for {
+			file := PolicyFile{}
+			if decodeErr := decoder.Decode(&file); decodeErr != nil {
+				if decodeErr == io.EOF {
+					break
+				}
+				return fmt.Errorf("%s: %s", path, decodeErr)
+			}
+
+			for _, override := range file.Functions {
+				override.gateway = file.Gateway
+				overrides = append(overrides, override)
+			}
+		}
+		return nil
+	})
+
+	return overrides, err
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/types"
+)
+
+// gitPolicySource keeps a shallow clone of the policy repository up to date
+// and loads its policy files into policyFiles
+type gitPolicySource struct {
+	config types.Config
+	store  *policyStore
+	syncs  chan struct{}
+}
+
+func newGitPolicySource(config types.Config, store *policyStore) *gitPolicySource {
+	return &gitPolicySource{
+		config: config,
+		store:  store,
+		syncs:  make(chan struct{}, 1),
+	}
+}
+
+// run syncs on every poll interval and whenever the webhook fires, it never
+// returns
+func (g *gitPolicySource) run() {
+	var ticks <-chan time.Time
+	if g.config.PolicyGitPollInterval > 0 {
+		ticker := time.NewTicker(g.config.PolicyGitPollInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case <-ticks:
+		case <-g.syncs:
+		}
+
+		if err := g.sync(); err != nil {
+			log.Printf("Unable to sync policies from %s: %s\n", redactURL(g.config.PolicyGitURL), err)
+		}
+	}
+}
+
+// trigger requests a sync without waiting for it
+func (g *gitPolicySource) trigger() {
+	select {
+	case g.syncs <- struct{}{}:
+	default:
+	}
+}
+
+// sync fetches the branch and reloads the policy files when the revision
+// changed, a failed load keeps the previous policies in place
+func (g *gitPolicySource) sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	dir := g.config.PolicyGitDir
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if cloneErr := g.clone(ctx, dir); cloneErr != nil {
+			return cloneErr
+		}
+	} else {
+		if _, fetchErr := git(ctx, dir, "fetch", "--quiet", "--depth", "1", "origin", g.config.PolicyGitBranch); fetchErr != nil {
+			return fetchErr
+		}
+		if _, resetErr := git(ctx, dir, "reset", "--quiet", "--hard", "FETCH_HEAD"); resetErr != nil {
+			return resetErr
+		}
+	}
+
+	revision, err := git(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	g.store.mu.RLock()
+	unchanged := g.store.revision == revision
+	g.store.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	overrides, err := loadPolicyDir(filepath.Join(dir, g.config.PolicyGitPath))
+	if err != nil {
+		return fmt.Errorf("revision %s: %s", revision, err)
+	}
+
+	g.store.replace(overrides, revision)
+	log.Printf("Loaded %d policies from %s at revision %s\n", len(overrides), redactURL(g.config.PolicyGitURL), revision)
+	return nil
+}
+
+// clone clones the branch into a temporary directory next to dir and renames
+// it into place. dir may be missing or empty, anything else is refused rather
+// than deleted.
+func (g *gitPolicySource) clone(ctx context.Context, dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("policy_git_dir %s is not a directory", dir)
+		}
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return fmt.Errorf("policy_git_dir %s is not empty and not a git checkout", dir)
+		}
+	}
+
+	parent := filepath.Dir(dir)
+	if err := os.MkdirAll(parent, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(parent, ".faas-idler-clone-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if _, err := git(ctx, "", "clone", "--quiet", "--depth", "1", "--branch", g.config.PolicyGitBranch, "--", g.config.PolicyGitURL, tmp); err != nil {
+		return err
+	}
+
+	// Only an empty directory is removed
+	if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+// redactURL hides the credentials of a repository URL, the username too as
+// tokens are often given as one
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return redactCredentials(raw)
+	}
+	u.User = url.User("xxxxx")
+	return u.String()
+}
+
+var urlCredentials = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/@\s]+@`)
+
+// redactCredentials hides the credentials of any URL in git's output
+func redactCredentials(text string) string {
+	return urlCredentials.ReplaceAllString(text, "${1}xxxxx@")
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s: %s", args[0], err, redactCredentials(strings.TrimSpace(string(out))))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// makePolicySyncHandler triggers a sync from a push webhook, when a secret is
+// configured the body must carry a valid X-Hub-Signature-256
+func makePolicySyncHandler(source *gitPolicySource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if source == nil {
+			http.Error(w, "policy_git_url is not set", http.StatusNotFound)
+			return
+		}
+
+		if secret := source.config.PolicyGitWebhookSecret; len(secret) > 0 {
+			body, _ := ioutil.ReadAll(r.Body)
+
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(body)
+			want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+			if !hmac.Equal([]byte(want), []byte(r.Header.Get("X-Hub-Signature-256"))) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+
+		source.trigger()
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_Policy_scaleDownTarget(t *testing.T) {
+	policy := Policy{ScaleDownSteps: []uint64{5, 2, 1}}
+
+	steps := []struct {
+		replicas uint64
+		target   uint64
+		want     uint64
+	}{
+		{replicas: 10, want: 5},
+		{replicas: 5, want: 2},
+		{replicas: 3, want: 2},
+		{replicas: 2, want: 1},
+		{replicas: 1, want: 0},
+		// No step is taken below scale_target
+		{replicas: 5, target: 2, want: 2},
+	}
+	for _, step := range steps {
+		if got := policy.scaleDownTarget(step.replicas, step.target); got != step.want {
+			t.Errorf("%d replicas to %d: wanted: %d got: %d", step.replicas, step.target, step.want, got)
+		}
+	}
+
+	if got := (Policy{}).scaleDownTarget(5, 0); got != 0 {
+		t.Errorf("Without steps a function should be scaled straight to its target, got: %d", got)
+	}
+}
+
+func Test_resolvePolicy_scaleMin(t *testing.T) {
+	labels := map[string]string{scaleLabel: "true", scaleMinLabel: "2"}
+	fn := Function{Function: requests.Function{Name: "figlet", Labels: &labels}}
+
+	policy := resolvePolicy(fn, types.Config{})
+	if policy.ScaleMin != 2 {
+		t.Fatalf("ScaleMin wanted: 2 got: %d", policy.ScaleMin)
+	}
+	if target := policy.idleTarget(); target != 2 {
+		t.Errorf("com.openfaas.scale.min should be the target, got: %d", target)
+	}
+	policy.ScaleTarget = 3
+	if target := policy.idleTarget(); target != 3 {
+		t.Errorf("A higher scale_target should be kept, got: %d", target)
+	}
+
+	labels[scaleMinLabel] = "some"
+	if policy := resolvePolicy(fn, types.Config{}); policy.ScaleMin != 0 {
+		t.Errorf("An invalid label should be ignored, got: %d", policy.ScaleMin)
+	}
+}
+
+func Test_resolvePolicy_coldStarts(t *testing.T) {
+	labels := map[string]string{scaleLabel: "true"}
+	fn := Function{Function: requests.Function{Name: "figlet", Labels: &labels}}
+	config := types.Config{MaxColdStartsPerHour: 4}
+
+	if policy := resolvePolicy(fn, config); policy.MaxColdStartsPerHour != 4 {
+		t.Errorf("max_cold_starts_per_hour should be the default, got: %d", policy.MaxColdStartsPerHour)
+	}
+
+	labels[coldStartsLabel] = "0"
+	if policy := resolvePolicy(fn, config); policy.MaxColdStartsPerHour != 0 {
+		t.Errorf("The label should override the budget, got: %d", policy.MaxColdStartsPerHour)
+	}
+
+	labels[coldStartsLabel] = "-1"
+	if policy := resolvePolicy(fn, config); policy.MaxColdStartsPerHour != 4 {
+		t.Errorf("An invalid label should be ignored, got: %d", policy.MaxColdStartsPerHour)
+	}
+}
+
+func Test_resolvePolicy_sla(t *testing.T) {
+	labels := map[string]string{scaleLabel: "true"}
+	annotations := map[string]string{slaAnnotation: "250ms"}
+	fn := Function{Function: requests.Function{Name: "checkout", Labels: &labels}, Annotations: &annotations}
+	config := types.Config{SLAGuardThreshold: time.Second * 2}
+
+	policy := resolvePolicy(fn, config)
+	if !policy.Enabled || !policy.SLAGuarded || policy.SLAP99 != time.Millisecond*250 {
+		t.Fatalf("A tight SLA should guard the function, got: %+v", policy)
+	}
+	if target := policy.idleTarget(); target != 1 {
+		t.Errorf("A guarded function should keep a replica, got: %d", target)
+	}
+
+	annotations[slaAnnotation] = "3000"
+	if policy := resolvePolicy(fn, config); policy.SLAGuarded || policy.SLAP99 != time.Second*3 {
+		t.Errorf("An SLA looser than sla_guard_threshold should not guard the function, got: %+v", policy)
+	}
+
+	annotations[slaAnnotation] = "fast"
+	if policy := resolvePolicy(fn, config); policy.SLAGuarded {
+		t.Errorf("An invalid SLA should be ignored")
+	}
+
+	annotations[slaAnnotation] = "100ms"
+	if policy := resolvePolicy(fn, types.Config{}); policy.SLAGuarded {
+		t.Errorf("SLAs should be ignored without sla_guard_threshold")
+	}
+}
+
+func Test_resolvePolicy_snooze(t *testing.T) {
+	labels := map[string]string{scaleLabel: "true"}
+	annotations := map[string]string{snoozeAnnotation: "2019-04-01T18:00:00+02:00"}
+	fn := Function{Function: requests.Function{Name: "checkout", Labels: &labels}, Annotations: &annotations}
+
+	policy := resolvePolicy(fn, types.Config{})
+	if !policy.Enabled || !policy.SnoozeUntil.Equal(time.Date(2019, time.April, 1, 16, 0, 0, 0, time.UTC)) {
+		t.Fatalf("The snooze should be read from the annotation, got: %+v", policy)
+	}
+	if !policy.snoozed(time.Date(2019, time.April, 1, 15, 59, 0, 0, time.UTC)) {
+		t.Errorf("The function should be snoozed before the time")
+	}
+	if policy.snoozed(time.Date(2019, time.April, 1, 16, 0, 0, 0, time.UTC)) {
+		t.Errorf("The function should not be snoozed once the time passed")
+	}
+
+	annotations[snoozeAnnotation] = "tomorrow"
+	if policy := resolvePolicy(fn, types.Config{}); policy.snoozed(time.Now()) {
+		t.Errorf("An invalid snooze should be ignored")
+	}
+}
+
+func Test_resolvePolicy_idleByDefault(t *testing.T) {
+	cases := []struct {
+		label         string
+		idleByDefault bool
+		enabled       bool
+	}{
+		{"", false, false},
+		{"true", false, true},
+		{"", true, true},
+		{"1", true, true},
+		{"false", true, false},
+		{"0", true, false},
+	}
+
+	for _, c := range cases {
+		labels := map[string]string{"team": "blue"}
+		if len(c.label) > 0 {
+			labels[scaleLabel] = c.label
+		}
+		fn := Function{Function: requests.Function{Name: "figlet", Labels: &labels}}
+
+		policy := resolvePolicy(fn, types.Config{IdleByDefault: c.idleByDefault})
+		if policy.Enabled != c.enabled {
+			t.Errorf("label %q idle_by_default %t: enabled wanted: %t got: %t", c.label, c.idleByDefault, c.enabled, policy.Enabled)
+		}
+	}
+}
+
+func Test_resolvePolicy_annotations(t *testing.T) {
+	config := types.Config{TierPolicies: map[string]types.TierPolicy{"gold": {InactivityDuration: time.Hour}}}
+
+	labels := map[string]string{"team": "blue"}
+	annotations := map[string]string{scaleLabel: "true", tierLabel: "gold"}
+	fn := Function{Function: requests.Function{Name: "figlet", Labels: &labels}, Annotations: &annotations}
+	if policy := resolvePolicy(fn, config); !policy.Enabled || policy.Tier != "gold" || policy.InactivityDuration != time.Hour {
+		t.Errorf("Annotations should opt in and set the tier, got: %+v", policy)
+	}
+
+	// Without labels an annotation can still opt out
+	optOut := map[string]string{scaleLabel: "false"}
+	fn = Function{Function: requests.Function{Name: "figlet"}, Annotations: &optOut}
+	if policy := resolvePolicy(fn, config); policy.Enabled {
+		t.Errorf("An annotation should opt out a function without labels")
+	}
+
+	// A label takes precedence over an annotation
+	labels[scaleLabel] = "false"
+	fn = Function{Function: requests.Function{Name: "figlet", Labels: &labels}, Annotations: &annotations}
+	if policy := resolvePolicy(fn, config); policy.Enabled {
+		t.Errorf("The label should take precedence over the annotation")
+	}
+}
+
+func Test_resolvePolicy_namespacePolicies(t *testing.T) {
+	one := uint64(1)
+	config := types.Config{
+		InactivityDuration: time.Minute * 15,
+		NamespacePolicies: map[string]types.NamespacePolicy{
+			"dev":  {InactivityDuration: time.Minute * 5, ScaleLabel: "idle"},
+			"prod": {InactivityDuration: time.Hour, ScaleTarget: &one},
+		},
+	}
+
+	devLabels := map[string]string{"idle": "true"}
+	dev := Function{Function: requests.Function{Name: "figlet", Labels: &devLabels}, Namespace: "dev"}
+	if policy := resolvePolicy(dev, config); !policy.Enabled || policy.InactivityDuration != time.Minute*5 || policy.idleTarget() != 0 {
+		t.Errorf("dev: unexpected policy %+v", policy)
+	}
+
+	prodLabels := map[string]string{scaleLabel: "true", tierLabel: "gold"}
+	prod := Function{Function: requests.Function{Name: "figlet", Labels: &prodLabels}, Namespace: "prod"}
+	if policy := resolvePolicy(prod, config); !policy.Enabled || policy.InactivityDuration != time.Hour || policy.idleTarget() != 1 {
+		t.Errorf("prod: unexpected policy %+v", policy)
+	}
+
+	// A tier is more specific than a namespace
+	config.TierPolicies = map[string]types.TierPolicy{"gold": {InactivityDuration: time.Hour * 2}}
+	if policy := resolvePolicy(prod, config); policy.InactivityDuration != time.Hour*2 {
+		t.Errorf("prod gold: inactivity duration wanted: %s got: %s", time.Hour*2, policy.InactivityDuration)
+	}
+
+	// The namespace's label replaces com.openfaas.scale.zero
+	if policy := resolvePolicy(Function{Function: requests.Function{Name: "echo", Labels: &prodLabels}, Namespace: "dev"}, config); policy.Enabled {
+		t.Errorf("dev: a function without the namespace's label should not be enabled")
+	}
+}
+
+func Test_resolvePolicy_scaleSteps(t *testing.T) {
+	config := types.Config{ScaleDownSteps: []uint64{2, 1}}
+
+	annotations := map[string]string{scaleStepsAnnotation: "1, 4"}
+	fn := Function{Function: requests.Function{Name: "figlet"}, Annotations: &annotations}
+	if policy := resolvePolicy(fn, config); len(policy.ScaleDownSteps) != 2 || policy.ScaleDownSteps[0] != 4 {
+		t.Errorf("Steps wanted: [4 1] got: %v", policy.ScaleDownSteps)
+	}
+
+	invalid := map[string]string{scaleStepsAnnotation: "0"}
+	fn = Function{Function: requests.Function{Name: "figlet"}, Annotations: &invalid}
+	if policy := resolvePolicy(fn, config); len(policy.ScaleDownSteps) != 2 || policy.ScaleDownSteps[0] != 2 {
+		t.Errorf("An invalid annotation should keep scale_down_steps, got: %v", policy.ScaleDownSteps)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/types"
+)
+
+// A pattern is only trusted when activity recurred at its period for this
+// share of the active steps, over at least this many of them
+const (
+	predictMinScore       = 0.6
+	predictMinOccurrences = 3
+)
+
+// seasonalForecast is what predictive_idling found in a function's history
+type seasonalForecast struct {
+	// Period is how often activity recurs, zero without a pattern
+	Period time.Duration
+	// Next is how soon activity is expected, when within the horizon
+	Next     time.Duration
+	Expected bool
+}
+
+// activityBuckets marks the steps of the history in which a function was
+// invoked, the most recent last
+func activityBuckets(ctx context.Context, gateway *Gateway, fn Function, now time.Time, config types.Config) ([]bool, error) {
+	step := config.PredictiveStep
+	end := now.Add(-config.QueryOffset).Truncate(step)
+	start := end.Add(-config.PredictiveHistory)
+
+	res, err := fetchPrometheusRange(ctx, gateway.PrometheusClient, rangeActivityQuery(fn.Name, step), start, end, step, config)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]bool, int(config.PredictiveHistory/step)+1)
+	for _, series := range res.Data.Result {
+		if series.Metric["function_name"] != fn.Name {
+			continue
+		}
+		for _, sample := range series.Values {
+			at, ok := sample[0].(float64)
+			if !ok {
+				continue
+			}
+			increase, ok, err := vectorValue(sample)
+			if err != nil || !ok || increase <= 0 {
+				continue
+			}
+			i := int(math.Round((at - float64(start.Unix())) / step.Seconds()))
+			if i >= 0 && i < len(buckets) {
+				buckets[i] = true
+			}
+		}
+	}
+	return buckets, nil
+}
+
+// seasonalPeriod returns the lag, in steps, at which the activity of the
+// buckets recurs most consistently, the shortest of equally good ones, or
+// zero when none does
+func seasonalPeriod(buckets []bool) int {
+	best, bestScore := 0, 0.0
+	for lag := 2; lag <= len(buckets)/2; lag++ {
+		active, recurring := 0, 0
+		for i := lag; i < len(buckets); i++ {
+			if !buckets[i] {
+				continue
+			}
+			active++
+			if buckets[i-lag] {
+				recurring++
+			}
+		}
+		if active < predictMinOccurrences {
+			continue
+		}
+		if score := float64(recurring) / float64(active); score >= predictMinScore && score > bestScore {
+			best, bestScore = lag, score
+		}
+	}
+	return best
+}
+
+// expectedWithin returns after how many steps, up to horizon, activity is
+// expected: the first step at which at least half of the past cycles of the
+// period were active, within a step either way for jitter
+func expectedWithin(buckets []bool, period int, horizon int) (int, bool) {
+	activeAround := func(i int) bool {
+		for j := i - 1; j <= i+1; j++ {
+			if j >= 0 && j < len(buckets) && buckets[j] {
+				return true
+			}
+		}
+		return false
+	}
+
+	last := len(buckets) - 1
+	for ahead := 1; ahead <= horizon; ahead++ {
+		cycles, active := 0, 0
+		for past := last + ahead - period; past >= 0; past -= period {
+			cycles++
+			if activeAround(past) {
+				active++
+			}
+		}
+		if cycles > 0 && active*2 >= cycles {
+			return ahead, true
+		}
+	}
+	return 0, false
+}
+
+// predictDefers reports whether scaling a function to zero is deferred by
+// predictive_idling. A forecast which fails is counted and does not defer
+// anything, the function is idle after all.
+func predictDefers(ctx context.Context, gateway *Gateway, fn Function, target uint64, now time.Time, summary *cycleSummary) (seasonalForecast, bool) {
+	if !gateway.Config.PredictiveIdling || target > 0 {
+		return seasonalForecast{}, false
+	}
+
+	forecast, err := forecastActivity(ctx, gateway, fn, now)
+	if err != nil {
+		log.Printf("[%s] Unable to forecast the invocations of %s: %s\n", gateway.Name, fn.Name, err)
+		summary.fail("forecast", err)
+		return forecast, false
+	}
+	return forecast, forecast.Expected
+}
+
+// forecastActivity predicts with a seasonal model whether an idle function
+// is likely to be invoked within predictive_horizon, so that scaling it to
+// zero would only cause a cold start
+func forecastActivity(ctx context.Context, gateway *Gateway, fn Function, now time.Time) (seasonalForecast, error) {
+	config := gateway.Config
+	buckets, err := activityBuckets(ctx, gateway, fn, now, config)
+	if err != nil {
+		return seasonalForecast{}, err
+	}
+
+	period := seasonalPeriod(buckets)
+	if period == 0 {
+		return seasonalForecast{}, nil
+	}
+
+	horizon := config.PredictiveHorizon
+	if horizon <= 0 {
+		horizon = config.ReconcileInterval
+	}
+
+	forecast := seasonalForecast{Period: time.Duration(period) * config.PredictiveStep}
+	if ahead, expected := expectedWithin(buckets, period, int(math.Ceil(float64(horizon)/float64(config.PredictiveStep)))); expected {
+		forecast.Next = time.Duration(ahead) * config.PredictiveStep
+		forecast.Expected = true
+	}
+	return forecast, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+// everyNth marks every nth of the buckets active, the first at offset
+func everyNth(length int, n int, offset int) []bool {
+	buckets := make([]bool, length)
+	for i := offset; i < length; i += n {
+		buckets[i] = true
+	}
+	return buckets
+}
+
+func Test_seasonalPeriod(t *testing.T) {
+	if period := seasonalPeriod(everyNth(120, 15, 3)); period != 15 {
+		t.Errorf("Period wanted: 15 got: %d", period)
+	}
+
+	// Bursts lasting three steps every 20 steps
+	bursts := make([]bool, 120)
+	for i := 0; i < 120; i += 20 {
+		bursts[i], bursts[i+1], bursts[i+2] = true, true, true
+	}
+	if period := seasonalPeriod(bursts); period != 20 {
+		t.Errorf("Period of bursts wanted: 20 got: %d", period)
+	}
+
+	random := make([]bool, 120)
+	for _, i := range []int{3, 17, 22, 51, 58, 90, 101} {
+		random[i] = true
+	}
+	if period := seasonalPeriod(random); period != 0 {
+		t.Errorf("Random invocations should have no period, got: %d", period)
+	}
+
+	if period := seasonalPeriod(everyNth(120, 50, 10)); period != 0 {
+		t.Errorf("Two occurrences should not be a pattern, got: %d", period)
+	}
+}
+
+func Test_expectedWithin(t *testing.T) {
+	// Active at 3, 18, ... 108, the last bucket is 119, 123 is next
+	buckets := everyNth(120, 15, 3)
+
+	if ahead, expected := expectedWithin(buckets, 15, 5); !expected || ahead != 3 {
+		t.Errorf("Activity wanted in 3 steps (one early for jitter) got: %d %v", ahead, expected)
+	}
+	if _, expected := expectedWithin(buckets, 15, 2); expected {
+		t.Errorf("Activity should not be expected within 2 steps")
+	}
+}
+
+func Test_forecastActivity(t *testing.T) {
+	now := time.Date(2019, time.April, 1, 10, 0, 0, 0, time.UTC)
+	var received url.Values
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.URL.Query()
+		start, _ := strconv.ParseInt(received.Get("start"), 10, 64)
+
+		// Invoked every 15 minutes, the last time 12 minutes ago
+		values := []string{}
+		for minute := 3; minute <= 120; minute += 15 {
+			values = append(values, fmt.Sprintf(`[%d, "1"]`, start+int64(minute*60)))
+		}
+		fmt.Fprintf(w, `{"status": "success", "data": {"resultType": "matrix", "result": [
+			{"metric": {"function_name": "cron"}, "values": [%s]}]}}`, strings.Join(values, ", "))
+	}))
+	defer prometheus.Close()
+
+	parsed, _ := url.Parse(prometheus.URL)
+	host, portVal, _ := net.SplitHostPort(parsed.Host)
+	port, _ := strconv.Atoi(portVal)
+
+	gateway := newGateway(types.Config{
+		Name:              "test",
+		PrometheusHost:    host,
+		PrometheusPort:    port,
+		PredictiveIdling:  true,
+		PredictiveHistory: time.Hour * 2,
+		PredictiveStep:    time.Minute,
+		PredictiveHorizon: time.Minute * 5,
+	})
+	fn := Function{Function: requests.Function{Name: "cron"}}
+	summary := newCycleSummary("test", now)
+
+	forecast, deferred := predictDefers(context.Background(), gateway, fn, 0, now, summary)
+	if !deferred || forecast.Period != time.Minute*15 || forecast.Next != time.Minute*2 {
+		t.Errorf("Invocations wanted within 2m every 15m, got: %+v %v", forecast, deferred)
+	}
+	if received.Get("step") != "60" || received.Get("end") != strconv.FormatInt(now.Unix(), 10) {
+		t.Errorf("Unexpected range: %v", received)
+	}
+
+	if _, deferred := predictDefers(context.Background(), gateway, fn, 1, now, summary); deferred {
+		t.Errorf("Only scaling to zero should be deferred")
+	}
+
+	gateway.Config.PredictiveHorizon = time.Minute
+	if _, deferred := predictDefers(context.Background(), gateway, fn, 0, now, summary); deferred {
+		t.Errorf("Invocations beyond the horizon should not defer scaling")
+	}
+
+	prometheus.Close()
+	if _, deferred := predictDefers(context.Background(), gateway, fn, 0, now, summary); deferred || summary.failures() != 1 {
+		t.Errorf("A failed forecast should be counted and not defer scaling")
+	}
+}
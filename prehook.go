@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/types"
+)
+
+// preIdleHookAnnotation holds a URL POSTed the scale action before the
+// function is scaled to zero, i.e. http://figlet.openfaas-fn:8080/_/flush
+const preIdleHookAnnotation = "com.openfaas.scale.zero.pre-hook"
+
+// runPreIdleHook calls the com.openfaas.scale.zero.pre-hook of a function
+// about to be scaled to zero, so that it can flush caches or drain its
+// connections. An invalid URL, a failure, a non-2xx response or a reply
+// with "allow": false vetoes the scale down.
+func runPreIdleHook(ctx context.Context, gateway *Gateway, fn Function, action ScaleAction) error {
+	hookURL := settingValue(fn, preIdleHookAnnotation)
+	if len(hookURL) == 0 || action.Target > 0 {
+		return nil
+	}
+
+	parsed, err := url.Parse(hookURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+		return vetoError{hook: preIdleHookAnnotation, reason: fmt.Sprintf("invalid URL %q", hookURL)}
+	}
+
+	hook := types.Hook{Name: preIdleHookAnnotation, URL: hookURL, Timeout: gateway.Config.PreIdleHookTimeout}
+	response, err := runHook(ctx, hook, fn, action)
+	if err != nil {
+		return vetoError{hook: hook.Name, reason: err.Error()}
+	}
+	if response.Allow != nil && !*response.Allow {
+		reason := response.Reason
+		if len(reason) == 0 {
+			reason = "denied"
+		}
+		return vetoError{hook: hook.Name, reason: reason}
+	}
+	return nil
+}
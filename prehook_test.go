@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_runPreIdleHook(t *testing.T) {
+	status, reply := http.StatusOK, ""
+	calls := []ScaleAction{}
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := ScaleAction{}
+		json.NewDecoder(r.Body).Decode(&action)
+		calls = append(calls, action)
+		w.WriteHeader(status)
+		w.Write([]byte(reply))
+	}))
+	defer hook.Close()
+
+	gateway := newGateway(types.Config{Name: "test", PreIdleHookTimeout: time.Second})
+	annotations := map[string]string{preIdleHookAnnotation: hook.URL + "/flush"}
+	fn := Function{Function: requests.Function{Name: "figlet"}, Annotations: &annotations}
+
+	if err := runPreIdleHook(context.Background(), gateway, fn, newScaleAction(gateway, fn, 2, 0)); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if len(calls) != 1 || calls[0].Function != "figlet" || calls[0].Target != 0 {
+		t.Errorf("The hook should be called with the action, got: %+v", calls)
+	}
+
+	if err := runPreIdleHook(context.Background(), gateway, fn, newScaleAction(gateway, fn, 2, 1)); err != nil || len(calls) != 1 {
+		t.Errorf("A scale down step above zero should not call the hook, calls: %d err: %v", len(calls), err)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := runPreIdleHook(context.Background(), gateway, fn, newScaleAction(gateway, fn, 2, 0)); err == nil {
+		t.Errorf("A non-2xx response should veto the scale down")
+	}
+
+	status, reply = http.StatusOK, `{"allow": false, "reason": "draining"}`
+	if err := runPreIdleHook(context.Background(), gateway, fn, newScaleAction(gateway, fn, 2, 0)); err == nil || err.Error() != "vetoed by hook "+preIdleHookAnnotation+": draining" {
+		t.Errorf("A denial should veto the scale down, got: %v", err)
+	}
+
+	annotations[preIdleHookAnnotation] = "file:///etc/passwd"
+	if err := runPreIdleHook(context.Background(), gateway, fn, newScaleAction(gateway, fn, 2, 0)); err == nil || len(calls) != 3 {
+		t.Errorf("An invalid URL should veto the scale down without a call, got: %v", err)
+	}
+
+	none := Function{Function: requests.Function{Name: "env"}}
+	if err := runPreIdleHook(context.Background(), gateway, none, newScaleAction(gateway, none, 2, 0)); err != nil {
+		t.Errorf("A function without a hook should be scaled, got: %s", err)
+	}
+}
+
+func Test_scaleDown_preIdleHook(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer hook.Close()
+
+	scales := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scales++
+	}))
+	defer server.Close()
+
+	gateway := newGateway(types.Config{Name: "test", GatewayURL: server.URL + "/", PreIdleHookTimeout: time.Second})
+	annotations := map[string]string{preIdleHookAnnotation: hook.URL}
+	fn := Function{Function: requests.Function{Name: "figlet"}, Annotations: &annotations}
+	summary := newCycleSummary("test", time.Now())
+
+	scaleDown(context.Background(), gateway, fn, 0, 0, 1, 0, summary)
+	if scales != 0 || summary.Skipped[skipVetoed] != 1 {
+		t.Errorf("A function whose pre-idle hook failed should not be scaled, scales: %d skipped: %v", scales, summary.Skipped)
+	}
+}
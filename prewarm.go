@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prewarmAnnotation holds cron expressions separated by ; at which a function
+// is scaled up ahead of known traffic, i.e. "45 8 * * 1-5", to the replicas
+// of prewarmReplicasAnnotation, 1 by default
+const (
+	prewarmAnnotation         = "com.openfaas.prewarm"
+	prewarmReplicasAnnotation = "com.openfaas.prewarm.replicas"
+)
+
+// prewarmStore remembers when the pre-warm schedule of each function was
+// last checked, so that a time matched between two passes is not missed
+type prewarmStore struct {
+	mu      sync.Mutex
+	checked *lruCache
+}
+
+func newPrewarmStore(maxEntries int, ttl time.Duration) *prewarmStore {
+	return &prewarmStore{
+		checked: newLRUCache(maxEntries, ttl),
+	}
+}
+
+// due reports whether schedule matched a minute since the function was last
+// checked, or within since before now for one not checked yet
+func (p *prewarmStore) due(name string, schedule []cronExpr, since time.Duration, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	last := now.Add(-since)
+	if value, exists := p.checked.get(name); exists {
+		last = value.(time.Time)
+	}
+	p.checked.set(name, now)
+
+	for _, expr := range schedule {
+		if next := expr.next(last); !next.IsZero() && !next.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// retain drops functions which were not listed by the gateway in the last
+// reconcile pass
+func (p *prewarmStore) retain(names map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.checked.retain(names)
+}
+
+// parsePrewarm reads a pre-warm schedule, cron expressions separated by ;
+func parsePrewarm(val string) ([]cronExpr, error) {
+	schedule := []cronExpr{}
+	for _, item := range strings.Split(val, ";") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		expr, err := parseCron(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s", item, err)
+		}
+		schedule = append(schedule, expr)
+	}
+	return schedule, nil
+}
+
+// parsePrewarmReplicas reads the replicas a function is pre-warmed to
+func parsePrewarmReplicas(val string) (uint64, error) {
+	replicas, err := strconv.ParseUint(val, 10, 64)
+	if err != nil || replicas == 0 {
+		return 0, fmt.Errorf("must be a number > 0, got: %q", val)
+	}
+	return replicas, nil
+}
+
+// prewarm scales up the functions whose pre-warm schedule matched since the
+// last pass and which run fewer replicas than they are pre-warmed to. They
+// are then left alone for their inactivity duration, so that the traffic
+// they were warmed for has the time to register.
+func prewarm(ctx context.Context, gateway *Gateway, functions []Function, policies map[string]Policy, now time.Time, summary *cycleSummary) {
+	config := gateway.Config
+
+	for _, fn := range functions {
+		policy := policies[fn.Name]
+		if len(policy.Prewarm) == 0 {
+			continue
+		}
+
+		schedule, err := parsePrewarm(policy.Prewarm)
+		if err != nil {
+			log.Printf("[%s] Invalid pre-warm schedule of %s: %s\n", gateway.Name, fn.Name, err)
+			continue
+		}
+		replicas := policy.PrewarmReplicas
+		if replicas == 0 {
+			replicas = 1
+		}
+		if !gateway.prewarms.due(fn.Name, schedule, config.ReconcileInterval, now) || fn.Replicas >= replicas {
+			continue
+		}
+
+		if observe {
+			fmt.Printf("observe: %s would be pre-warmed to %d\n", fn.Name, replicas)
+			continue
+		}
+		if summary.Paused {
+			fmt.Printf("paused: %s would be pre-warmed to %d\n", fn.Name, replicas)
+			continue
+		}
+
+		log.Printf("[%s] Pre-warming %s to %d replica(s)\n", gateway.Name, fn.Name, replicas)
+		if err := sendScaleEventWithRetry(ctx, gateway, fn.Name, replicas); err != nil {
+			summary.fail("prewarm", err)
+			continue
+		}
+		summary.Prewarmed++
+
+		gateway.drains.cancel(fn.Name)
+		gateway.wakes.scaled(fn.Name, replicas)
+		gateway.wakes.hold(fn.Name, now.Add(policy.InactivityDuration))
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	providerTypes "github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_prewarmStore_due(t *testing.T) {
+	prewarms := newPrewarmStore(0, 0)
+	schedule, err := parsePrewarm("45 8 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Monday
+	morning := time.Date(2019, time.April, 1, 8, 44, 0, 0, time.UTC)
+
+	if prewarms.due("figlet", schedule, time.Minute, morning.Add(-time.Minute)) {
+		t.Errorf("A schedule not matched yet should not be due")
+	}
+	if !prewarms.due("figlet", schedule, time.Minute, morning.Add(time.Minute*2)) {
+		t.Errorf("A schedule matched since the last check should be due")
+	}
+	if prewarms.due("figlet", schedule, time.Minute, morning.Add(time.Minute*3)) {
+		t.Errorf("A schedule should only be due once")
+	}
+
+	// Not checked yet, so matched within the interval before now
+	if !prewarms.due("nodeinfo", schedule, time.Minute*5, morning.Add(time.Minute*2)) {
+		t.Errorf("A schedule matched within the interval should be due")
+	}
+}
+
+func Test_prewarm(t *testing.T) {
+	scaled := map[string]uint64{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := providerTypes.ScaleServiceRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+		scaled[req.ServiceName] = req.Replicas
+	}))
+	defer server.Close()
+
+	gateway := newGateway(types.Config{Name: "test", GatewayURL: server.URL + "/", ReconcileInterval: time.Minute})
+	functions := []Function{
+		{Function: requests.Function{Name: "reports", Replicas: 0}},
+		{Function: requests.Function{Name: "warm", Replicas: 2}},
+		{Function: requests.Function{Name: "figlet", Replicas: 0}},
+	}
+	policies := map[string]Policy{
+		"reports": {InactivityDuration: time.Minute * 30, Prewarm: "* * * * *", PrewarmReplicas: 2},
+		"warm":    {InactivityDuration: time.Minute * 30, Prewarm: "* * * * *"},
+		"figlet":  {InactivityDuration: time.Minute * 30},
+	}
+	now := time.Now()
+	summary := newCycleSummary("test", now)
+
+	prewarm(context.Background(), gateway, functions, policies, now, summary)
+
+	if len(scaled) != 1 || scaled["reports"] != 2 || summary.Prewarmed != 1 {
+		t.Errorf("Only reports should be pre-warmed, got: %v", scaled)
+	}
+	if until, cooling := gateway.wakes.coolingDown("reports", now.Add(time.Minute*29)); !cooling || !until.Equal(now.Add(time.Minute*30)) {
+		t.Errorf("A pre-warmed function should be left alone for its inactivity duration, got: %s %v", until, cooling)
+	}
+	if gateway.wakes.observe("reports", 2, now, time.Minute) {
+		t.Errorf("A function pre-warmed by the idler should not be woken")
+	}
+}
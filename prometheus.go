@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/types"
+)
+
+// prometheusTLSConfig verifies Prometheus with prometheus_ca_file, nil keeps
+// the default verification
+func prometheusTLSConfig(config types.Config) *tls.Config {
+	if config.PrometheusScheme != "https" || (config.PrometheusRootCAs == nil && !config.PrometheusInsecureSkipVerify) {
+		return nil
+	}
+
+	return &tls.Config{
+		RootCAs:            config.PrometheusRootCAs,
+		InsecureSkipVerify: config.PrometheusInsecureSkipVerify,
+	}
+}
+
+// prometheusQueryURL is the instant query endpoint of Prometheus for promQL,
+// under prometheus_path_prefix when set
+func prometheusQueryURL(promQL string, config types.Config) string {
+	return prometheusAPIURL("query", url.Values{"query": []string{promQL}}, config)
+}
+
+// prometheusAPIURL is an endpoint of the Prometheus HTTP API with the query
+// parameters given
+func prometheusAPIURL(endpoint string, values url.Values, config types.Config) string {
+	scheme := config.PrometheusScheme
+	if len(scheme) == 0 {
+		scheme = "http"
+	}
+	prefix := config.PrometheusPathPrefix
+	if len(prefix) > 0 && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	host := net.JoinHostPort(config.PrometheusHost, strconv.Itoa(config.PrometheusPort))
+	return fmt.Sprintf("%s://%s%s/api/v1/%s?%s", scheme, host, prefix, endpoint, values.Encode())
+}
+
+// fetchPrometheus runs an instant query, bounded by prometheus_timeout or the
+// time remaining in the reconcile pass
+func fetchPrometheus(ctx context.Context, client *http.Client, promQL string, config types.Config) (*metrics.VectorQueryResponse, error) {
+	values := metrics.VectorQueryResponse{}
+	if err := getPrometheus(ctx, client, prometheusQueryURL(promQL, config), config, &values); err != nil {
+		return nil, err
+	}
+	return &values, nil
+}
+
+// prometheusRangeResponse is the body of a range query
+type prometheusRangeResponse struct {
+	Data struct {
+		Result []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][]interface{}   `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// fetchPrometheusRange runs a range query evaluating promQL every step from
+// start to end, bounded as fetchPrometheus
+func fetchPrometheusRange(ctx context.Context, client *http.Client, promQL string, start time.Time, end time.Time, step time.Duration, config types.Config) (*prometheusRangeResponse, error) {
+	values := url.Values{}
+	values.Set("query", promQL)
+	values.Set("start", strconv.FormatInt(start.Unix(), 10))
+	values.Set("end", strconv.FormatInt(end.Unix(), 10))
+	values.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+
+	body := prometheusRangeResponse{}
+	if err := getPrometheus(ctx, client, prometheusAPIURL("query_range", values, config), config, &body); err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
+
+// getPrometheus decodes the JSON body of a query
+func getPrometheus(ctx context.Context, client *http.Client, queryURL string, config types.Config, body interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, queryURL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := boundedClient(ctx, client, config.PrometheusTimeout).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	bytesOut, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status code from Prometheus want: %d, got: %d, body: %s", http.StatusOK, res.StatusCode, string(bytesOut))
+	}
+
+	if err := json.Unmarshal(bytesOut, body); err != nil {
+		return fmt.Errorf("Error unmarshaling result: %s, '%s'", err, string(bytesOut))
+	}
+	return nil
+}
+
+// boundedClient copies the client with the timeout, or the time remaining
+// before the context's deadline when sooner
+func boundedClient(ctx context.Context, client *http.Client, timeout time.Duration) *http.Client {
+	bounded := *client
+	bounded.Timeout = timeout
+	if deadline, ok := ctx.Deadline(); ok && (bounded.Timeout <= 0 || time.Until(deadline) < bounded.Timeout) {
+		bounded.Timeout = time.Until(deadline)
+	}
+	return &bounded
+}
+
+// vectorValue is the value of a sample of an instant query
+func vectorValue(value []interface{}) (float64, bool, error) {
+	if len(value) < 2 {
+		return 0, false, nil
+	}
+
+	metricValue, ok := value[1].(string)
+	if !ok {
+		return 0, false, nil
+	}
+
+	f, err := strconv.ParseFloat(metricValue, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("unable to convert value for metric: %s", err)
+	}
+	return f, true, nil
+}
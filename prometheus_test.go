@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_fetchPrometheus_tls(t *testing.T) {
+	prometheus := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"result": [{"metric": {}, "value": [1546300800, "2"]}]}}`))
+	}))
+	defer prometheus.Close()
+
+	parsed, _ := url.Parse(prometheus.URL)
+	host, portVal, _ := net.SplitHostPort(parsed.Host)
+	port, _ := strconv.Atoi(portVal)
+
+	config := types.Config{PrometheusScheme: "https", PrometheusHost: host, PrometheusPort: port}
+	if _, _, err := queryValue(context.Background(), newHeaderClient("", nil, prometheusTLSConfig(config)), "up", config); err == nil {
+		t.Errorf("A certificate from an unknown authority should be rejected")
+	}
+
+	config.PrometheusRootCAs = x509.NewCertPool()
+	config.PrometheusRootCAs.AddCert(prometheus.Certificate())
+	value, found, err := queryValue(context.Background(), newHeaderClient("", nil, prometheusTLSConfig(config)), "up", config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !found || value != 2 {
+		t.Errorf("Value wanted: 2 got: %f found: %t", value, found)
+	}
+}
+
+func Test_prometheusTLSConfig(t *testing.T) {
+	if tlsConfig := prometheusTLSConfig(types.Config{PrometheusScheme: "http"}); tlsConfig != nil {
+		t.Errorf("Plain HTTP should keep the default transport")
+	}
+	if tlsConfig := prometheusTLSConfig(types.Config{PrometheusScheme: "https", PrometheusInsecureSkipVerify: true}); tlsConfig == nil || !tlsConfig.InsecureSkipVerify {
+		t.Errorf("Verification should be skipped when asked to")
+	}
+}
+
+func Test_prometheusQueryURL(t *testing.T) {
+	config := types.Config{PrometheusScheme: "https", PrometheusHost: "cortex", PrometheusPort: 443, PrometheusPathPrefix: "prometheus"}
+
+	if want, got := "https://cortex:443/prometheus/api/v1/query?query=up", prometheusQueryURL("up", config); got != want {
+		t.Errorf("URL wanted: %s got: %s", want, got)
+	}
+
+	config.PrometheusPathPrefix = ""
+	if want, got := "https://cortex:443/api/v1/query?query=up", prometheusQueryURL("up", config); got != want {
+		t.Errorf("URL wanted: %s got: %s", want, got)
+	}
+}
+
+func Test_prometheusProvider_victoriaMetrics(t *testing.T) {
+	vmselect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/select/0/prometheus/api/v1/query" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"status": "success", "isPartial": false, "data": {"resultType": "vector", "result": [
+			{"metric": {"function_name": "figlet", "code": "200"}, "value": [1546300800, "0.5"]},
+			{"metric": {"function_name": "nodeinfo", "code": "200"}, "value": [1546300800, "0"]}]},
+			"stats": {"seriesFetched": "2", "executionTimeMsec": 1}}`))
+	}))
+	defer vmselect.Close()
+
+	parsed, _ := url.Parse(vmselect.URL)
+	host, portVal, _ := net.SplitHostPort(parsed.Host)
+	port, _ := strconv.Atoi(portVal)
+
+	config := types.Config{PrometheusScheme: "http", PrometheusHost: host, PrometheusPort: port, PrometheusPathPrefix: "/select/0/prometheus"}
+	provider := newPrometheusProvider(config, newHeaderClient("", nil, nil))
+
+	rates, ok, err := provider.(BatchMetricsProvider).ActivityBatch(context.Background(), []string{"figlet", "nodeinfo"}, time.Minute*5)
+	if err != nil || !ok {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rates) != 2 || rates["figlet"] != 0.5 || rates["nodeinfo"] != 0 {
+		t.Errorf("Rates wanted figlet: 0.5 nodeinfo: 0 got: %v", rates)
+	}
+}
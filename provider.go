@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/types"
+)
+
+// MetricsProvider is the monitoring backend reporting the activity which
+// idle decisions are based on
+type MetricsProvider interface {
+	// Activity returns a function's invocation rate over the window, found
+	// is false when the backend holds no data for it
+	Activity(ctx context.Context, function string, window time.Duration) (rate float64, found bool, err error)
+}
+
+// BatchMetricsProvider is a MetricsProvider able to report the activity of
+// every function over a window at once
+type BatchMetricsProvider interface {
+	MetricsProvider
+
+	// ActivityBatch returns the invocation rate over the window of the
+	// functions the backend holds data for, by name, ok is false when the
+	// window is not batched and its functions must be queried one by one
+	ActivityBatch(ctx context.Context, functions []string, window time.Duration) (rates map[string]float64, ok bool, err error)
+}
+
+// metricsProviders creates the MetricsProvider named by metrics_provider for
+// a gateway, client carrying the headers configured for the backend
+var metricsProviders = map[string]func(config types.Config, client *http.Client) MetricsProvider{
+	types.MetricsProviderPrometheus: newPrometheusProvider,
+	types.MetricsProviderInfluxDB:   newInfluxProvider,
+	types.MetricsProviderCloudWatch: newCloudWatchProvider,
+}
+
+func newMetricsProvider(config types.Config, client *http.Client) MetricsProvider {
+	create, exists := metricsProviders[config.MetricsProvider]
+	if !exists {
+		create = newPrometheusProvider
+	}
+	provider := create(config, client)
+	if config.IncludeAsync && readsPrometheus(config) {
+		provider = newAsyncProvider(provider, config, client)
+	}
+	if config.MetricsRetries > 0 {
+		provider = newRetryingProvider(provider, config.MetricsRetries, config.MetricsRetryBackoff)
+	}
+	if config.QueryCacheTTL > 0 {
+		provider = newCachedProvider(provider, config.QueryCacheMaxEntries, config.QueryCacheTTL)
+	}
+	return provider
+}
+
+// readsPrometheus is true when activity is read from Prometheus, whose own
+// uptime and history then bound the windows evaluated
+func readsPrometheus(config types.Config) bool {
+	return len(config.MetricsProvider) == 0 || config.MetricsProvider == types.MetricsProviderPrometheus
+}
+
+// prometheusProvider queries the gateway's invocation counters in Prometheus
+type prometheusProvider struct {
+	client *http.Client
+	config types.Config
+}
+
+func newPrometheusProvider(config types.Config, client *http.Client) MetricsProvider {
+	return &prometheusProvider{client: client, config: config}
+}
+
+func (p *prometheusProvider) Activity(ctx context.Context, function string, window time.Duration) (float64, bool, error) {
+	// Series returned by a custom query may be labelled differently, so
+	// all of them are summed
+	if p.config.PrometheusQueryTemplate != nil {
+		promQL, err := templatedActivityQuery(function, window, p.config)
+		if err != nil {
+			return 0, false, err
+		}
+		return queryValue(ctx, p.client, promQL, p.config)
+	}
+
+	if p.config.RangeQueries {
+		rates, err := queryRangeRates(ctx, p.client, function, window, p.config)
+		if err != nil {
+			return 0, false, err
+		}
+		rate, found := rates[function]
+		return rate, found, nil
+	}
+
+	if p.config.QueryChunk > 0 && window > p.config.QueryChunk && !p.config.UseRecordingRules {
+		return queryChunkedRate(ctx, p.client, function, window, p.config)
+	}
+
+	rates, err := p.rates(ctx, activityQuery(function, window, p.config), function)
+	if err != nil {
+		return 0, false, err
+	}
+	rate, found := rates[function]
+	return rate, found, nil
+}
+
+// ActivityBatch does not batch a custom query nor windows split into chunks,
+// those stop at the first chunk with activity which differs between functions
+func (p *prometheusProvider) ActivityBatch(ctx context.Context, functions []string, window time.Duration) (map[string]float64, bool, error) {
+	if p.config.PrometheusQueryTemplate != nil {
+		return nil, false, nil
+	}
+	if p.config.RangeQueries {
+		rates, err := queryRangeRates(ctx, p.client, "", window, p.config)
+		return rates, true, err
+	}
+	if p.config.QueryChunk > 0 && window > p.config.QueryChunk && !p.config.UseRecordingRules {
+		return nil, false, nil
+	}
+
+	rates, err := p.rates(ctx, batchActivityQuery(window, p.config), "")
+	return rates, true, err
+}
+
+// rates runs an instant query and sums the series it returned by function,
+// only keeping the function given unless it is empty
+func (p *prometheusProvider) rates(ctx context.Context, promQL string, function string) (map[string]float64, error) {
+	res, err := fetchPrometheus(ctx, p.client, promQL, p.config)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := make(map[string]float64)
+	for _, v := range res.Data.Result {
+		if writeDebug {
+			fmt.Println(v)
+		}
+
+		name := v.Metric.FunctionName
+		if (len(function) > 0 && name != function) || len(name) == 0 {
+			continue
+		}
+
+		f, ok, err := vectorValue(v.Value)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if ok {
+			rates[name] += f
+		}
+	}
+	return rates, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+// fakeProvider reports fixed rates, functions missing from them have no data
+type fakeProvider struct {
+	rates   map[string]float64
+	windows map[string]time.Duration
+}
+
+func (p *fakeProvider) Activity(ctx context.Context, function string, window time.Duration) (float64, bool, error) {
+	p.windows[function] = window
+	if function == "broken" {
+		return 0, false, fmt.Errorf("unavailable")
+	}
+	rate, found := p.rates[function]
+	return rate, found, nil
+}
+
+func Test_buildMetricsMap_provider(t *testing.T) {
+	provider := &fakeProvider{
+		rates:   map[string]float64{"figlet": 0.5, "nodeinfo": 0},
+		windows: map[string]time.Duration{},
+	}
+	functions := []Function{}
+	for _, name := range []string{"figlet", "nodeinfo", "new", "broken", "never"} {
+		functions = append(functions, Function{Function: requests.Function{Name: name}})
+	}
+	policies := map[string]Policy{
+		"figlet":   {InactivityDuration: time.Minute * 10},
+		"nodeinfo": {InactivityDuration: time.Minute * 5},
+		"new":      {InactivityDuration: time.Minute * 5},
+		"broken":   {InactivityDuration: time.Minute * 5},
+		"never":    {Never: true},
+	}
+	summary := newCycleSummary("prod", time.Now())
+
+	metrics, unknown := buildMetricsMap(context.Background(), provider, functions, policies, 0, types.Config{}, summary)
+
+	if len(unknown) != 1 || !unknown["broken"] {
+		t.Errorf("Functions whose query failed should be unknown, got: %v", unknown)
+	}
+	if len(metrics) != 2 || metrics["figlet"] != 0.5 || metrics["nodeinfo"] != 0 {
+		t.Errorf("Only functions with data should be mapped, got: %v", metrics)
+	}
+	if provider.windows["figlet"] != time.Minute*10 {
+		t.Errorf("Window wanted: %s got: %s", time.Minute*10, provider.windows["figlet"])
+	}
+	if _, queried := provider.windows["never"]; queried {
+		t.Errorf("Functions never scaled should not be queried")
+	}
+	if summary.failures() != 1 {
+		t.Errorf("Errors from the provider should be counted, got: %v", summary.Failed)
+	}
+}
+
+func Test_prometheusProvider_Activity(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"result": [
+			{"metric": {"code": "200", "function_name": "figlet"}, "value": [1546300800, "0.25"]},
+			{"metric": {"code": "500", "function_name": "figlet"}, "value": [1546300800, "0.5"]},
+			{"metric": {"code": "200", "function_name": "figlet-v2"}, "value": [1546300800, "1"]}]}}`))
+	}))
+	defer prometheus.Close()
+
+	parsed, _ := url.Parse(prometheus.URL)
+	host, portVal, _ := net.SplitHostPort(parsed.Host)
+	port, _ := strconv.Atoi(portVal)
+
+	config := types.Config{PrometheusHost: host, PrometheusPort: port, MetricsProvider: types.MetricsProviderPrometheus}
+	provider := newMetricsProvider(config, &http.Client{})
+
+	rate, found, err := provider.Activity(context.Background(), "figlet", time.Minute*5)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !found || rate != 0.75 {
+		t.Errorf("Rate of every status code of the function wanted: 0.75 got: %f found: %t", rate, found)
+	}
+}
+
+// fakeBatchProvider batches every window, counting the queries made
+type fakeBatchProvider struct {
+	fakeProvider
+	batches int
+}
+
+func (p *fakeBatchProvider) ActivityBatch(ctx context.Context, functions []string, window time.Duration) (map[string]float64, bool, error) {
+	p.batches++
+	return p.rates, true, nil
+}
+
+func Test_buildMetricsMap_batch(t *testing.T) {
+	provider := &fakeBatchProvider{fakeProvider: fakeProvider{
+		rates:   map[string]float64{"figlet": 0.5, "nodeinfo": 0, "env": 0},
+		windows: map[string]time.Duration{},
+	}}
+	functions := []Function{}
+	policies := map[string]Policy{}
+	for _, name := range []string{"figlet", "nodeinfo", "new", "env"} {
+		functions = append(functions, Function{Function: requests.Function{Name: name}})
+		policies[name] = Policy{InactivityDuration: time.Minute * 5}
+	}
+	policies["env"] = Policy{InactivityDuration: time.Hour}
+
+	metrics, _ := buildMetricsMap(context.Background(), provider, functions, policies, 0, types.Config{BatchQueries: true}, newCycleSummary("prod", time.Now()))
+
+	if len(metrics) != 3 || metrics["figlet"] != 0.5 {
+		t.Errorf("Only functions with data should be mapped, got: %v", metrics)
+	}
+	if provider.batches != 1 {
+		t.Errorf("Functions sharing a window should be queried at once, batches: %d", provider.batches)
+	}
+	if _, queried := provider.windows["env"]; !queried || len(provider.windows) != 1 {
+		t.Errorf("Only the function alone in its window should be queried by itself, got: %v", provider.windows)
+	}
+}
+
+func Test_prometheusProvider_queryTemplate(t *testing.T) {
+	var received string
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.URL.Query().Get("query")
+		w.Write([]byte(`{"data": {"result": [{"metric": {"service": "figlet"}, "value": [1546300800, "0.25"]}]}}`))
+	}))
+	defer prometheus.Close()
+
+	parsed, _ := url.Parse(prometheus.URL)
+	host, portVal, _ := net.SplitHostPort(parsed.Host)
+	port, _ := strconv.Atoi(portVal)
+
+	config := types.Config{
+		PrometheusHost:          host,
+		PrometheusPort:          port,
+		QueryOffset:             time.Minute,
+		BatchQueries:            true,
+		PrometheusQueryTemplate: template.Must(template.New("").Parse(`sum(rate(http_requests_total{service="{{.FunctionName}}"}{{.Range}}))`)),
+	}
+	provider := newMetricsProvider(config, &http.Client{})
+
+	rate, found, err := provider.Activity(context.Background(), "figlet", time.Minute*5)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if want := `sum(rate(http_requests_total{service="figlet"}[5m] offset 1m))`; received != want {
+		t.Errorf("Query wanted: %s got: %s", want, received)
+	}
+	if !found || rate != 0.25 {
+		t.Errorf("Series relabelled by the query should be summed, got: %f found: %t", rate, found)
+	}
+
+	if _, ok, _ := provider.(BatchMetricsProvider).ActivityBatch(context.Background(), []string{"figlet"}, time.Minute*5); ok {
+		t.Errorf("A custom query should not be batched")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// quotaVictims picks, in each namespace running more replicas than the
+// max_replicas of its namespace policy, the opted-in functions to idle
+// whatever their activity, least active first, until the replicas their
+// scale downs free bring it back under quota. Functions whose activity is
+// unknown or which are left alone for another reason are not picked.
+func quotaVictims(gateway *Gateway, functions []Function, policies map[string]Policy, metrics map[string]float64, unknown map[string]bool, autoscaled map[string]string, now time.Time) map[string]bool {
+	victims := make(map[string]bool)
+
+	running := make(map[string]uint64)
+	for _, fn := range functions {
+		running[fn.Namespace] += fn.Replicas
+	}
+
+	for namespace, replicas := range running {
+		quota := gateway.Config.NamespacePolicies[namespace].MaxReplicas
+		if quota == 0 || replicas <= quota {
+			continue
+		}
+
+		candidates := []Function{}
+		for _, fn := range functions {
+			policy := policies[fn.Name]
+			_, found := metrics[fn.Name]
+			_, external := autoscaled[fn.Name]
+			if fn.Namespace != namespace || !policy.Enabled || policy.Never || policy.snoozed(now) ||
+				!found || unknown[fn.Name] || external || fn.Replicas <= policy.idleTarget() {
+				continue
+			}
+			candidates = append(candidates, fn)
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if metrics[candidates[i].Name] != metrics[candidates[j].Name] {
+				return metrics[candidates[i].Name] < metrics[candidates[j].Name]
+			}
+			return candidates[i].Name < candidates[j].Name
+		})
+
+		picked := 0
+		for _, fn := range candidates {
+			if replicas <= quota {
+				break
+			}
+			victims[fn.Name] = true
+			replicas -= fn.Replicas - policies[fn.Name].idleTarget()
+			picked++
+		}
+		log.Printf("[%s] Namespace %q runs %d replica(s) over its max_replicas of %d, idling %d function(s) to bring it back\n", gateway.Name, namespace, running[namespace]-quota, quota, picked)
+	}
+	return victims
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_quotaVictims(t *testing.T) {
+	gateway := newGateway(types.Config{
+		Name:              "test",
+		NamespacePolicies: map[string]types.NamespacePolicy{"staging": {MaxReplicas: 6}, "dev": {MaxReplicas: 10}},
+	})
+	now := time.Now()
+
+	functions := []Function{
+		{Function: requests.Function{Name: "busy", Replicas: 3}, Namespace: "staging"},
+		{Function: requests.Function{Name: "quiet", Replicas: 2}, Namespace: "staging"},
+		{Function: requests.Function{Name: "idle", Replicas: 2}, Namespace: "staging"},
+		{Function: requests.Function{Name: "guarded", Replicas: 2}, Namespace: "staging"},
+		{Function: requests.Function{Name: "unknown", Replicas: 1}, Namespace: "staging"},
+		{Function: requests.Function{Name: "dev", Replicas: 4}, Namespace: "dev"},
+		{Function: requests.Function{Name: "prod", Replicas: 50}, Namespace: "prod"},
+	}
+	policies := map[string]Policy{
+		"busy":    {Enabled: true},
+		"quiet":   {Enabled: true},
+		"idle":    {Enabled: true},
+		"guarded": {Enabled: true, Never: true},
+		"unknown": {Enabled: true},
+		"dev":     {Enabled: true},
+		"prod":    {Enabled: true},
+	}
+	metrics := map[string]float64{"busy": 5, "quiet": 0.5, "idle": 0, "guarded": 0, "dev": 0, "prod": 0}
+	unknown := map[string]bool{"unknown": true}
+
+	// staging runs 10 replicas, idling idle then quiet frees 4
+	victims := quotaVictims(gateway, functions, policies, metrics, unknown, nil, now)
+	if len(victims) != 2 || !victims["idle"] || !victims["quiet"] {
+		t.Errorf("The least active functions of staging should be picked, got: %v", victims)
+	}
+
+	// Keeping a replica frees one replica less each
+	policies["idle"] = Policy{Enabled: true, ScaleTarget: 1}
+	policies["quiet"] = Policy{Enabled: true, SLAGuarded: true}
+	victims = quotaVictims(gateway, functions, policies, metrics, unknown, nil, now)
+	if len(victims) != 3 || !victims["busy"] {
+		t.Errorf("More functions should be picked when their targets keep replicas, got: %v", victims)
+	}
+
+	policies["busy"] = Policy{Enabled: true, SnoozeUntil: now.Add(time.Hour)}
+	victims = quotaVictims(gateway, functions, policies, metrics, unknown, map[string]string{"quiet": "HorizontalPodAutoscaler"}, now)
+	if len(victims) != 1 || !victims["idle"] {
+		t.Errorf("Snoozed and autoscaled functions should not be picked, got: %v", victims)
+	}
+}
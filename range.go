@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/types"
+)
+
+// maxRangePoints is the most samples Prometheus returns for a series of a
+// range query
+const maxRangePoints = 11000
+
+// rangeStep is range_query_step, widened to whole seconds when the window
+// would otherwise hold more steps than Prometheus returns
+func rangeStep(window time.Duration, config types.Config) time.Duration {
+	step := config.RangeQueryStep
+	if window/step > maxRangePoints {
+		step = (window/maxRangePoints + time.Second - 1).Truncate(time.Second)
+	}
+	return step
+}
+
+// rangeActivityQuery is the invocations of a function, or of every function
+// when name is empty, in the step ending at each evaluation, by function
+func rangeActivityQuery(name string, step time.Duration) string {
+	selector := `code=~".*"`
+	if len(name) > 0 {
+		selector = `function_name="` + name + `", ` + selector
+	}
+	return `sum(increase(gateway_function_invocation_total{` + selector + `}[` + promDuration(step) + `])) by (function_name)`
+}
+
+// queryRangeRates evaluates the window step by step, so that a burst early
+// in it is not averaged away, and returns the rate of the busiest step by
+// function, only keeping the function given unless it is empty. A function
+// is idle only when every step is zero.
+func queryRangeRates(ctx context.Context, client *http.Client, name string, window time.Duration, config types.Config) (map[string]float64, error) {
+	step := rangeStep(window, config)
+	end := time.Now().Add(-config.QueryOffset)
+	start := end.Add(-window).Add(step)
+	if start.After(end) {
+		start = end
+	}
+
+	res, err := fetchPrometheusRange(ctx, client, rangeActivityQuery(name, step), start, end, step, config)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := make(map[string]float64)
+	for _, series := range res.Data.Result {
+		function := series.Metric["function_name"]
+		if len(function) == 0 || (len(name) > 0 && function != name) {
+			continue
+		}
+
+		for _, sample := range series.Values {
+			increase, ok, err := vectorValue(sample)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+
+			rate := increase / step.Seconds()
+			if peak, exists := rates[function]; !exists || rate > peak {
+				rates[function] = rate
+			}
+		}
+	}
+	return rates, nil
+}
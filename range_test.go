@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_rangeStep(t *testing.T) {
+	config := types.Config{RangeQueryStep: time.Minute}
+
+	if step := rangeStep(time.Hour, config); step != time.Minute {
+		t.Errorf("Step wanted: %s got: %s", time.Minute, step)
+	}
+	// 30 days of minutes exceed the points of a series
+	if step := rangeStep(time.Hour*24*30, config); step != time.Second*236 {
+		t.Errorf("Step wanted: %s got: %s", time.Second*236, step)
+	}
+}
+
+func Test_rangeActivityQuery(t *testing.T) {
+	want := `sum(increase(gateway_function_invocation_total{function_name="figlet", code=~".*"}[1m])) by (function_name)`
+	if got := rangeActivityQuery("figlet", time.Minute); got != want {
+		t.Errorf("Query wanted: %s got: %s", want, got)
+	}
+}
+
+func Test_prometheusProvider_rangeQueries(t *testing.T) {
+	var received url.Values
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query_range" {
+			http.NotFound(w, r)
+			return
+		}
+		received = r.URL.Query()
+		w.Write([]byte(`{"status": "success", "data": {"resultType": "matrix", "result": [
+			{"metric": {"function_name": "figlet"}, "values": [[1546300800, "120"], [1546300860, "0"], [1546300920, "0"]]},
+			{"metric": {"function_name": "nodeinfo"}, "values": [[1546300800, "0"], [1546300860, "0"], [1546300920, "0"]]}]}}`))
+	}))
+	defer prometheus.Close()
+
+	parsed, _ := url.Parse(prometheus.URL)
+	host, portVal, _ := net.SplitHostPort(parsed.Host)
+	port, _ := strconv.Atoi(portVal)
+
+	config := types.Config{PrometheusHost: host, PrometheusPort: port, RangeQueries: true, RangeQueryStep: time.Minute}
+	provider := newPrometheusProvider(config, newHeaderClient("", nil, nil))
+
+	rates, ok, err := provider.(BatchMetricsProvider).ActivityBatch(context.Background(), []string{"figlet", "nodeinfo"}, time.Minute*3)
+	if err != nil || !ok {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The burst at the start of the window is the busiest step
+	if len(rates) != 2 || rates["figlet"] != 2 || rates["nodeinfo"] != 0 {
+		t.Errorf("Rates wanted figlet: 2 nodeinfo: 0 got: %v", rates)
+	}
+
+	start, _ := strconv.ParseInt(received.Get("start"), 10, 64)
+	end, _ := strconv.ParseInt(received.Get("end"), 10, 64)
+	if received.Get("step") != "60" || end-start != 120 {
+		t.Errorf("Unexpected range: %v", received)
+	}
+
+	rate, found, err := provider.Activity(context.Background(), "nodeinfo", time.Minute*3)
+	if err != nil || !found || rate != 0 {
+		t.Errorf("A function without invocations in any step should be idle, got: %f %t %v", rate, found, err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// recheckActivity reads the activity of a function over recheck_window right
+// before it is scaled, as traffic may have arrived since the pass read its
+// metrics, and reports whether it is still idle. query_cache_ttl is bypassed
+// for the reading to be fresh, and a function is not scaled when it fails.
+func recheckActivity(ctx context.Context, gateway *Gateway, fn Function, threshold float64, summary *cycleSummary) bool {
+	window := gateway.Config.RecheckWindow
+	if window <= 0 {
+		return true
+	}
+
+	provider := gateway.metrics
+	if cached, ok := provider.(*cachedProvider); ok {
+		provider = cached.next
+	}
+
+	rate, _, err := provider.Activity(ctx, fn.Name, window)
+	if err != nil {
+		log.Printf("[%s] Not scaling %s: unable to read its activity over the last %s: %s\n", gateway.Name, fn.Name, window, err)
+		summary.fail("metrics", err)
+		return false
+	}
+	if rate > threshold {
+		log.Printf("[%s] Not scaling %s: invoked at %.4f/s over the last %s\n", gateway.Name, fn.Name, rate, window)
+		summary.skip(skipRecheck)
+		return false
+	}
+	return true
+}
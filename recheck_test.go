@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_scaleDown_recheck(t *testing.T) {
+	rate := "0.5"
+	var received string
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.URL.Query().Get("query")
+		w.Write([]byte(`{"data": {"result": [{"metric": {"function_name": "figlet", "code": "200"}, "value": [1546300800, "` + rate + `"]}]}}`))
+	}))
+	defer prometheus.Close()
+
+	scales := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scales++
+	}))
+	defer server.Close()
+
+	parsed, _ := url.Parse(prometheus.URL)
+	host, portVal, _ := net.SplitHostPort(parsed.Host)
+	port, _ := strconv.Atoi(portVal)
+
+	gateway := newGateway(types.Config{
+		Name:                 "test",
+		GatewayURL:           server.URL + "/",
+		PrometheusHost:       host,
+		PrometheusPort:       port,
+		RecheckWindow:        time.Second * 30,
+		QueryCacheTTL:        time.Minute,
+		QueryCacheMaxEntries: 10,
+	})
+	fn := Function{Function: requests.Function{Name: "figlet"}}
+	summary := newCycleSummary("test", time.Now())
+
+	scaleDown(context.Background(), gateway, fn, 0, 0, 1, 0, summary)
+	if scales != 0 || summary.Skipped[skipRecheck] != 1 {
+		t.Errorf("A function invoked since the pass read its metrics should not be scaled, scales: %d skipped: %v", scales, summary.Skipped)
+	}
+	if want := activityQuery("figlet", time.Second*30, gateway.Config); received != want {
+		t.Errorf("Query wanted: %s got: %s", want, received)
+	}
+
+	scaleDown(context.Background(), gateway, fn, 0, 1, 1, 0, summary)
+	if scales != 1 {
+		t.Errorf("A function invoked below its idle threshold should be scaled, scales: %d", scales)
+	}
+
+	// The rate read before is cached, the recheck has to read it again
+	rate = "0"
+	received = ""
+	scaleDown(context.Background(), gateway, fn, 0, 0, 1, 0, summary)
+	if scales != 2 || len(received) == 0 {
+		t.Errorf("A function idle since should be scaled after a fresh query, scales: %d", scales)
+	}
+
+	prometheus.Close()
+	scaleDown(context.Background(), gateway, fn, 0, 0, 1, 0, summary)
+	if scales != 2 || summary.failures() != 1 {
+		t.Errorf("A function whose activity cannot be read should not be scaled, scales: %d", scales)
+	}
+
+	gateway.Config.RecheckWindow = 0
+	scaleDown(context.Background(), gateway, fn, 0, 0, 1, 0, summary)
+	if scales != 3 {
+		t.Errorf("recheck_window of 0 should not read the activity again, scales: %d", scales)
+	}
+}
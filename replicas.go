@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replicaStore records the replicas each function ran before the idler
+// first scaled it down, until it is woken, so that they can be restored
+// rather than the gateway's minimum
+type replicaStore struct {
+	mu      sync.Mutex
+	records *lruCache
+}
+
+// replicaRecord is the replicas of a function before it was idled, as
+// listed by /api/replicas
+type replicaRecord struct {
+	Gateway   string    `json:"gateway"`
+	Function  string    `json:"function"`
+	Namespace string    `json:"namespace,omitempty"`
+	Replicas  uint64    `json:"replicas"`
+	IdledAt   time.Time `json:"idled_at"`
+}
+
+func newReplicaStore(maxEntries int, ttl time.Duration) *replicaStore {
+	return &replicaStore{
+		records: newLRUCache(maxEntries, ttl),
+	}
+}
+
+// record keeps the replicas of a function being scaled down unless they
+// were recorded already, a scale down step does not replace them, and
+// reports whether they were
+func (s *replicaStore) record(record replicaRecord) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.records.get(record.Function); exists {
+		return false
+	}
+	s.records.set(record.Function, record)
+	return true
+}
+
+// woken returns and forgets the record of a function which was woken
+func (s *replicaStore) woken(name string) (replicaRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, exists := s.records.get(name)
+	if !exists {
+		return replicaRecord{}, false
+	}
+	s.records.remove(name)
+	return value.(replicaRecord), true
+}
+
+// retain drops functions which were not listed by the gateway in the last
+// reconcile pass
+func (s *replicaStore) retain(names map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records.retain(names)
+}
+
+// list returns the records of the functions idled, by name
+func (s *replicaStore) list() []replicaRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []replicaRecord{}
+	s.records.each(func(key string, value interface{}) {
+		list = append(list, value.(replicaRecord))
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Function < list[j].Function
+	})
+	return list
+}
+
+// recordReplicas keeps the replicas of a function about to be scaled down,
+// and writes them to replicas_configmap for the gateway or an operator to
+// restore. A failed write is logged, it does not hold the scale down back.
+func recordReplicas(ctx context.Context, gateway *Gateway, fn Function, replicas uint64) {
+	record := replicaRecord{
+		Gateway:   gateway.Name,
+		Function:  fn.Name,
+		Namespace: fn.Namespace,
+		Replicas:  replicas,
+		IdledAt:   time.Now(),
+	}
+	if !gateway.replicas.record(record) || gateway.kubernetes == nil || len(gateway.Config.ReplicasConfigMap) == 0 {
+		return
+	}
+
+	namespace := fn.Namespace
+	if len(namespace) == 0 {
+		namespace = defaultFunctionNamespace
+	}
+	writeCtx, cancel := withTimeout(ctx, gateway.Config.ScaleTimeout)
+	defer cancel()
+	if err := gateway.kubernetes.setConfigMapKey(writeCtx, namespace, gateway.Config.ReplicasConfigMap, fn.Name, strconv.FormatUint(replicas, 10)); err != nil {
+		log.Printf("[%s] Unable to record the replicas of %s in configmap %s: %s\n", gateway.Name, fn.Name, gateway.Config.ReplicasConfigMap, err)
+	}
+}
+
+// restoreReplicas scales a woken function back to the replicas it ran
+// before being idled, when the wake brought up fewer. While paused the
+// restore is only logged, the record is forgotten either way.
+func restoreReplicas(ctx context.Context, gateway *Gateway, fn Function, summary *cycleSummary) {
+	record, exists := gateway.replicas.woken(fn.Name)
+	if !exists || !gateway.Config.RestoreReplicas || fn.Replicas >= record.Replicas {
+		return
+	}
+	if summary.Paused {
+		fmt.Printf("paused: %s would be restored to %d\n", fn.Name, record.Replicas)
+		return
+	}
+
+	log.Printf("[%s] Restoring %s to the %d replica(s) it ran before being idled\n", gateway.Name, fn.Name, record.Replicas)
+	if err := sendScaleEventWithRetry(ctx, gateway, fn.Name, record.Replicas); err != nil {
+		log.Printf("[%s] Unable to restore the replicas of %s: %s\n", gateway.Name, fn.Name, err)
+		summary.fail("restore", err)
+	}
+}
+
+// setConfigMapKey sets a key of a ConfigMap, creating it when missing
+func (k *kubernetesClient) setConfigMapKey(ctx context.Context, namespace string, name string, key string, value string) error {
+	patch, _ := json.Marshal(map[string]map[string]string{"data": {key: value}})
+	path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/configmaps"
+
+	_, err := k.do(ctx, http.MethodPatch, path+"/"+url.PathEscape(name), "application/merge-patch+json", patch)
+	if err == nil || !strings.Contains(err.Error(), "got: 404") {
+		return err
+	}
+
+	configMap, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]string{"name": name},
+		"data":       map[string]string{key: value},
+	})
+	if _, err := k.do(ctx, http.MethodPost, path, "application/json", configMap); err != nil {
+		return fmt.Errorf("creating: %s", err)
+	}
+	return nil
+}
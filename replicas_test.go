@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	providerTypes "github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_recordReplicas(t *testing.T) {
+	created := map[string]interface{}{}
+	patches := 0
+	kubernetes := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPatch && r.URL.Path == "/api/v1/namespaces/openfaas-fn/configmaps/faas-idler-replicas":
+			patches++
+			if len(created) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+				t.Errorf("Unexpected content type: %s", r.Header.Get("Content-Type"))
+			}
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/namespaces/openfaas-fn/configmaps":
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &created)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+	}))
+	defer kubernetes.Close()
+
+	gateway := newGateway(types.Config{Name: "test", ReplicasConfigMap: "faas-idler-replicas"})
+	gateway.kubernetes = newKubernetesClient(types.Config{KubernetesAPIURL: kubernetes.URL})
+	fn := Function{Function: requests.Function{Name: "figlet"}}
+
+	recordReplicas(context.Background(), gateway, fn, 5)
+	if data, _ := created["data"].(map[string]interface{}); data["figlet"] != "5" {
+		t.Errorf("A missing configmap should be created with the replicas, got: %v", created)
+	}
+
+	recordReplicas(context.Background(), gateway, fn, 2)
+	if patches != 1 {
+		t.Errorf("A scale down step should not replace the replicas, patches: %d", patches)
+	}
+
+	list := gateway.replicas.list()
+	if len(list) != 1 || list[0].Function != "figlet" || list[0].Replicas != 5 {
+		t.Errorf("Unexpected records: %+v", list)
+	}
+
+	gateway.replicas.woken("figlet")
+	recordReplicas(context.Background(), gateway, fn, 3)
+	if patches != 2 {
+		t.Errorf("Replicas should be recorded again once woken, patches: %d", patches)
+	}
+}
+
+func Test_restoreReplicas(t *testing.T) {
+	scaled := []uint64{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := providerTypes.ScaleServiceRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+		scaled = append(scaled, req.Replicas)
+	}))
+	defer server.Close()
+
+	gateway := newGateway(types.Config{Name: "test", GatewayURL: server.URL + "/", RestoreReplicas: true, ScaleTimeout: time.Second})
+	gateway.replicas.record(replicaRecord{Function: "figlet", Replicas: 4})
+	gateway.replicas.record(replicaRecord{Function: "env", Replicas: 1})
+	summary := newCycleSummary("test", time.Now())
+
+	restoreReplicas(context.Background(), gateway, Function{Function: requests.Function{Name: "figlet", Replicas: 1}}, summary)
+	restoreReplicas(context.Background(), gateway, Function{Function: requests.Function{Name: "env", Replicas: 1}}, summary)
+	if len(scaled) != 1 || scaled[0] != 4 {
+		t.Errorf("Only figlet should be restored to 4 replicas, got: %v", scaled)
+	}
+	if len(gateway.replicas.list()) != 0 {
+		t.Errorf("Records should be forgotten once woken")
+	}
+
+	restoreReplicas(context.Background(), gateway, Function{Function: requests.Function{Name: "figlet", Replicas: 1}}, summary)
+	if len(scaled) != 1 {
+		t.Errorf("A function should be restored once")
+	}
+
+	gateway.replicas.record(replicaRecord{Function: "figlet", Replicas: 4})
+	summary.Paused = true
+	restoreReplicas(context.Background(), gateway, Function{Function: requests.Function{Name: "figlet", Replicas: 1}}, summary)
+	if len(scaled) != 1 || len(gateway.replicas.list()) != 0 {
+		t.Errorf("A restore while paused should only be logged, scales: %v", scaled)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// sendScaleEventWithRetry retries a scale request which failed to reach the
+// gateway or got a 5xx up to scale_retries times, waiting a jittered,
+// exponentially growing backoff between attempts. It gives up early rather
+// than wait past the reconcile pass's deadline.
+func sendScaleEventWithRetry(ctx context.Context, gateway *Gateway, name string, replicas uint64) error {
+	config := gateway.Config
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		scaleCtx, cancelScale := withTimeout(ctx, config.ScaleTimeout)
+		err = sendScaleEvent(scaleCtx, gateway, name, replicas)
+		cancelScale()
+
+		if err == nil || !retryable(err) || attempt >= config.ScaleRetries {
+			return err
+		}
+
+		wait := backoff(config.ScaleRetryBackoff, attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+
+		log.Printf("[%s] Retrying scale of %s in %s: %s\n", gateway.Name, name, wait, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retryingProvider retries activity queries which failed up to
+// metrics_retries times, waiting a jittered, exponentially growing backoff
+// between attempts, and gives up early rather than wait past the reconcile
+// pass's deadline
+type retryingProvider struct {
+	next    MetricsProvider
+	retries int
+	backoff time.Duration
+}
+
+func newRetryingProvider(next MetricsProvider, retries int, backoff time.Duration) *retryingProvider {
+	return &retryingProvider{next: next, retries: retries, backoff: backoff}
+}
+
+func (p *retryingProvider) Activity(ctx context.Context, function string, window time.Duration) (float64, bool, error) {
+	var rate float64
+	var found bool
+	err := p.retry(ctx, func() error {
+		var err error
+		rate, found, err = p.next.Activity(ctx, function, window)
+		return err
+	})
+	return rate, found, err
+}
+
+// ActivityBatch is not batched when the provider it wraps does not batch
+func (p *retryingProvider) ActivityBatch(ctx context.Context, functions []string, window time.Duration) (map[string]float64, bool, error) {
+	batcher, ok := p.next.(BatchMetricsProvider)
+	if !ok {
+		return nil, false, nil
+	}
+
+	var rates map[string]float64
+	err := p.retry(ctx, func() error {
+		var err error
+		rates, ok, err = batcher.ActivityBatch(ctx, functions, window)
+		return err
+	})
+	return rates, ok, err
+}
+
+func (p *retryingProvider) retry(ctx context.Context, query func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = query()
+		if err == nil || ctx.Err() != nil || attempt >= p.retries {
+			return err
+		}
+
+		wait := backoff(p.backoff, attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+
+		log.Printf("Retrying activity query in %s: %s\n", wait, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retryable is false for responses the gateway will give again, such as a
+// 404 for a function which was removed
+func retryable(err error) bool {
+	if statusErr, ok := err.(scaleStatusError); ok {
+		return statusErr.code >= 500
+	}
+	return true
+}
+
+// backoff is a random duration between half and all of base doubled for each
+// previous attempt, capped at a minute
+func backoff(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+
+	ceiling := base << uint(attempt)
+	if ceiling <= 0 || ceiling > time.Minute {
+		ceiling = time.Minute
+	}
+	return ceiling/2 + time.Duration(rand.Int63n(int64(ceiling/2)+1))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_sendScaleEventWithRetry(t *testing.T) {
+	cases := []struct {
+		name         string
+		codes        []int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"succeeds after transient 503s", []int{503, 503, 200}, 3, false},
+		{"gives up after retries", []int{503, 503, 503, 503, 503}, 3, true},
+		{"does not retry a 404", []int{404, 200}, 1, true},
+	}
+
+	for _, c := range cases {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.codes[attempts])
+			attempts++
+		}))
+
+		gateway := newGateway(types.Config{
+			Name:              "test",
+			GatewayURL:        server.URL + "/",
+			ScaleRetries:      2,
+			ScaleRetryBackoff: time.Millisecond,
+		})
+
+		err := sendScaleEventWithRetry(context.Background(), gateway, "figlet", 0)
+		server.Close()
+
+		if attempts != c.wantAttempts {
+			t.Errorf("%s: attempts wanted: %d got: %d", c.name, c.wantAttempts, attempts)
+		}
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: error wanted: %v got: %v", c.name, c.wantErr, err)
+		}
+	}
+}
+
+func Test_backoff(t *testing.T) {
+	for attempt := 0; attempt < 4; attempt++ {
+		ceiling := time.Second << uint(attempt)
+		if wait := backoff(time.Second, attempt); wait < ceiling/2 || wait > ceiling {
+			t.Errorf("Attempt %d: backoff wanted between %s and %s got: %s", attempt, ceiling/2, ceiling, wait)
+		}
+	}
+
+	if wait := backoff(time.Second, 20); wait > time.Minute {
+		t.Errorf("Backoff should be capped at a minute, got: %s", wait)
+	}
+}
+
+// flakyProvider fails the first failures queries
+type flakyProvider struct {
+	failures int
+	queries  int
+}
+
+func (p *flakyProvider) Activity(ctx context.Context, function string, window time.Duration) (float64, bool, error) {
+	p.queries++
+	if p.queries <= p.failures {
+		return 0, false, fmt.Errorf("unavailable")
+	}
+	return 0.5, true, nil
+}
+
+func Test_retryingProvider(t *testing.T) {
+	next := &flakyProvider{failures: 2}
+	provider := newRetryingProvider(next, 2, time.Millisecond)
+
+	rate, found, err := provider.Activity(context.Background(), "figlet", time.Minute)
+	if err != nil || !found || rate != 0.5 || next.queries != 3 {
+		t.Errorf("Wanted a rate after 3 queries, got: %f %t %v after %d", rate, found, err, next.queries)
+	}
+
+	next = &flakyProvider{failures: 5}
+	provider = newRetryingProvider(next, 2, time.Millisecond)
+	if _, _, err := provider.Activity(context.Background(), "figlet", time.Minute); err == nil || next.queries != 3 {
+		t.Errorf("Wanted an error after 3 queries, got: %v after %d", err, next.queries)
+	}
+
+	if _, ok, _ := provider.ActivityBatch(context.Background(), []string{"figlet"}, time.Minute); ok {
+		t.Errorf("A provider which does not batch should not be batched")
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// maxIdleGap caps the time counted as idle between two decisions, longer
+// gaps are the idler not running rather than the function being idle
+const maxIdleGap = time.Hour
+
+// DailyRollup aggregates a function's events of one day, UTC
+type DailyRollup struct {
+	Day           string  `json:"day"`
+	Gateway       string  `json:"gateway"`
+	Function      string  `json:"function"`
+	Decisions     int     `json:"decisions"`
+	IdleDecisions int     `json:"idle_decisions"`
+	IdleHours     float64 `json:"idle_hours"`
+	ScaleDowns    int     `json:"scale_downs"`
+	ScaleUps      int     `json:"scale_ups"`
+	Wakes         int     `json:"wakes"`
+	Errors        int     `json:"errors"`
+}
+
+func (r *DailyRollup) add(other DailyRollup) {
+	r.Decisions += other.Decisions
+	r.IdleDecisions += other.IdleDecisions
+	r.IdleHours += other.IdleHours
+	r.ScaleDowns += other.ScaleDowns
+	r.ScaleUps += other.ScaleUps
+	r.Wakes += other.Wakes
+	r.Errors += other.Errors
+}
+
+type rollupKey struct {
+	day      string
+	gateway  string
+	function string
+}
+
+// rollups holds the daily aggregates by day and function
+type rollups map[rollupKey]*DailyRollup
+
+func (r rollups) get(day string, gateway string, function string) *DailyRollup {
+	key := rollupKey{day: day, gateway: gateway, function: function}
+	if _, exists := r[key]; !exists {
+		r[key] = &DailyRollup{Day: day, Gateway: gateway, Function: function}
+	}
+	return r[key]
+}
+
+func (r rollups) merge(other rollups) {
+	for key, rollup := range other {
+		r.get(key.day, key.gateway, key.function).add(*rollup)
+	}
+}
+
+// list returns the aggregates the most recent day first
+func (r rollups) list() []DailyRollup {
+	list := []DailyRollup{}
+	for _, rollup := range r {
+		list = append(list, *rollup)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Day != list[j].Day {
+			return list[i].Day > list[j].Day
+		}
+		if list[i].Gateway != list[j].Gateway {
+			return list[i].Gateway < list[j].Gateway
+		}
+		return list[i].Function < list[j].Function
+	})
+	return list
+}
+
+// rollUp aggregates the events of the audit log for which include returns
+// true. The time until a function's next decision, seen in any of the
+// lines, is counted as idle after an idle decision.
+func rollUp(lines []auditLine, include func(i int) bool) rollups {
+	result := rollups{}
+	nextDecision := map[rollupKey]time.Time{}
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		event := lines[i].notification
+		function := rollupKey{gateway: event.Gateway, function: event.Function}
+
+		if include(i) {
+			rollup := result.get(event.Timestamp.UTC().Format("2006-01-02"), event.Gateway, event.Function)
+
+			switch event.Kind {
+			case kindDecision:
+				rollup.Decisions++
+				if event.Idle {
+					rollup.IdleDecisions++
+					if next, exists := nextDecision[function]; exists {
+						gap := next.Sub(event.Timestamp)
+						if gap > maxIdleGap {
+							gap = maxIdleGap
+						}
+						rollup.IdleHours += gap.Hours()
+					}
+				}
+			case kindScale:
+				if event.Replicas == 0 {
+					rollup.ScaleDowns++
+				} else {
+					rollup.ScaleUps++
+				}
+			case kindWake:
+				rollup.Wakes++
+			case kindError:
+				rollup.Errors++
+			}
+		}
+
+		if event.Kind == kindDecision {
+			nextDecision[function] = event.Timestamp
+		}
+	}
+	return result
+}
+
+func saveRollups(path string, r rollups) error {
+	data, err := json.Marshal(r.list())
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadRollups reads the aggregates of the events already pruned, the file
+// may not exist yet
+func loadRollups(path string) (rollups, error) {
+	result := rollups{}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return result, err
+	}
+
+	list := []DailyRollup{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return result, err
+	}
+	for _, rollup := range list {
+		result.get(rollup.Day, rollup.Gateway, rollup.Function).add(rollup)
+	}
+	return result, nil
+}
+
+// RollupPage is a page of daily aggregates, the most recent day first
+type RollupPage struct {
+	Page    int           `json:"page"`
+	PerPage int           `json:"per_page"`
+	Total   int           `json:"total"`
+	Days    []DailyRollup `json:"days"`
+}
+
+// readRollups aggregates the events pruned from the audit log with the ones
+// it still holds and returns the requested page
+func readRollups(a *auditLog, q historyQuery) (RollupPage, error) {
+	page := RollupPage{Page: q.Page, PerPage: q.PerPage, Days: []DailyRollup{}}
+
+	// Held so that a compaction does not move events from one to the other
+	// while they are read
+	a.mu.Lock()
+	stored, err := loadRollups(a.rollupPath())
+	if err != nil {
+		a.mu.Unlock()
+		return page, err
+	}
+	lines, err := readAuditLines(a.path)
+	a.mu.Unlock()
+	if err != nil {
+		return page, err
+	}
+	stored.merge(rollUp(lines, func(int) bool { return true }))
+
+	matched := []DailyRollup{}
+	for _, rollup := range stored.list() {
+		day, _ := time.Parse("2006-01-02", rollup.Day)
+		switch {
+		case len(q.Function) > 0 && q.Function != rollup.Function:
+		case len(q.Gateway) > 0 && q.Gateway != rollup.Gateway:
+		case !q.Since.IsZero() && !day.Add(time.Hour*24).After(q.Since):
+		case !q.Until.IsZero() && !day.Before(q.Until):
+		default:
+			matched = append(matched, rollup)
+		}
+	}
+
+	page.Total = len(matched)
+	for i := (q.Page - 1) * q.PerPage; i < len(matched) && len(page.Days) < q.PerPage; i++ {
+		page.Days = append(page.Days, matched[i])
+	}
+	return page, nil
+}
+
+// makeRollupsHandler serves the daily aggregates of the history on
+// /api/history/rollups, filtered and paged as /api/history
+func makeRollupsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if audit == nil {
+			http.Error(w, "audit_log_file is not set", http.StatusNotFound)
+			return
+		}
+
+		q, err := parseHistoryQuery(r, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := readRollups(audit, q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
+	}
+}
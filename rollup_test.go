@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_rollUp(t *testing.T) {
+	start := time.Date(2019, 1, 1, 22, 0, 0, 0, time.UTC)
+	events := []Notification{
+		{Kind: kindDecision, Gateway: "prod", Function: "figlet", Idle: true, Timestamp: start},
+		{Kind: kindScale, Gateway: "prod", Function: "figlet", Replicas: 0, Success: true, Timestamp: start},
+		{Kind: kindDecision, Gateway: "prod", Function: "figlet", Idle: true, Timestamp: start.Add(time.Minute * 30)},
+		{Kind: kindError, Gateway: "prod", Function: "nodeinfo", Timestamp: start.Add(time.Minute * 30)},
+		// Three hours without a decision count as one
+		{Kind: kindDecision, Gateway: "prod", Function: "figlet", Timestamp: start.Add(time.Hour * 3)},
+	}
+	lines := []auditLine{}
+	for _, event := range events {
+		lines = append(lines, auditLine{notification: event})
+	}
+
+	result := rollUp(lines, func(i int) bool { return true })
+
+	figlet := result[rollupKey{day: "2019-01-01", gateway: "prod", function: "figlet"}]
+	if figlet == nil || figlet.Decisions != 2 || figlet.IdleDecisions != 2 || figlet.ScaleDowns != 1 || figlet.IdleHours != 1.5 {
+		t.Errorf("Unexpected rollup for figlet: %+v", figlet)
+	}
+	if next := result[rollupKey{day: "2019-01-02", gateway: "prod", function: "figlet"}]; next == nil || next.Decisions != 1 || next.IdleHours != 0 {
+		t.Errorf("Unexpected rollup for figlet on the next day: %+v", next)
+	}
+	if nodeinfo := result[rollupKey{day: "2019-01-01", gateway: "prod", function: "nodeinfo"}]; nodeinfo == nil || nodeinfo.Errors != 1 {
+		t.Errorf("Unexpected rollup for nodeinfo: %+v", nodeinfo)
+	}
+}
+
+func Test_readRollups_afterCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2019, 1, 31, 12, 0, 0, 0, time.UTC)
+	log, err := openAuditLog(dir+"/audit.log", time.Hour*24, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 3; i >= 0; i-- {
+		log.write(Notification{Kind: kindScale, Gateway: "prod", Function: "figlet", Success: true, Timestamp: now.Add(-time.Hour * 24 * time.Duration(i))})
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := log.compact(now)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if want := []int{2, 0}[i]; result.RolledUp != want {
+			t.Errorf("Compaction %d should roll up %d event(s), got: %d", i, want, result.RolledUp)
+		}
+	}
+
+	page, err := readRollups(log, historyQuery{Function: "figlet", Page: 1, PerPage: 10})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if page.Total != 4 || page.Days[0].Day != "2019-01-31" || page.Days[3].Day != "2019-01-28" {
+		t.Fatalf("Every day should be kept, the most recent first, got: %+v", page)
+	}
+	for _, day := range page.Days {
+		if day.ScaleDowns != 1 {
+			t.Errorf("Each day should count one scale down, got: %+v", day)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/types"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RuleFile is a Prometheus recording rules file
+type RuleFile struct {
+	Groups []RuleGroup `yaml:"groups"`
+}
+
+// RuleGroup is a group of recording rules evaluated together
+type RuleGroup struct {
+	Name  string          `yaml:"name"`
+	Rules []RecordingRule `yaml:"rules"`
+}
+
+// RecordingRule records the result of expr as a new series
+type RecordingRule struct {
+	Record string `yaml:"record"`
+	Expr   string `yaml:"expr"`
+}
+
+// recordingRuleName is the series pre-aggregating the invocation rate of
+// every function over a window
+func recordingRuleName(window time.Duration) string {
+	return "function_name:gateway_function_invocation_total:rate" + promDuration(window)
+}
+
+// recordingRules generates a rule for each distinct window, query_offset is
+// left to the lookup of the recorded series
+func recordingRules(windows []time.Duration, config types.Config) RuleFile {
+	unique := map[time.Duration]bool{}
+	sorted := []time.Duration{}
+	for _, window := range windows {
+		if window > 0 && !unique[window] {
+			unique[window] = true
+			sorted = append(sorted, window)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	selectorConfig := config
+	selectorConfig.QueryOffset = 0
+
+	group := RuleGroup{Name: "faas-idler", Rules: []RecordingRule{}}
+	for _, window := range sorted {
+		group.Rules = append(group.Rules, RecordingRule{
+			Record: recordingRuleName(window),
+			Expr:   `sum(rate(gateway_function_invocation_total` + rangeSelector(window, 0, selectorConfig) + `)) by (function_name)`,
+		})
+	}
+	return RuleFile{Groups: []RuleGroup{group}}
+}
+
+// recordedActivityQuery looks up a function's rate from the series recorded
+// for its window
+func recordedActivityQuery(name string, window time.Duration, config types.Config) string {
+	query := recordingRuleName(window) + `{function_name="` + name + `"}`
+	if config.QueryOffset > 0 {
+		query += ` offset ` + promDuration(config.QueryOffset)
+	}
+	return `sum(` + query + `) by (function_name)`
+}
+
+// recordedBatchQuery looks up the rate of every function from the series
+// recorded for the window
+func recordedBatchQuery(window time.Duration, config types.Config) string {
+	query := recordingRuleName(window)
+	if config.QueryOffset > 0 {
+		query += ` offset ` + promDuration(config.QueryOffset)
+	}
+	return `sum(` + query + `) by (function_name)`
+}
+
+// runGenRules prints recording rules for the inactivity duration of every
+// function which may be idled, across all gateways
+func runGenRules(config types.Config) int {
+	windows := []time.Duration{config.InactivityDuration}
+	if schedule, err := parseInactivitySchedule(config.InactivitySchedule, config.InactivityTimezone); err == nil && schedule != nil {
+		for _, window := range schedule.windows {
+			windows = append(windows, window.duration)
+		}
+	}
+	if config.RecheckWindow > 0 {
+		windows = append(windows, config.RecheckWindow)
+	}
+	for _, gatewayConfig := range config.Targets() {
+		gateway := newGateway(gatewayConfig)
+		gateway.checkHealth(context.Background())
+
+		export, err := exportPolicies(context.Background(), gateway)
+		if err != nil {
+			log.Printf("[%s] Unable to list functions: %s\n", gateway.Name, err)
+			return 1
+		}
+
+		windows = append(windows, gatewayConfig.InactivityDuration)
+		for _, fn := range export.Functions {
+			if fn.Enabled && !fn.Never {
+				windows = append(windows, fn.InactivityDuration)
+			}
+		}
+	}
+
+	out, _ := yaml.Marshal(recordingRules(windows, config))
+	fmt.Fprintf(os.Stdout, "%s", out)
+	return 0
+}
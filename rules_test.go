@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/types"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func Test_recordingRules(t *testing.T) {
+	config := types.Config{QueryResolution: time.Minute, QueryOffset: time.Second * 30}
+	rules := recordingRules([]time.Duration{time.Hour, time.Minute * 5, time.Hour, 0}, config)
+
+	out, err := yaml.Marshal(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `groups:
+- name: faas-idler
+  rules:
+  - record: function_name:gateway_function_invocation_total:rate5m
+    expr: sum(rate(gateway_function_invocation_total[5m:1m])) by (function_name)
+  - record: function_name:gateway_function_invocation_total:rate60m
+    expr: sum(rate(gateway_function_invocation_total[60m:1m])) by (function_name)
+`
+	if string(out) != want {
+		t.Errorf("Rules wanted:\n%s\ngot:\n%s", want, out)
+	}
+}
+
+func Test_activityQuery_recordingRules(t *testing.T) {
+	config := types.Config{UseRecordingRules: true, QueryOffset: time.Second * 30}
+
+	want := `sum(function_name:gateway_function_invocation_total:rate5m{function_name="figlet"} offset 30s) by (function_name)`
+	if got := activityQuery("figlet", time.Minute*5, config); got != want {
+		t.Errorf("Query wanted: %s got: %s", want, got)
+	}
+}
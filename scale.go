@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/types"
+)
+
+// scaleDown passes the scale of an idle function to target, scale_target or
+// its next scale down step, through the pre-scale hooks and opa_url, then
+// scales it to the target they settle on, unless invocations above threshold
+// over recheck_window or a veto signal find it busy or its pre-idle hook
+// vetoes it, and reports the outcome to the post-scale hooks in the
+// background and to the summary. It returns whether the function was scaled.
+func scaleDown(ctx context.Context, gateway *Gateway, fn Function, rate float64, threshold float64, replicas uint64, target uint64, summary *cycleSummary) bool {
+	action := newScaleAction(gateway, fn, replicas, target)
+
+	if !dryRun {
+		if len(gateway.Config.DecisionWebhookURL) > 0 && !decisionAllows(ctx, gateway, fn, action, rate, summary) {
+			return false
+		}
+
+		var err error
+		action, err = runPreScaleHooks(ctx, gateway.Config.PreScaleHooks, fn, action)
+		if err != nil {
+			log.Printf("[%s] Not scaling %s: %s\n", gateway.Name, fn.Name, err)
+			summary.skip(skipVetoed)
+			return false
+		}
+
+		if len(gateway.Config.OPAURL) > 0 {
+			action, err = opaDecision(ctx, gateway.Config, action, rate)
+			if err != nil {
+				log.Printf("[%s] Not scaling %s: %s\n", gateway.Name, fn.Name, err)
+				summary.skip(skipVetoed)
+				return false
+			}
+		}
+
+		if action.Target == 0 && slaGuarded(fn, gateway.Config) {
+			log.Printf("[%s] Keeping a replica of %s for its %s, hooks set target 0\n", gateway.Name, fn.Name, slaAnnotation)
+			action.Target = 1
+		}
+		if action.Target >= replicas {
+			log.Printf("[%s] Not scaling %s: hooks set target %d with %d replica(s) running\n", gateway.Name, fn.Name, action.Target, replicas)
+			summary.skip(skipHookTarget)
+			return false
+		}
+	}
+
+	// Idling is decided on rates over a window, a long invocation may have
+	// started or requests been queued since, or a drain delay passed
+	if !recheckActivity(ctx, gateway, fn, threshold, summary) {
+		return false
+	}
+	for _, name := range vetoSignals(gateway.Config) {
+		value, idle, err := activitySignals[name].read(ctx, gateway.PrometheusClient, fn, 0, gateway.Config)
+		if err != nil {
+			log.Printf("[%s] Not scaling %s: unable to read the %s signal: %s\n", gateway.Name, fn.Name, name, err)
+			summary.fail(name, err)
+			return false
+		}
+		if !idle {
+			log.Printf("[%s] Not scaling %s: %s is busy at %.0f\n", gateway.Name, fn.Name, name, value)
+			summary.skip(activitySignals[name].skip)
+			return false
+		}
+	}
+
+	// The function's own hook is called last, once nothing else holds the
+	// scale down back, as it may flush its caches
+	if !dryRun {
+		if err := runPreIdleHook(ctx, gateway, fn, action); err != nil {
+			log.Printf("[%s] Not scaling %s: %s\n", gateway.Name, fn.Name, err)
+			summary.skip(skipVetoed)
+			return false
+		}
+	}
+
+	summary.scaleDowns++
+	if summary.scaleLimited(gateway.Config.MaxScaleDownsPerCycle) {
+		log.Printf("[%s] Reached max_scale_downs_per_cycle of %d, idle functions left are scaled in later passes\n", gateway.Name, gateway.Config.MaxScaleDownsPerCycle)
+	}
+
+	if !dryRun {
+		recordReplicas(ctx, gateway, fn, replicas)
+	}
+	scaleErr := sendScaleEventWithRetry(ctx, gateway, fn.Name, action.Target)
+	if scaleErr != nil {
+		summary.fail("scale", scaleErr)
+	} else {
+		summary.Idled++
+	}
+
+	if !dryRun {
+		if scaleErr == nil {
+			gateway.wakes.scaled(fn.Name, action.Target)
+			if gateway.Config.AutoTune {
+				gateway.tuning.idled(fn.Name, time.Now())
+			}
+		}
+		gateway.notifier.notify(fn, scaleNotification(gateway, fn, action.Target, scaleErr))
+
+		outcome := ScaleOutcome{ScaleAction: action, Success: scaleErr == nil}
+		outcome.Schema = schemaScaleOutcome
+		if scaleErr != nil {
+			outcome.Error = scaleErr.Error()
+		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runPostScaleHooks(context.Background(), gateway.Config.PostScaleHooks, fn, outcome)
+		}()
+	}
+	return scaleErr == nil
+}
+
+// decisionAllows asks decision_webhook_url whether to go on with a scale
+// event, a deferred function is left alone for defer_for
+func decisionAllows(ctx context.Context, gateway *Gateway, fn Function, action ScaleAction, rate float64, summary *cycleSummary) bool {
+	verdict, deferFor, err := askDecisionWebhook(ctx, gateway.Config, DecisionRequest{ScaleAction: action, Rate: rate})
+	if err != nil {
+		if gateway.Config.DecisionWebhookFailurePolicy == types.FailurePolicyIgnore {
+			log.Printf("[%s] Ignoring the decision webhook for %s: %s\n", gateway.Name, fn.Name, err)
+			return true
+		}
+		log.Printf("[%s] Not scaling %s: decision webhook failed: %s\n", gateway.Name, fn.Name, err)
+		summary.fail("decision", err)
+		return false
+	}
+
+	switch verdict.Verdict {
+	case verdictDeny:
+		log.Printf("[%s] Not scaling %s: denied by the decision webhook: %s\n", gateway.Name, fn.Name, verdict.Reason)
+		summary.skip(skipDenied)
+		return false
+	case verdictDefer:
+		if deferFor > 0 {
+			gateway.wakes.hold(fn.Name, time.Now().Add(deferFor))
+		}
+		log.Printf("[%s] Not scaling %s: deferred by the decision webhook for %s: %s\n", gateway.Name, fn.Name, deferFor, verdict.Reason)
+		summary.skip(skipDeferred)
+		return false
+	}
+	return true
+}
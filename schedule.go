@@ -0,0 +1,358 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule decides when the next reconcile pass starts
+type schedule interface {
+	next(after time.Time) time.Time
+}
+
+// intervalSchedule waits a fixed interval after each pass
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+func (s intervalSchedule) next(after time.Time) time.Time {
+	return after.Add(s.interval)
+}
+
+// adaptiveSchedule stretches or shrinks the interval between passes within
+// its bounds, keeping it at least twice as long as the last pass and
+// perFunction for each function evaluated
+type adaptiveSchedule struct {
+	min, max    time.Duration
+	perFunction time.Duration
+	interval    time.Duration
+}
+
+func newAdaptiveSchedule(interval time.Duration, min time.Duration, max time.Duration, perFunction time.Duration) *adaptiveSchedule {
+	s := &adaptiveSchedule{
+		min:         min,
+		max:         max,
+		perFunction: perFunction,
+	}
+	s.set(interval)
+	return s
+}
+
+func (s *adaptiveSchedule) next(after time.Time) time.Time {
+	return after.Add(s.interval)
+}
+
+// record adapts the interval to the longest pass over any gateway and the
+// number of functions evaluated across all of them
+func (s *adaptiveSchedule) record(duration time.Duration, functions int) {
+	target := duration * 2
+	if fleet := s.perFunction * time.Duration(functions); fleet > target {
+		target = fleet
+	}
+	s.set(target)
+}
+
+func (s *adaptiveSchedule) set(interval time.Duration) {
+	if interval < s.min {
+		interval = s.min
+	}
+	if interval > s.max {
+		interval = s.max
+	}
+
+	s.interval = interval
+	reconcileInterval.Set(interval.Seconds())
+}
+
+// cronSchedule runs at the earliest time matched by any of its expressions
+type cronSchedule struct {
+	exprs []cronExpr
+}
+
+func (s cronSchedule) next(after time.Time) time.Time {
+	next := time.Time{}
+	for _, expr := range s.exprs {
+		if t := expr.next(after); next.IsZero() || (!t.IsZero() && t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// parseSchedule reads reconcile_schedule, either a Golang duration or one or
+// more cron expressions separated by ;, i.e. "*/5 8-19 * * 1-5; 0 * * * *".
+// An empty value keeps the fixed reconcile_interval.
+func parseSchedule(val string, interval time.Duration) (schedule, error) {
+	val = strings.TrimSpace(val)
+	if len(val) == 0 {
+		return intervalSchedule{interval: interval}, nil
+	}
+
+	if duration, err := time.ParseDuration(val); err == nil {
+		if duration <= 0 {
+			return nil, fmt.Errorf("reconcile_schedule: interval must be positive, got: %s", val)
+		}
+		return intervalSchedule{interval: duration}, nil
+	}
+
+	s := cronSchedule{}
+	for _, item := range strings.Split(val, ";") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		expr, err := parseCron(item)
+		if err != nil {
+			return nil, fmt.Errorf("reconcile_schedule: %q: %s", item, err)
+		}
+		s.exprs = append(s.exprs, expr)
+	}
+
+	if len(s.exprs) == 0 {
+		return nil, fmt.Errorf("reconcile_schedule: no cron expression in %q", val)
+	}
+	return s, nil
+}
+
+// cronExpr is a standard five field cron expression in local time, each
+// field a bit set of the values it matches
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny are set for * so that a restricted day of month or
+	// day of week alone decides the day, as in cron
+	domAny, dowAny bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func parseCron(val string) (cronExpr, error) {
+	if descriptor, exists := cronDescriptors[val]; exists {
+		val = descriptor
+	}
+
+	fields := strings.Fields(val)
+	if len(fields) != 5 {
+		return cronExpr{}, fmt.Errorf("expected 5 fields, got: %d", len(fields))
+	}
+
+	bounds := []struct {
+		name     string
+		min, max int
+	}{
+		{"minute", 0, 59},
+		{"hour", 0, 23},
+		{"day of month", 1, 31},
+		{"month", 1, 12},
+		{"day of week", 0, 7},
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i].min, bounds[i].max)
+		if err != nil {
+			return cronExpr{}, fmt.Errorf("%s: %s", bounds[i].name, err)
+		}
+		sets[i] = set
+	}
+
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return cronExpr{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField reads a comma-separated list of *, a value or a range, each
+// optionally with a /step
+func parseCronField(field string, min int, max int) (uint64, error) {
+	set := uint64(0)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			parsedStep, err := strconv.Atoi(part[i+1:])
+			if err != nil || parsedStep <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = parsedStep
+			part = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var lowErr, highErr error
+			low, lowErr = strconv.Atoi(bounds[0])
+			high, highErr = strconv.Atoi(bounds[1])
+			if lowErr != nil || highErr != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			low, high = value, value
+			if step > 1 {
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is outside of %d-%d", part, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+	return set, nil
+}
+
+func (e cronExpr) matchesDay(t time.Time) bool {
+	dom := e.dom&(1<<uint(t.Day())) != 0
+	dow := e.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case e.domAny && e.dowAny:
+		return true
+	case e.domAny:
+		return dow
+	case e.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// matches reports whether the minute of the given time is matched by the
+// expression
+func (e cronExpr) matches(t time.Time) bool {
+	return e.month&(1<<uint(t.Month())) != 0 && e.matchesDay(t) &&
+		e.hour&(1<<uint(t.Hour())) != 0 && e.minute&(1<<uint(t.Minute())) != 0
+}
+
+// next returns the first minute after the given time matched by the
+// expression, or the zero time when none is found within five years
+func (e cronExpr) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	loc := t.Location()
+
+	for t.Before(limit) {
+		switch {
+		case e.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !e.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case e.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case e.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// sweep selects the functions evaluated by a reconcile pass: those in
+// namespaces with their own namespace_schedules entry when it is due, all
+// others when the global schedule is
+type sweep struct {
+	global     bool
+	namespaces map[string]bool
+	scheduled  map[string]time.Duration
+
+	// timeout bounds the pass instead of reconcile_interval when set
+	timeout time.Duration
+}
+
+// fullSweep evaluates every function
+var fullSweep = sweep{global: true}
+
+func (s sweep) includes(namespace string) bool {
+	if _, own := s.scheduled[namespace]; own {
+		return s.namespaces[namespace]
+	}
+	return s.global
+}
+
+// sweepScheduler tracks when the global schedule and each namespace with its
+// own interval are next due, every one of them is due at start
+type sweepScheduler struct {
+	global     schedule
+	intervals  map[string]time.Duration
+	nextGlobal time.Time
+	next       map[string]time.Time
+}
+
+func newSweepScheduler(global schedule, intervals map[string]time.Duration) *sweepScheduler {
+	return &sweepScheduler{
+		global:    global,
+		intervals: intervals,
+		next:      make(map[string]time.Time),
+	}
+}
+
+// due returns the sweep of everything due at the given time
+func (s *sweepScheduler) due(now time.Time) sweep {
+	due := sweep{
+		global:     !now.Before(s.nextGlobal),
+		namespaces: make(map[string]bool),
+		scheduled:  s.intervals,
+	}
+	for namespace := range s.intervals {
+		due.namespaces[namespace] = !now.Before(s.next[namespace])
+	}
+	return due
+}
+
+// advance schedules the next run of everything swept once the pass finished
+func (s *sweepScheduler) advance(swept sweep, now time.Time) {
+	if swept.global {
+		s.nextGlobal = s.global.next(now)
+	}
+	for namespace, interval := range s.intervals {
+		if swept.namespaces[namespace] {
+			s.next[namespace] = now.Add(interval)
+		}
+	}
+}
+
+// wakeAt is the earliest time anything is due, zero once the global
+// schedule never matches again
+func (s *sweepScheduler) wakeAt() time.Time {
+	if s.nextGlobal.IsZero() {
+		return s.nextGlobal
+	}
+
+	wake := s.nextGlobal
+	for _, next := range s.next {
+		if next.Before(wake) {
+			wake = next
+		}
+	}
+	return wake
+}
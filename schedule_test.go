@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parseSchedule(t *testing.T) {
+	invalid := []string{"-1m", "* * * *", "61 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", ";"}
+	for _, val := range invalid {
+		if _, err := parseSchedule(val, time.Second*30); err == nil {
+			t.Errorf("%q: wanted an error", val)
+		}
+	}
+
+	s, err := parseSchedule("", time.Second*30)
+	if err != nil || s.(intervalSchedule).interval != time.Second*30 {
+		t.Errorf("Empty schedule should keep the interval, got: %v %v", s, err)
+	}
+
+	s, err = parseSchedule("2m", time.Second*30)
+	if err != nil || s.(intervalSchedule).interval != time.Minute*2 {
+		t.Errorf("Duration schedule wanted: 2m got: %v %v", s, err)
+	}
+}
+
+func Test_cronScheduleNext(t *testing.T) {
+	// Every 5 minutes from 08:00 to 19:55 on weekdays, hourly otherwise
+	s, err := parseSchedule("*/5 8-19 * * 1-5; 0 * * * *", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Monday 1st April 2019
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2019, time.April, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		after time.Time
+		want  time.Time
+	}{
+		{at(1, 9, 2), at(1, 9, 5)},
+		{at(1, 9, 5), at(1, 9, 10)},
+		{at(1, 19, 56), at(1, 20, 0)},
+		{at(1, 20, 1), at(1, 21, 0)},
+		{at(1, 7, 30), at(1, 8, 0)},
+		{at(6, 9, 2), at(6, 10, 0)},
+	}
+
+	for _, c := range cases {
+		if next := s.next(c.after); !next.Equal(c.want) {
+			t.Errorf("After %s wanted: %s got: %s", c.after, c.want, next)
+		}
+	}
+}
+
+func Test_cronExprDays(t *testing.T) {
+	cases := []struct {
+		expr  string
+		after time.Time
+		want  time.Time
+	}{
+		// Day of month or day of week when both are restricted
+		{"0 0 13 * 5", time.Date(2019, time.April, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, time.April, 5, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2019, time.December, 15, 0, 0, 0, 0, time.UTC), time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2019, time.April, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, time.April, 7, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Date(2019, time.April, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+
+	for _, c := range cases {
+		expr, err := parseCron(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %s", c.expr, err)
+		}
+		if next := expr.next(c.after); !next.Equal(c.want) {
+			t.Errorf("%s after %s wanted: %s got: %s", c.expr, c.after, c.want, next)
+		}
+	}
+}
+
+func Test_sweepScheduler(t *testing.T) {
+	start := time.Date(2019, time.April, 1, 9, 0, 0, 0, time.UTC)
+	scheduler := newSweepScheduler(intervalSchedule{interval: time.Minute * 10}, map[string]time.Duration{
+		"dev": time.Minute * 2,
+	})
+
+	due := scheduler.due(start)
+	if !due.includes("dev") || !due.includes("prod") || !due.includes("") {
+		t.Errorf("Everything should be due at start, got: %+v", due)
+	}
+	scheduler.advance(due, start)
+
+	if wake := scheduler.wakeAt(); !wake.Equal(start.Add(time.Minute * 2)) {
+		t.Errorf("Wake wanted: %s got: %s", start.Add(time.Minute*2), wake)
+	}
+
+	due = scheduler.due(start.Add(time.Minute * 2))
+	if !due.includes("dev") || due.includes("prod") {
+		t.Errorf("Only dev should be due after 2m, got: %+v", due)
+	}
+	scheduler.advance(due, start.Add(time.Minute*2))
+
+	due = scheduler.due(start.Add(time.Minute * 10))
+	if !due.includes("dev") || !due.includes("prod") {
+		t.Errorf("Everything should be due after 10m, got: %+v", due)
+	}
+
+	if !fullSweep.includes("dev") {
+		t.Errorf("A full sweep should include every namespace")
+	}
+}
+
+func Test_adaptiveSchedule(t *testing.T) {
+	s := newAdaptiveSchedule(time.Second*30, time.Second*10, time.Minute*5, time.Millisecond*100)
+	if s.interval != time.Second*30 {
+		t.Errorf("Interval wanted: %s got: %s", time.Second*30, s.interval)
+	}
+
+	cases := []struct {
+		duration  time.Duration
+		functions int
+		want      time.Duration
+	}{
+		{time.Second, 10, time.Second * 10},
+		{time.Second * 40, 10, time.Second * 80},
+		{time.Second * 5, 1200, time.Minute * 2},
+		{time.Minute * 4, 10, time.Minute * 5},
+	}
+
+	for _, c := range cases {
+		s.record(c.duration, c.functions)
+		if s.interval != c.want {
+			t.Errorf("%s over %d functions: interval wanted: %s got: %s", c.duration, c.functions, c.want, s.interval)
+		}
+	}
+
+	start := time.Now()
+	if next := s.next(start); !next.Equal(start.Add(time.Minute * 5)) {
+		t.Errorf("Next wanted: %s got: %s", start.Add(time.Minute*5), next)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schemas of the events emitted, carried in their schema field. A version
+// only ever gains optional fields, renaming or removing one or changing its
+// type starts a new version.
+const (
+	schemaNotification = "faas-idler.notification.v1"
+	schemaScaleAction  = "faas-idler.scale_action.v1"
+	schemaScaleOutcome = "faas-idler.scale_outcome.v1"
+
+	schemaDecisionRequest = "faas-idler.decision_request.v1"
+)
+
+// eventSchemas are the JSON Schema documents served by the admin API
+var eventSchemas = map[string]string{
+	schemaNotification: `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "faas-idler.notification.v1",
+  "title": "Notification",
+  "description": "A decision, scale event, wake or scale error, sent to webhooks and written to the audit log",
+  "type": "object",
+  "required": ["schema", "kind", "gateway", "function", "replicas", "success", "timestamp"],
+  "properties": {
+    "schema": {"const": "faas-idler.notification.v1"},
+    "kind": {"enum": ["decision", "scale", "wake", "error"]},
+    "gateway": {"type": "string"},
+    "function": {"type": "string"},
+    "namespace": {"type": "string"},
+    "idle": {"type": "boolean", "description": "set on decisions"},
+    "invocation_rate": {"type": "number", "description": "set on decisions"},
+    "replicas": {"type": "integer", "minimum": 0, "description": "target of a scale event, or the replicas a function was woken to"},
+    "success": {"type": "boolean"},
+    "error": {"type": "string"},
+    "timestamp": {"type": "string", "format": "date-time"}
+  }
+}
+`,
+	schemaScaleAction: `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "faas-idler.scale_action.v1",
+  "title": "ScaleAction",
+  "description": "A pending scale event, sent to pre-scale hooks",
+  "type": "object",
+  "required": ["schema", "gateway", "function", "replicas", "target"],
+  "properties": {
+    "schema": {"const": "faas-idler.scale_action.v1"},
+    "gateway": {"type": "string"},
+    "function": {"type": "string"},
+    "namespace": {"type": "string"},
+    "replicas": {"type": "integer", "minimum": 0},
+    "target": {"type": "integer", "minimum": 0},
+    "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+    "annotations": {"type": "object", "additionalProperties": {"type": "string"}}
+  }
+}
+`,
+	schemaScaleOutcome: `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "faas-idler.scale_outcome.v1",
+  "title": "ScaleOutcome",
+  "description": "A completed scale event, sent to post-scale hooks",
+  "type": "object",
+  "required": ["schema", "gateway", "function", "replicas", "target", "success"],
+  "properties": {
+    "schema": {"const": "faas-idler.scale_outcome.v1"},
+    "gateway": {"type": "string"},
+    "function": {"type": "string"},
+    "namespace": {"type": "string"},
+    "replicas": {"type": "integer", "minimum": 0},
+    "target": {"type": "integer", "minimum": 0},
+    "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+    "annotations": {"type": "object", "additionalProperties": {"type": "string"}},
+    "success": {"type": "boolean"},
+    "error": {"type": "string"}
+  }
+}
+`,
+	schemaDecisionRequest: `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "faas-idler.decision_request.v1",
+  "title": "DecisionRequest",
+  "description": "A pending scale event, sent to decision_webhook_url",
+  "type": "object",
+  "required": ["schema", "gateway", "function", "replicas", "target", "invocation_rate"],
+  "properties": {
+    "schema": {"const": "faas-idler.decision_request.v1"},
+    "gateway": {"type": "string"},
+    "function": {"type": "string"},
+    "namespace": {"type": "string"},
+    "replicas": {"type": "integer", "minimum": 0},
+    "target": {"type": "integer", "minimum": 0},
+    "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+    "annotations": {"type": "object", "additionalProperties": {"type": "string"}},
+    "invocation_rate": {"type": "number"}
+  }
+}
+`,
+}
+
+const cloudEventsContentType = "application/cloudevents+json"
+
+// CloudEvent is a notification wrapped in a CloudEvents 1.0 envelope, sent
+// to webhooks with notify_format=cloudevents
+type CloudEvent struct {
+	SpecVersion     string       `json:"specversion"`
+	ID              string       `json:"id"`
+	Source          string       `json:"source"`
+	Type            string       `json:"type"`
+	Time            time.Time    `json:"time"`
+	DataContentType string       `json:"datacontenttype"`
+	Subject         string       `json:"subject"`
+	Data            Notification `json:"data"`
+}
+
+func newCloudEvent(notification Notification) CloudEvent {
+	return CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              fmt.Sprintf("%016x", rand.Int63()),
+		Source:          "faas-idler/" + notification.Gateway,
+		Type:            "com.openfaas.idler." + notification.Kind + ".v1",
+		Time:            notification.Timestamp,
+		DataContentType: "application/json",
+		Subject:         notification.Function,
+		Data:            notification,
+	}
+}
+
+// makeSchemasHandler lists the event schemas on /api/schemas and serves
+// each of them on /api/schemas/<name>
+func makeSchemasHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/schemas"), "/")
+		if len(name) == 0 {
+			names := []string{}
+			for schema := range eventSchemas {
+				names = append(names, schema)
+			}
+			sort.Strings(names)
+			writeJSON(w, http.StatusOK, names)
+			return
+		}
+
+		schema, exists := eventSchemas[name]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Write([]byte(schema))
+	}
+}
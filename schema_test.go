@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// jsonFields lists the JSON names of a struct's fields, including those of
+// embedded structs
+func jsonFields(t reflect.Type) []string {
+	fields := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			fields = append(fields, jsonFields(field.Type)...)
+			continue
+		}
+		if name := strings.Split(field.Tag.Get("json"), ",")[0]; len(name) > 0 && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// Test_eventSchemas_matchPayloads fails when a payload field is added,
+// renamed or removed without updating its schema
+func Test_eventSchemas_matchPayloads(t *testing.T) {
+	payloads := map[string]interface{}{
+		schemaNotification: Notification{},
+		schemaScaleAction:  ScaleAction{},
+		schemaScaleOutcome: ScaleOutcome{},
+
+		schemaDecisionRequest: DecisionRequest{},
+	}
+
+	for name, payload := range payloads {
+		schema := struct {
+			ID         string                     `json:"$id"`
+			Required   []string                   `json:"required"`
+			Properties map[string]json.RawMessage `json:"properties"`
+		}{}
+		if err := json.Unmarshal([]byte(eventSchemas[name]), &schema); err != nil {
+			t.Fatalf("%s is not valid JSON: %s", name, err)
+		}
+		if schema.ID != name {
+			t.Errorf("%s has $id %s", name, schema.ID)
+		}
+
+		fields := jsonFields(reflect.TypeOf(payload))
+		if len(fields) != len(schema.Properties) {
+			t.Errorf("%s has %d properties, its payload %d fields", name, len(schema.Properties), len(fields))
+		}
+		for _, field := range fields {
+			if _, exists := schema.Properties[field]; !exists {
+				t.Errorf("%s does not describe field %s", name, field)
+			}
+		}
+	}
+}
+
+func Test_makeSchemasHandler(t *testing.T) {
+	handler := makeSchemasHandler()
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/api/schemas", nil))
+	names := []string{}
+	json.Unmarshal(rr.Body.Bytes(), &names)
+	if len(names) != len(eventSchemas) {
+		t.Errorf("Schemas wanted: %d got: %v", len(eventSchemas), names)
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/api/schemas/"+schemaNotification, nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != eventSchemas[schemaNotification] {
+		t.Errorf("Unexpected schema response %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/api/schemas/unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Status wanted: %d got: %d", http.StatusNotFound, rr.Code)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/types"
+)
+
+// activitySignal is read, beside the invocation rate, before a function is
+// idled
+type activitySignal struct {
+	// skip is the reason a function busy on the signal is skipped
+	skip string
+	// read returns the signal of a function and whether it is idle
+	read func(ctx context.Context, client *http.Client, fn Function, window time.Duration, config types.Config) (float64, bool, error)
+}
+
+// activitySignals holds every signal of idle_signals but invocations, which
+// are already known from the metrics provider
+var activitySignals = map[string]activitySignal{
+	types.SignalInflight: {
+		skip: skipInflight,
+		read: func(ctx context.Context, client *http.Client, fn Function, window time.Duration, config types.Config) (float64, bool, error) {
+			inflight, err := queryInflight(ctx, client, fn, config)
+			return inflight, inflight <= 0, err
+		},
+	},
+	types.SignalCPU: {
+		skip: skipCPU,
+		read: func(ctx context.Context, client *http.Client, fn Function, window time.Duration, config types.Config) (float64, bool, error) {
+			cpu, err := queryCPU(ctx, client, fn, window, config)
+			return cpu, cpu <= config.CPUThreshold, err
+		},
+	},
+	types.SignalNATS: {
+		skip: skipNATS,
+		read: func(ctx context.Context, client *http.Client, fn Function, window time.Duration, config types.Config) (float64, bool, error) {
+			waiting, err := queryNATS(ctx, fn, config)
+			return waiting, waiting <= 0, err
+		},
+	},
+	types.SignalKafka: {
+		skip: skipKafka,
+		read: func(ctx context.Context, client *http.Client, fn Function, window time.Duration, config types.Config) (float64, bool, error) {
+			lag, err := queryKafkaLag(ctx, fn, config)
+			return lag, lag <= 0, err
+		},
+	},
+	types.SignalQueue: {
+		skip: skipQueue,
+		read: func(ctx context.Context, client *http.Client, fn Function, window time.Duration, config types.Config) (float64, bool, error) {
+			queued, _, err := queryValue(ctx, client, queueQuery(fn, config), config)
+			return queued, queued <= 0, err
+		},
+	},
+}
+
+// queueQuery renders queue_query for a function, $function and $namespace
+// are replaced by its name and namespace
+func queueQuery(fn Function, config types.Config) string {
+	query := strings.Replace(config.QueueQuery, "$function", fn.Name, -1)
+	return strings.Replace(query, "$namespace", fn.Namespace, -1)
+}
+
+// vetoSignals are read once more right before an idle function is scaled:
+// in-flight requests with require_zero_inflight, and asynchronous requests
+// waiting for it with require_empty_async_backlog
+func vetoSignals(config types.Config) []string {
+	signals := []string{}
+	if config.RequireZeroInflight {
+		signals = append(signals, types.SignalInflight)
+	}
+	if config.RequireEmptyAsyncBacklog {
+		if len(config.QueueQuery) > 0 {
+			signals = append(signals, types.SignalQueue)
+		}
+		if len(config.NATSMonitorURL) > 0 {
+			signals = append(signals, types.SignalNATS)
+		}
+	}
+	return signals
+}
+
+// idleSignals reads the idle_signals of a function in order, v is its
+// invocation rate, idle when at most threshold. With all_idle the first busy signal decides, with
+// any_idle the first idle one, and the signals after it are not queried.
+// signal names the deciding signal, or the one which failed on an error,
+// and value is its reading.
+func idleSignals(ctx context.Context, client *http.Client, fn Function, v float64, threshold float64, window time.Duration, config types.Config) (idle bool, signal string, value float64, err error) {
+	signals := config.IdleSignals
+	if len(signals) == 0 {
+		signals = []string{types.SignalInvocations}
+	}
+	anyIdle := config.IdleCombinator == types.CombinatorAnyIdle
+
+	first := float64(0)
+	for i, name := range signals {
+		value, idle := v, v <= threshold
+		if name != types.SignalInvocations {
+			var readErr error
+			value, idle, readErr = activitySignals[name].read(ctx, client, fn, window, config)
+			if readErr != nil {
+				return false, name, 0, readErr
+			}
+		}
+		if i == 0 {
+			first = value
+		}
+		if idle == anyIdle {
+			return idle, name, value, nil
+		}
+	}
+
+	// No signal decided, all of them are idle, or with any_idle all of them
+	// are busy and the first is reported
+	if anyIdle {
+		return false, signals[0], first, nil
+	}
+	return true, "", 0, nil
+}
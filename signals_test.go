@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_idleSignals(t *testing.T) {
+	queries := []string{}
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		queries = append(queries, query)
+
+		value := "0"
+		if query == "cpu" {
+			value = "0.5"
+		}
+		w.Write([]byte(`{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1546300800, "` + value + `"]}]}}`))
+	}))
+	defer prometheus.Close()
+
+	parsed, _ := url.Parse(prometheus.URL)
+	host, portVal, _ := net.SplitHostPort(parsed.Host)
+	port, _ := strconv.Atoi(portVal)
+	client := newHeaderClient("", nil, nil)
+	fn := Function{Name: "figlet"}
+
+	config := types.Config{
+		PrometheusHost: host,
+		PrometheusPort: port,
+		InflightQuery:  "inflight",
+		CPUQuery:       "cpu",
+		CPUThreshold:   0.01,
+		QueueQuery:     "queue",
+	}
+
+	cases := []struct {
+		name       string
+		signals    []string
+		combinator string
+		rate       float64
+		idle       bool
+		signal     string
+		queries    int
+	}{
+		{"invocations by default", nil, "", 0, true, "", 0},
+		{"active invocations skip the other signals", []string{"invocations", "cpu"}, types.CombinatorAllIdle, 1, false, "invocations", 0},
+		{"all_idle stops at the first busy signal", []string{"invocations", "inflight", "cpu", "queue"}, types.CombinatorAllIdle, 0, false, "cpu", 2},
+		{"all_idle with every signal idle", []string{"invocations", "inflight", "queue"}, types.CombinatorAllIdle, 0, true, "", 2},
+		{"any_idle stops at the first idle signal", []string{"cpu", "queue", "inflight"}, types.CombinatorAnyIdle, 1, true, "queue", 2},
+		{"any_idle with every signal busy", []string{"cpu", "invocations"}, types.CombinatorAnyIdle, 1, false, "cpu", 1},
+	}
+
+	for _, c := range cases {
+		queries = queries[:0]
+		config.IdleSignals = c.signals
+		config.IdleCombinator = c.combinator
+
+		idle, signal, _, err := idleSignals(context.Background(), client, fn, c.rate, 0, time.Minute, config)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", c.name, err)
+		}
+		if idle != c.idle || signal != c.signal || len(queries) != c.queries {
+			t.Errorf("%s: wanted idle: %t signal: %q queries: %d got: %t %q %v", c.name, c.idle, c.signal, c.queries, idle, signal, queries)
+		}
+	}
+
+	config.IdleSignals = nil
+	if idle, _, _, _ := idleSignals(context.Background(), client, fn, 0.005, 0.01, time.Minute, config); !idle {
+		t.Errorf("A rate below idle_threshold should be idle")
+	}
+	if idle, _, _, _ := idleSignals(context.Background(), client, fn, 0.02, 0.01, time.Minute, config); idle {
+		t.Errorf("A rate above idle_threshold should be busy")
+	}
+}
+
+func Test_vetoSignals(t *testing.T) {
+	if signals := vetoSignals(types.Config{}); len(signals) != 0 {
+		t.Errorf("No signal should be read again by default, got: %v", signals)
+	}
+
+	config := types.Config{
+		RequireZeroInflight:      true,
+		RequireEmptyAsyncBacklog: true,
+		QueueQuery:               "queue",
+		NATSMonitorURL:           "http://nats.openfaas:8222",
+	}
+	if signals := strings.Join(vetoSignals(config), ","); signals != "inflight,queue,nats" {
+		t.Errorf("Signals wanted: inflight,queue,nats got: %s", signals)
+	}
+}
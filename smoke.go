@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+// smokeStep is the outcome of one step of the smoke test
+type smokeStep struct {
+	Name    string  `json:"name"`
+	Passed  bool    `json:"passed"`
+	Seconds float64 `json:"seconds"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type smokeResult struct {
+	Gateway  string      `json:"gateway"`
+	Function string      `json:"function"`
+	Deployed bool        `json:"deployed"`
+	Passed   bool        `json:"passed"`
+	Steps    []smokeStep `json:"steps"`
+}
+
+// run records a step, later steps are only run while every earlier one
+// passed
+func (r *smokeResult) run(name string, step func() error) bool {
+	if !r.Passed {
+		return false
+	}
+
+	start := time.Now()
+	err := step()
+
+	result := smokeStep{Name: name, Passed: err == nil, Seconds: time.Since(start).Seconds()}
+	if err != nil {
+		result.Error = err.Error()
+		r.Passed = false
+	}
+	r.Steps = append(r.Steps, result)
+	return r.Passed
+}
+
+// runSmoke checks that the idler running against a gateway scales a function
+// to zero: it deploys a disposable function opted in to scale to zero, or
+// uses the one given, invokes it once so it has invocation metrics, then
+// waits for the idler to scale it and removes the function it deployed
+func runSmoke(config types.Config, args []string, out io.Writer) int {
+	targets := config.Targets()
+
+	flags := flag.NewFlagSet("smoke", flag.ContinueOnError)
+	gatewayName := flags.String("gateway", targets[0].Name, "name of the gateway to test, from gateways_file")
+	function := flags.String("function", "", "existing function to use instead of deploying one, it is not removed")
+	image := flags.String("image", "ghcr.io/openfaas/figlet:latest", "image of the function deployed")
+	timeout := flags.Duration("timeout", config.InactivityDuration+config.ReconcileInterval*2+time.Minute*2, "time allowed for the function to be scaled to zero")
+	poll := flags.Duration("poll", time.Second*5, "interval between checks of the function's replicas")
+	keep := flags.Bool("keep", false, "keep the deployed function")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	var gateway *Gateway
+	for _, target := range targets {
+		if target.Name == *gatewayName {
+			gateway = newGateway(target)
+		}
+	}
+	if gateway == nil {
+		fmt.Fprintf(os.Stderr, "smoke: unknown gateway: %s\n", *gatewayName)
+		return 1
+	}
+
+	ctx := context.Background()
+	gateway.checkHealth(ctx)
+
+	result := smokeResult{
+		Gateway:  gateway.Name,
+		Function: *function,
+		Passed:   true,
+	}
+
+	if len(result.Function) == 0 {
+		result.Function = fmt.Sprintf("faas-idler-smoke-%06x", rand.Intn(1<<24))
+		result.Deployed = result.run("deploy", func() error {
+			return deployFunction(ctx, gateway, result.Function, *image)
+		})
+	}
+
+	deadline := time.Now().Add(*timeout)
+	result.run("ready", func() error {
+		return waitReplicas(ctx, gateway, result.Function, deadline, *poll, func(fn *Function) bool {
+			return fn.AvailableReplicas > 0
+		})
+	})
+	result.run("invoke", func() error {
+		return invokeFunction(ctx, gateway, result.Function)
+	})
+	result.run("scaled to zero", func() error {
+		return waitReplicas(ctx, gateway, result.Function, deadline, *poll, func(fn *Function) bool {
+			return fn.Replicas == 0 && fn.AvailableReplicas == 0
+		})
+	})
+
+	// The function deployed is removed whether or not the test passed
+	if result.Deployed && !*keep {
+		passed := result.Passed
+		result.Passed = true
+		result.run("remove", func() error {
+			return removeFunction(ctx, gateway, result.Function)
+		})
+		result.Passed = passed && result.Passed
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		fmt.Fprintf(os.Stderr, "smoke: unable to write result: %s\n", err)
+	}
+
+	if !result.Passed {
+		return 1
+	}
+	return 0
+}
+
+func deployFunction(ctx context.Context, gateway *Gateway, name string, image string) error {
+	labels := map[string]string{scaleLabel: "true"}
+	body, _ := json.Marshal(requests.CreateFunctionRequest{
+		Service: name,
+		Image:   image,
+		Labels:  &labels,
+	})
+
+	return smokeRequest(ctx, gateway, http.MethodPost, gateway.URL()+"system/functions", body)
+}
+
+func removeFunction(ctx context.Context, gateway *Gateway, name string) error {
+	body, _ := json.Marshal(requests.DeleteFunctionRequest{FunctionName: name})
+
+	return smokeRequest(ctx, gateway, http.MethodDelete, gateway.URL()+"system/functions", body)
+}
+
+// invokeFunction calls the function once, so that Prometheus holds its
+// invocation metrics and it can be evaluated
+func invokeFunction(ctx context.Context, gateway *Gateway, name string) error {
+	return smokeRequest(ctx, gateway, http.MethodPost, gateway.URL()+"function/"+name, []byte("faas-idler"))
+}
+
+func smokeRequest(ctx context.Context, gateway *Gateway, method string, url string, body []byte) error {
+	requestCtx, cancel := withTimeout(ctx, gateway.Config.ScaleTimeout)
+	defer cancel()
+
+	req, _ := http.NewRequest(method, url, bytes.NewReader(body))
+	req = req.WithContext(requestCtx)
+	req.SetBasicAuth(gateway.Credentials.Username, gateway.Credentials.Password)
+
+	res, err := gateway.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return nil
+}
+
+// waitReplicas polls the function until done returns true or the deadline
+// passed, returning the last error seen then
+func waitReplicas(ctx context.Context, gateway *Gateway, name string, deadline time.Time, poll time.Duration, done func(*Function) bool) error {
+	lastErr := fmt.Errorf("timed out")
+	for {
+		replicasCtx, cancel := withTimeout(ctx, gateway.Config.GetReplicasTimeout)
+		fn, err := getReplicas(replicasCtx, gateway.Client, gateway.URL(), name, gateway.Credentials)
+		cancel()
+
+		if err == nil && done(fn) {
+			return nil
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("timed out, last error: %s", err)
+		} else {
+			lastErr = fmt.Errorf("timed out with %d replica(s), %d available", fn.Replicas, fn.AvailableReplicas)
+		}
+
+		if time.Now().Add(poll).After(deadline) {
+			return lastErr
+		}
+		time.Sleep(poll)
+	}
+}
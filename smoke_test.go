@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/types"
+)
+
+func Test_runSmoke(t *testing.T) {
+	var mu sync.Mutex
+	deployed, invoked, removed := "", false, false
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/system/functions" && r.Method == http.MethodPost:
+			create := requests.CreateFunctionRequest{}
+			json.NewDecoder(r.Body).Decode(&create)
+			if create.Labels == nil || (*create.Labels)[scaleLabel] != "true" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			deployed = create.Service
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/system/functions" && r.Method == http.MethodDelete:
+			removed = true
+		case strings.HasPrefix(r.URL.Path, "/function/"):
+			invoked = true
+		case r.URL.Path == "/system/function/"+deployed:
+			// Scaled to zero by the idler once invoked
+			replicas := uint64(1)
+			if invoked {
+				replicas = 0
+			}
+			json.NewEncoder(w).Encode(Function{Function: requests.Function{Name: deployed, Replicas: replicas, AvailableReplicas: replicas}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer gateway.Close()
+
+	config := types.Config{Name: "test", GatewayURL: gateway.URL + "/"}
+	out := &bytes.Buffer{}
+	if code := runSmoke(config, []string{"-poll", "1ms", "-timeout", "1s"}, out); code != 0 {
+		t.Errorf("Exit code wanted: %d got: %d\n%s", 0, code, out)
+	}
+
+	result := smokeResult{}
+	json.Unmarshal(out.Bytes(), &result)
+	if !result.Passed || !result.Deployed || len(result.Steps) != 5 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if !invoked || !removed {
+		t.Errorf("The function should be invoked and removed, invoked: %v removed: %v", invoked, removed)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+// functionStatus is what the idler last observed and recommended for a function
+type functionStatus struct {
+	Gateway   string                      `json:"gateway"`
+	Function  string                      `json:"function"`
+	Namespace string                      `json:"namespace,omitempty"`
+	Rate      float64                     `json:"invocation_rate"`
+	Replicas  uint64                      `json:"replicas"`
+	Requests  *requests.FunctionResources `json:"requests,omitempty"`
+	// Target is the idle target the function would be scaled down to
+	Target uint64 `json:"idle_target,omitempty"`
+
+	// Unknown is set when the function's activity could not be read
+	Unknown bool `json:"unknown,omitempty"`
+	// ScaleToZero is set when the function is idle and still has replicas
+	ScaleToZero bool      `json:"scale_to_zero"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// PendingScaleAt is when the function will be scaled once its drain
+	// delay has passed
+	PendingScaleAt *time.Time `json:"pending_scale_at,omitempty"`
+	// CooldownUntil is set while a function woken outside of the idler is
+	// left alone
+	CooldownUntil *time.Time `json:"cooldown_until,omitempty"`
+	// MarkedIdleAt is set on a function marked idle with confirm_scale_down,
+	// it is scaled when still idle on the next pass
+	MarkedIdleAt *time.Time `json:"marked_idle_at,omitempty"`
+
+	// ObservedSeconds and IdleReplicaSeconds accumulate over reconcile
+	// passes, the latter counting the replicas above the idle target of
+	// functions recommended to be scaled down
+	ObservedSeconds    float64 `json:"observed_seconds"`
+	IdleReplicaSeconds float64 `json:"idle_replica_seconds"`
+}
+
+// statusStore holds the latest functionStatus per function of a gateway,
+// nothing is kept when disabled, and the summary of its last reconcile pass
+type statusStore struct {
+	mu       sync.Mutex
+	items    *lruCache
+	disabled bool
+	cycle    *cycleSummary
+}
+
+// newStatusStore keeps up to maxEntries statuses updated within the ttl
+func newStatusStore(maxEntries int, ttl time.Duration) *statusStore {
+	items := newLRUCache(maxEntries, ttl)
+	items.onEvict = func(name string, value interface{}) {
+		recommendation.DeleteLabelValues(value.(functionStatus).Gateway, name)
+	}
+
+	return &statusStore{
+		items: items,
+	}
+}
+
+func (s *statusStore) set(status functionStatus) {
+	if s.disabled {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if value, exists := s.items.get(status.Function); exists {
+		previous := value.(functionStatus)
+		elapsed := status.UpdatedAt.Sub(previous.UpdatedAt).Seconds()
+
+		status.ObservedSeconds = previous.ObservedSeconds + elapsed
+		status.IdleReplicaSeconds = previous.IdleReplicaSeconds
+		if previous.ScaleToZero && previous.Replicas > previous.Target {
+			status.IdleReplicaSeconds += float64(previous.Replicas-previous.Target) * elapsed
+		}
+	}
+
+	s.items.set(status.Function, status)
+	recommendation.WithLabelValues(status.Gateway, status.Function).Set(boolToFloat(status.ScaleToZero))
+}
+
+// retain drops functions which were not listed by the gateway in the last
+// reconcile pass.
+func (s *statusStore) retain(names map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items.retain(names)
+}
+
+// list returns the statuses sorted by function name
+func (s *statusStore) list() []functionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]functionStatus, 0, s.items.len())
+	s.items.each(func(name string, value interface{}) {
+		list = append(list, value.(functionStatus))
+	})
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Function < list[j].Function
+	})
+	return list
+}
+
+// setCycle keeps the summary of a completed reconcile pass
+func (s *statusStore) setCycle(summary *cycleSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cycle = summary
+}
+
+// lastCycle returns the summary of the last reconcile pass, nil before the
+// first one completed
+func (s *statusStore) lastCycle() *cycleSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cycle
+}
+
+func boolToFloat(val bool) float64 {
+	if val {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// streakStore counts the consecutive reconcile passes each function was
+// observed idle, for required_idle_cycles
+type streakStore struct {
+	mu     sync.Mutex
+	cycles *lruCache
+}
+
+func newStreakStore(maxEntries int, ttl time.Duration) *streakStore {
+	return &streakStore{
+		cycles: newLRUCache(maxEntries, ttl),
+	}
+}
+
+// idle records a pass observing the function idle and returns how many
+// consecutive passes did so, this one included
+func (s *streakStore) idle(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cycles := 1
+	if value, exists := s.cycles.get(name); exists {
+		cycles += value.(int)
+	}
+	s.cycles.set(name, cycles)
+
+	return cycles
+}
+
+// reset ends the streak of a function, i.e. when it was active or its
+// activity could not be read
+func (s *streakStore) reset(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cycles.remove(name)
+}
+
+// retain drops functions which were not listed by the gateway in the last
+// reconcile pass
+func (s *streakStore) retain(names map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cycles.retain(names)
+}
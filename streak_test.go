@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func Test_streakStore(t *testing.T) {
+	streaks := newStreakStore(0, 0)
+
+	for want := 1; want <= 3; want++ {
+		if cycles := streaks.idle("figlet"); cycles != want {
+			t.Errorf("Cycles wanted: %d got: %d", want, cycles)
+		}
+	}
+
+	streaks.reset("figlet")
+	if cycles := streaks.idle("figlet"); cycles != 1 {
+		t.Errorf("A reset should start the streak over, got: %d", cycles)
+	}
+
+	streaks.retain(map[string]bool{})
+	if streaks.cycles.len() != 0 {
+		t.Errorf("Retain should drop unlisted functions, got: %v", streaks.cycles)
+	}
+}
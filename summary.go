@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Reasons a function was skipped in a reconcile pass
+const (
+	skipNotEnabled    = "not_enabled"
+	skipNever         = "never"
+	skipSnoozed       = "snoozed"
+	skipAutoscaled    = "autoscaled"
+	skipAutoscaleErr  = "autoscaler_error"
+	skipNoMetrics     = "no_metrics"
+	skipUnknown       = "unknown"
+	skipLongWindow    = "long_window"
+	skipDeadline      = "deadline"
+	skipInflight      = "inflight"
+	skipCPU           = "cpu"
+	skipQueue         = "queue"
+	skipNATS          = "nats"
+	skipKafka         = "kafka"
+	skipPrometheusGap = "prometheus_gap"
+	skipInactiveFor   = "inactive_for"
+	skipUpstream      = "upstream_active"
+	skipRecheck       = "recheck_active"
+	skipIdleCycles    = "idle_cycles"
+	skipNoReplicas    = "no_replicas"
+	skipCooldown      = "cooldown"
+	skipColdStarts    = "cold_start_budget"
+	skipExcluded      = "no_idle_schedule"
+	skipBlackout      = "blackout"
+	skipForecast      = "forecast"
+	skipMarked        = "marked_idle"
+	skipDraining      = "draining"
+	skipObserve       = "observe"
+	skipPaused        = "paused"
+	skipScaleLimit    = "scale_limit"
+	skipVetoed        = "vetoed"
+	skipDenied        = "denied"
+	skipDeferred      = "deferred"
+	skipHookTarget    = "hook_target"
+	skipReplicasError = "replicas_error"
+)
+
+// cycleSummary accounts for every function listed in a reconcile pass of a
+// gateway, it is logged as JSON at the end of the pass
+type cycleSummary struct {
+	Gateway   string    `json:"gateway"`
+	StartedAt time.Time `json:"started_at"`
+	Evaluated int       `json:"evaluated"`
+	Active    int       `json:"active"`
+	Idle      int       `json:"idle"`
+	Idled     int       `json:"idled"`
+	Prewarmed int       `json:"prewarmed"`
+	// Calendar is the holiday or blackout of the pass, i.e. holiday:Christmas
+	Calendar string `json:"calendar,omitempty"`
+	// Paused is set when pause_file suspended scale events for the pass
+	Paused bool `json:"paused,omitempty"`
+	// IdleCostPerHour prices the replicas of the functions found idle which
+	// the pass left running, by cost_per_cpu_hour and cost_per_gb_hour
+	IdleCostPerHour float64 `json:"idle_cost_per_hour,omitempty"`
+
+	// Skipped counts functions by the reason they were not scaled, Failed
+	// counts errors by stage and class, i.e. scale:http_5xx
+	Skipped map[string]int `json:"skipped"`
+	Failed  map[string]int `json:"failed"`
+
+	Durations cycleDurations `json:"durations"`
+
+	// scaleDowns counts the scale downs sent, successful or not, for
+	// max_scale_downs_per_cycle
+	scaleDowns int
+}
+
+// cycleDurations are in seconds
+type cycleDurations struct {
+	Total   float64 `json:"total"`
+	List    float64 `json:"list"`
+	Metrics float64 `json:"metrics"`
+}
+
+func newCycleSummary(gateway string, start time.Time) *cycleSummary {
+	return &cycleSummary{
+		Gateway:   gateway,
+		StartedAt: start,
+		Skipped:   make(map[string]int),
+		Failed:    make(map[string]int),
+	}
+}
+
+func (s *cycleSummary) skip(reason string) {
+	s.Skipped[reason]++
+}
+
+// scaleLimited is set once a pass sent max scale downs, zero for no limit
+func (s *cycleSummary) scaleLimited(max int) bool {
+	return max > 0 && s.scaleDowns >= max
+}
+
+func (s *cycleSummary) fail(stage string, err error) {
+	s.Failed[stage+":"+errorClass(err)]++
+}
+
+// failures is the total of Failed
+func (s *cycleSummary) failures() int {
+	total := 0
+	for _, count := range s.Failed {
+		total += count
+	}
+	return total
+}
+
+func (s *cycleSummary) log() {
+	bytesOut, _ := json.Marshal(s)
+	log.Printf("[%s] Reconcile summary: %s\n", s.Gateway, bytesOut)
+}
+
+// errorClass groups errors from the gateway, Prometheus and hooks
+func errorClass(err error) string {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+
+	switch e := err.(type) {
+	case scaleStatusError:
+		if e.code >= 500 {
+			return "http_5xx"
+		}
+		return "http_4xx"
+	case vetoError:
+		return "hook"
+	case net.Error:
+		if e.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+
+	if err == context.DeadlineExceeded {
+		return "timeout"
+	}
+	return "other"
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_errorClass(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 100)
+	}))
+	defer slow.Close()
+
+	_, timeoutErr := (&http.Client{Timeout: time.Millisecond}).Get(slow.URL)
+	_, networkErr := http.Get("http://127.0.0.1:1/")
+
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{scaleStatusError{name: "figlet", code: 503}, "http_5xx"},
+		{scaleStatusError{name: "figlet", code: 404}, "http_4xx"},
+		{vetoError{hook: "drain", reason: "denied"}, "hook"},
+		{timeoutErr, "timeout"},
+		{context.DeadlineExceeded, "timeout"},
+		{networkErr, "network"},
+		{errors.New("boom"), "other"},
+	}
+
+	for _, c := range cases {
+		if class := errorClass(c.err); class != c.want {
+			t.Errorf("%v: class wanted: %s got: %s", c.err, c.want, class)
+		}
+	}
+}
+
+func Test_cycleSummary(t *testing.T) {
+	summary := newCycleSummary("test", time.Now())
+	summary.skip(skipNotEnabled)
+	summary.skip(skipNotEnabled)
+	summary.fail("scale", scaleStatusError{name: "figlet", code: 503})
+	summary.fail("scale", scaleStatusError{name: "echo", code: 502})
+
+	if summary.Skipped[skipNotEnabled] != 2 {
+		t.Errorf("Skipped wanted: %d got: %d", 2, summary.Skipped[skipNotEnabled])
+	}
+	if summary.Failed["scale:http_5xx"] != 2 || summary.failures() != 2 {
+		t.Errorf("Failed wanted: %d got: %v", 2, summary.Failed)
+	}
+}
+
+func Test_cycleSummary_scaleLimited(t *testing.T) {
+	summary := newCycleSummary("test", time.Now())
+	summary.scaleDowns = 2
+
+	if summary.scaleLimited(0) {
+		t.Errorf("A limit of 0 should not limit scale downs")
+	}
+	if summary.scaleLimited(3) {
+		t.Errorf("2 scale downs should be within a limit of 3")
+	}
+	if !summary.scaleLimited(2) {
+		t.Errorf("2 scale downs should reach a limit of 2")
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/types"
+)
+
+// TelemetryReport is the anonymous usage report sent to telemetry_url. It
+// holds no names, URLs or labels: only the fleet size as a bucket, how the
+// idler is run and which features are configured.
+type TelemetryReport struct {
+	// InstanceID is random and changes on every start
+	InstanceID string   `json:"instance_id"`
+	GoVersion  string   `json:"go_version"`
+	OS         string   `json:"os"`
+	Arch       string   `json:"arch"`
+	Provider   string   `json:"provider"`
+	Gateways   int      `json:"gateways"`
+	FleetSize  string   `json:"fleet_size"`
+	Features   []string `json:"features"`
+}
+
+// telemetry reports once the first full reconcile pass sized the fleet and
+// then at each interval, it is only created when telemetry_url is set
+type telemetry struct {
+	url        string
+	interval   time.Duration
+	client     *http.Client
+	instanceID string
+	gateways   int
+	provider   string
+	features   []string
+
+	mu        sync.Mutex
+	functions int
+	recorded  chan struct{}
+	once      sync.Once
+}
+
+func newTelemetry(config types.Config, gateways int) *telemetry {
+	return &telemetry{
+		url:        config.TelemetryURL,
+		interval:   config.TelemetryInterval,
+		client:     &http.Client{Timeout: time.Second * 10},
+		instanceID: fmt.Sprintf("%016x", rand.Int63()),
+		gateways:   gateways,
+		provider:   config.MetricsProvider,
+		features:   telemetryFeatures(config),
+		recorded:   make(chan struct{}),
+	}
+}
+
+// record sizes the fleet from a full reconcile pass over every gateway
+func (t *telemetry) record(summaries []*cycleSummary) {
+	functions := 0
+	for _, summary := range summaries {
+		functions += summary.Evaluated
+	}
+
+	t.mu.Lock()
+	t.functions = functions
+	t.mu.Unlock()
+
+	t.once.Do(func() {
+		close(t.recorded)
+	})
+}
+
+func (t *telemetry) report() TelemetryReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return TelemetryReport{
+		InstanceID: t.instanceID,
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Provider:   t.provider,
+		Gateways:   t.gateways,
+		FleetSize:  fleetSizeBucket(t.functions),
+		Features:   t.features,
+	}
+}
+
+func (t *telemetry) run() {
+	<-t.recorded
+	for {
+		if err := t.send(t.report()); err != nil && writeDebug {
+			log.Printf("Unable to send telemetry: %s\n", err)
+		}
+		time.Sleep(t.interval)
+	}
+}
+
+func (t *telemetry) send(report TelemetryReport) error {
+	body, _ := json.Marshal(report)
+
+	res, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return nil
+}
+
+// fleetSizeBucket rounds the number of functions up to a power of ten
+func fleetSizeBucket(functions int) string {
+	switch {
+	case functions == 0:
+		return "0"
+	case functions <= 10:
+		return "1-10"
+	case functions <= 100:
+		return "11-100"
+	case functions <= 1000:
+		return "101-1000"
+	case functions <= 10000:
+		return "1001-10000"
+	}
+	return "10001+"
+}
+
+// telemetryFeatures lists the features configured, by the name of the flag
+// or setting enabling them
+func telemetryFeatures(config types.Config) []string {
+	features := []string{}
+	enabled := []struct {
+		name string
+		on   bool
+	}{
+		{"dry_run", dryRun},
+		{"observe", observe},
+		{"low_memory", config.LowMemory},
+		{"gateways_file", len(config.Gateways) > 0},
+		{"fallback_gateway_url", len(config.FallbackGatewayURL) > 0},
+		{"reconcile_schedule", len(config.ReconcileSchedule) > 0},
+		{"no_idle_schedule", len(config.NoIdleSchedule) > 0},
+		{"inactivity_schedule", len(config.InactivitySchedule) > 0},
+		{"calendar_file", len(config.CalendarFile) > 0},
+		{"max_reconcile_interval", config.MaxReconcileInterval > 0},
+		{"namespace_schedules", len(config.NamespaceSchedules) > 0},
+		{"tier_policies", len(config.TierPolicies) > 0},
+		{"namespace_policies_file", len(config.NamespacePolicies) > 0},
+		{"policy_git_url", len(config.PolicyGitURL) > 0},
+		{"idle_by_default", config.IdleByDefault},
+		{"drain_delay", config.DrainDelay > 0},
+		{"profile", len(config.Profile) > 0},
+		{"required_idle_cycles", config.RequiredIdleCycles > 1},
+		{"confirm_scale_down", config.ConfirmScaleDown},
+		{"flap_threshold", config.FlapThreshold > 0},
+		{"auto_tune", config.AutoTune},
+		{"max_cold_starts_per_hour", config.MaxColdStartsPerHour > 0},
+		{"max_scale_downs_per_cycle", config.MaxScaleDownsPerCycle > 0},
+		{"scale_down_priority", config.ScaleDownPriority == types.PriorityCost},
+		{"pause_file", len(config.PauseFile) > 0},
+		{"idle_threshold", config.IdleThreshold > 0},
+		{"scale_target", config.ScaleTarget > 0},
+		{"scale_down_steps", len(config.ScaleDownSteps) > 0},
+		{"scale_up_cooldown", config.ScaleUpCooldown > 0},
+		{"recheck_window", config.RecheckWindow > 0},
+		{"require_zero_inflight", config.RequireZeroInflight},
+		{"require_idle_cpu", config.RequireIdleCPU},
+		{"require_empty_async_backlog", config.RequireEmptyAsyncBacklog},
+		{"queue_query", len(config.QueueQuery) > 0},
+		{"include_async", config.IncludeAsync},
+		{"nats_monitor_url", len(config.NATSMonitorURL) > 0},
+		{"kafka_admin_url", len(config.KafkaAdminURL) > 0},
+		{"any_idle", config.IdleCombinator == types.CombinatorAnyIdle},
+		{"hooks_file", len(config.PreScaleHooks)+len(config.PostScaleHooks) > 0},
+		{"decision_webhook_url", len(config.DecisionWebhookURL) > 0},
+		{"opa_url", len(config.OPAURL) > 0},
+		{"kubernetes_autoscalers", config.KubernetesAutoscalers},
+		{"replicas_configmap", len(config.ReplicasConfigMap) > 0},
+		{"restore_replicas", config.RestoreReplicas},
+		{"notify_routes_file", len(config.NotifyRoutes) > 0},
+		{"notify_webhook_url", len(config.NotifyWebhookURL) > 0},
+		{"slack_token", len(config.SlackToken) > 0},
+		{"smtp_host", len(config.SMTPHost) > 0},
+		{"activity_snapshot_file", len(config.ActivitySnapshotFile) > 0},
+		{"query_chunk", config.QueryChunk > 0},
+		{"query_resolution", config.QueryResolution > 0},
+		{"query_offset", config.QueryOffset > 0},
+		{"metrics_window", config.MetricsWindow > 0},
+		{"invocation_count_fallback", config.InvocationCountFallback},
+		{"query_cache_ttl", config.QueryCacheTTL > 0},
+		{"use_recording_rules", config.UseRecordingRules},
+		{"range_queries", config.RangeQueries},
+		{"predictive_idling", config.PredictiveIdling},
+		{"prometheus_query_template", config.PrometheusQueryTemplate != nil},
+		{"long_window_cap", config.LongWindow == types.LongWindowCap},
+	}
+
+	for _, feature := range enabled {
+		if feature.on {
+			features = append(features, feature.name)
+		}
+	}
+	return features
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_fleetSizeBucket(t *testing.T) {
+	cases := map[int]string{0: "0", 1: "1-10", 10: "1-10", 11: "11-100", 1000: "101-1000", 5000: "1001-10000", 20000: "10001+"}
+	for functions, want := range cases {
+		if got := fleetSizeBucket(functions); got != want {
+			t.Errorf("Bucket of %d wanted: %s got: %s", functions, want, got)
+		}
+	}
+}
+
+func Test_telemetry_send(t *testing.T) {
+	received := make(chan TelemetryReport, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := TelemetryReport{}
+		json.NewDecoder(r.Body).Decode(&report)
+		received <- report
+	}))
+	defer collector.Close()
+
+	config := types.Config{
+		TelemetryURL:      collector.URL,
+		TelemetryInterval: time.Hour,
+		MetricsProvider:   types.MetricsProviderPrometheus,
+		DrainDelay:        time.Second,
+		QueryChunk:        time.Hour,
+	}
+	usage := newTelemetry(config, 2)
+	usage.record([]*cycleSummary{{Evaluated: 40}, {Evaluated: 30}})
+
+	if err := usage.send(usage.report()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	report := <-received
+	if report.Gateways != 2 || report.FleetSize != "11-100" || report.Provider != "prometheus" {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if want := []string{"drain_delay", "query_chunk"}; !reflect.DeepEqual(report.Features, want) {
+		t.Errorf("Features wanted: %v got: %v", want, report.Features)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/types"
+)
+
+// defaultUserAgent replaces Go's default User-Agent, which some WAFs block
+const defaultUserAgent = "faas-idler"
+
+// headerTransport sets the User-Agent and extra headers on every request,
+// headers already set on the request are kept
+type headerTransport struct {
+	userAgent string
+	headers   map[string]string
+	next      http.RoundTripper
+}
+
+func newHeaderClient(userAgent string, headers map[string]string, tlsConfig *tls.Config) *http.Client {
+	if len(userAgent) == 0 {
+		userAgent = defaultUserAgent
+	}
+	return &http.Client{
+		Transport: &headerTransport{
+			userAgent: userAgent,
+			headers:   headers,
+			next:      newTransport(tlsConfig),
+		},
+	}
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it was given
+	clone := *req
+	clone.Header = make(http.Header, len(req.Header)+len(t.headers)+1)
+	for key, values := range req.Header {
+		clone.Header[key] = append([]string(nil), values...)
+	}
+
+	for key, value := range t.headers {
+		if len(clone.Header.Get(key)) == 0 {
+			clone.Header.Set(key, value)
+		}
+	}
+	if len(clone.Header.Get("User-Agent")) == 0 {
+		clone.Header.Set("User-Agent", t.userAgent)
+	}
+
+	return t.next.RoundTrip(&clone)
+}
+
+// doRequest sends the request and returns the body of a 200 response
+func doRequest(ctx context.Context, client *http.Client, req *http.Request, service string) ([]byte, error) {
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	bytesOut, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status code from %s want: %d, got: %d, body: %s", service, http.StatusOK, res.StatusCode, string(bytesOut))
+	}
+	return bytesOut, nil
+}
+
+// prometheusHeaders adds the Authorization for prometheus_bearer_token or
+// prometheus_username and prometheus_password, and the X-Scope-OrgID for
+// prometheus_tenant, to prometheus_headers
+func prometheusHeaders(config types.Config) map[string]string {
+	set := make(map[string]string)
+	switch {
+	case len(config.PrometheusBearerToken) > 0:
+		set["Authorization"] = "Bearer " + config.PrometheusBearerToken
+	case len(config.PrometheusUsername) > 0:
+		credentials := config.PrometheusUsername + ":" + config.PrometheusPassword
+		set["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+	if len(config.PrometheusTenant) > 0 {
+		set["X-Scope-Orgid"] = config.PrometheusTenant
+	}
+	if len(set) == 0 {
+		return config.PrometheusHeaders
+	}
+
+	headers := make(map[string]string, len(config.PrometheusHeaders)+len(set))
+	for key, value := range config.PrometheusHeaders {
+		if _, exists := set[http.CanonicalHeaderKey(key)]; !exists {
+			headers[key] = value
+		}
+	}
+	for key, value := range set {
+		headers[key] = value
+	}
+	return headers
+}
+
+// credentialClient requests credentials and access tokens for the metrics
+// backend, bounded by prometheus_timeout so that an unreachable identity
+// endpoint can't stall a pass
+func credentialClient(config types.Config) *http.Client {
+	client := newHeaderClient(config.UserAgent, nil, nil)
+	client.Timeout = config.PrometheusTimeout
+	return client
+}
+
+// tokenSource returns an access token and when it expires
+type tokenSource interface {
+	token(ctx context.Context) (string, time.Time, error)
+}
+
+// prometheusTokenSource is the source of prometheus_token_provider, nil
+// when unset
+func prometheusTokenSource(config types.Config) tokenSource {
+	client := credentialClient(config)
+
+	switch config.PrometheusTokenProvider {
+	case types.TokenProviderAzure:
+		scope := config.PrometheusTokenScope
+		if len(scope) == 0 {
+			scope = types.DefaultAzureTokenScope
+		}
+		return newAzureTokenSource(client, scope)
+	case types.TokenProviderGoogle:
+		return newGoogleTokenSource(client, config.PrometheusTokenScope)
+	}
+	return nil
+}
+
+// tokenRefresh renews an access token this long before it expires
+const tokenRefresh = time.Minute * 5
+
+// tokenTransport sends an access token from the source with every request,
+// as a bearer token replacing any Authorization header
+type tokenTransport struct {
+	source  tokenSource
+	next    http.RoundTripper
+	mu      sync.Mutex
+	cached  string
+	expires time.Time
+}
+
+func (t *tokenTransport) get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.cached) > 0 && time.Until(t.expires) > tokenRefresh {
+		return t.cached, nil
+	}
+
+	token, expires, err := t.source.token(ctx)
+	if err != nil {
+		return "", err
+	}
+	t.cached, t.expires = token, expires
+	return token, nil
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.get(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	// A RoundTripper must not modify the request it was given
+	clone := *req
+	clone.Header = make(http.Header, len(req.Header)+1)
+	for key, values := range req.Header {
+		clone.Header[key] = append([]string(nil), values...)
+	}
+	clone.Header.Set("Authorization", "Bearer "+token)
+
+	return t.next.RoundTrip(&clone)
+}
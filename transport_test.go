@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/types"
+)
+
+func Test_headerTransport(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer server.Close()
+
+	client := newHeaderClient("", map[string]string{"X-Team": "platform", "Authorization": "Bearer default"}, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Authorization", "Bearer own")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if ua := received.Get("User-Agent"); ua != defaultUserAgent {
+		t.Errorf("User-Agent wanted: %s got: %s", defaultUserAgent, ua)
+	}
+	if team := received.Get("X-Team"); team != "platform" {
+		t.Errorf("X-Team wanted: %s got: %s", "platform", team)
+	}
+	if auth := received.Get("Authorization"); auth != "Bearer own" {
+		t.Errorf("Authorization wanted: %s got: %s", "Bearer own", auth)
+	}
+	if len(req.Header.Get("X-Team")) > 0 {
+		t.Errorf("The original request should not be modified")
+	}
+}
+
+func Test_prometheusHeaders(t *testing.T) {
+	headers := prometheusHeaders(types.Config{
+		PrometheusHeaders:  map[string]string{"X-Scope-OrgID": "tenant", "authorization": "Bearer stale"},
+		PrometheusUsername: "idler",
+		PrometheusPassword: "secret",
+	})
+	if auth := headers["Authorization"]; auth != "Basic aWRsZXI6c2VjcmV0" || len(headers) != 2 || headers["X-Scope-OrgID"] != "tenant" {
+		t.Errorf("Basic auth should replace the Authorization header, got: %v", headers)
+	}
+
+	headers = prometheusHeaders(types.Config{PrometheusBearerToken: "token"})
+	if auth := headers["Authorization"]; auth != "Bearer token" {
+		t.Errorf("Authorization wanted: %s got: %s", "Bearer token", auth)
+	}
+
+	headers = prometheusHeaders(types.Config{PrometheusTenant: "team-a", PrometheusHeaders: map[string]string{"x-scope-orgid": "stale"}})
+	if len(headers) != 1 || headers[http.CanonicalHeaderKey("X-Scope-OrgID")] != "team-a" {
+		t.Errorf("The tenant should replace X-Scope-OrgID, got: %v", headers)
+	}
+
+	if headers = prometheusHeaders(types.Config{}); len(headers) != 0 {
+		t.Errorf("No Authorization should be added without credentials, got: %v", headers)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Bounds of the factor auto_tune applies to a function's inactivity
+// duration, and the step it moves by
+const (
+	tuneStep      = 1.5
+	tuneMaxFactor = 8
+	tuneMinFactor = 0.25
+)
+
+// tuneStore learns with auto_tune a factor on the inactivity duration of each
+// function: woken within its inactivity duration of being idled it was idled
+// too early and the factor grows, asleep for auto_tune_long_sleep it could
+// have been idled sooner and the factor shrinks
+type tuneStore struct {
+	mu        sync.Mutex
+	functions *lruCache
+	dirty     bool
+}
+
+// tunedFunction is the learned state of a function, as persisted to
+// auto_tune_file and listed by /api/tuning
+type tunedFunction struct {
+	Factor float64 `json:"factor"`
+	// InactivitySeconds is the inactivity duration last applied
+	InactivitySeconds float64    `json:"inactivity_seconds"`
+	IdledAt           *time.Time `json:"idled_at,omitempty"`
+	WokenAt           *time.Time `json:"woken_at,omitempty"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+func newTuneStore(maxEntries int, ttl time.Duration) *tuneStore {
+	return &tuneStore{
+		functions: newLRUCache(maxEntries, ttl),
+	}
+}
+
+func (s *tuneStore) get(name string) tunedFunction {
+	if value, exists := s.functions.get(name); exists {
+		return value.(tunedFunction)
+	}
+	return tunedFunction{Factor: 1}
+}
+
+// idled records that the idler scaled a function down
+func (s *tuneStore) idled(name string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tuned := s.get(name)
+	tuned.IdledAt, tuned.WokenAt = &now, nil
+	s.functions.set(name, tuned)
+	s.dirty = true
+}
+
+// woken records that a function the idler scaled down was woken
+func (s *tuneStore) woken(name string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tuned := s.get(name)
+	if tuned.IdledAt == nil || tuned.WokenAt != nil {
+		return
+	}
+	tuned.WokenAt = &now
+	s.functions.set(name, tuned)
+	s.dirty = true
+}
+
+// apply learns from the last time the function was idled and returns its
+// inactivity duration
+func (s *tuneStore) apply(name string, inactivity time.Duration, longSleep time.Duration, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tuned := s.get(name)
+	learned := time.Duration(float64(inactivity) * tuned.Factor)
+
+	switch {
+	case tuned.IdledAt != nil && tuned.WokenAt != nil:
+		if tuned.WokenAt.Sub(*tuned.IdledAt) < learned {
+			tuned.Factor = math.Min(tuned.Factor*tuneStep, tuneMaxFactor)
+		}
+		tuned.IdledAt, tuned.WokenAt = nil, nil
+		tuned.UpdatedAt = now
+		s.dirty = true
+	case tuned.IdledAt != nil && now.Sub(*tuned.IdledAt) >= longSleep:
+		// Still asleep, it is shortened again after another long sleep
+		tuned.Factor = math.Max(tuned.Factor/tuneStep, tuneMinFactor)
+		tuned.IdledAt = &now
+		tuned.UpdatedAt = now
+		s.dirty = true
+	}
+
+	learned = time.Duration(float64(inactivity) * tuned.Factor)
+	tuned.InactivitySeconds = learned.Seconds()
+	// Set every pass, learned factors do not expire while a function is listed
+	s.functions.set(name, tuned)
+	return learned
+}
+
+// retain drops functions which were not listed by the gateway in the last
+// reconcile pass
+func (s *tuneStore) retain(names map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.functions.retain(names)
+}
+
+func (s *tuneStore) snapshot() map[string]tunedFunction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make(map[string]tunedFunction, s.functions.len())
+	s.functions.each(func(key string, value interface{}) {
+		snapshot[key] = value.(tunedFunction)
+	})
+	return snapshot
+}
+
+func (s *tuneStore) restore(snapshot map[string]tunedFunction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, tuned := range snapshot {
+		if tuned.Factor > 0 {
+			s.functions.set(name, tuned)
+		}
+	}
+}
+
+// tuningStatus is a function's learned state listed by /api/tuning
+type tuningStatus struct {
+	Gateway  string `json:"gateway"`
+	Function string `json:"function"`
+	tunedFunction
+}
+
+// list returns the learned state of every function, by name
+func (s *tuneStore) list(gateway string) []tuningStatus {
+	list := []tuningStatus{}
+	for name, tuned := range s.snapshot() {
+		list = append(list, tuningStatus{Gateway: gateway, Function: name, tunedFunction: tuned})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Function < list[j].Function
+	})
+	return list
+}
+
+// tuningSnapshot is the file written to auto_tune_file, the learned state of
+// each function by gateway name
+type tuningSnapshot map[string]map[string]tunedFunction
+
+// saveTuning writes the learned state of every gateway when any of it
+// changed, replacing the file only once it was written in full
+func saveTuning(path string, gateways []*Gateway) error {
+	dirty := false
+	for _, gateway := range gateways {
+		gateway.tuning.mu.Lock()
+		dirty = dirty || gateway.tuning.dirty
+		gateway.tuning.dirty = false
+		gateway.tuning.mu.Unlock()
+	}
+	if !dirty {
+		return nil
+	}
+
+	snapshot := tuningSnapshot{}
+	for _, gateway := range gateways {
+		snapshot[gateway.Name] = gateway.tuning.snapshot()
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadTuning restores the learned state of each gateway from the file, which
+// may not exist yet
+func loadTuning(path string, gateways []*Gateway) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	snapshot := tuningSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	for _, gateway := range gateways {
+		gateway.tuning.restore(snapshot[gateway.Name])
+	}
+	return nil
+}
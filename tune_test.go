@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_tuneStore(t *testing.T) {
+	tuning := newTuneStore(10, time.Hour*24*7)
+	start := time.Date(2019, time.April, 1, 8, 0, 0, 0, time.UTC)
+	inactivity := time.Minute * 10
+
+	if learned := tuning.apply("figlet", inactivity, time.Hour*24, start); learned != inactivity {
+		t.Errorf("A function never idled should keep its inactivity duration, got: %s", learned)
+	}
+
+	tuning.idled("figlet", start)
+	tuning.woken("figlet", start.Add(time.Minute*2))
+	if learned := tuning.apply("figlet", inactivity, time.Hour*24, start.Add(time.Minute*3)); learned != time.Minute*15 {
+		t.Errorf("Woken shortly after being idled should lengthen the duration, wanted: 15m got: %s", learned)
+	}
+	if learned := tuning.apply("figlet", inactivity, time.Hour*24, start.Add(time.Minute*4)); learned != time.Minute*15 {
+		t.Errorf("A wake should be learned from once, got: %s", learned)
+	}
+
+	tuning.idled("figlet", start.Add(time.Hour))
+	tuning.woken("figlet", start.Add(time.Hour*2))
+	if learned := tuning.apply("figlet", inactivity, time.Hour*24, start.Add(time.Hour*2)); learned != time.Minute*15 {
+		t.Errorf("Woken long after being idled should keep the duration, got: %s", learned)
+	}
+
+	tuning.idled("figlet", start.Add(time.Hour*3))
+	if learned := tuning.apply("figlet", inactivity, time.Hour*24, start.Add(time.Hour*27)); learned != time.Minute*10 {
+		t.Errorf("A long sleep should shorten the duration, wanted: 10m got: %s", learned)
+	}
+	if learned := tuning.apply("figlet", inactivity, time.Hour*24, start.Add(time.Hour*28)); learned != time.Minute*10 {
+		t.Errorf("A long sleep should shorten the duration once, got: %s", learned)
+	}
+
+	tuning.woken("env", start)
+	if learned := tuning.apply("env", inactivity, time.Hour*24, start); learned != inactivity {
+		t.Errorf("A wake without the idler scaling down should be ignored, got: %s", learned)
+	}
+}
+
+func Test_saveTuning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tuning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tuning.json")
+
+	gateway := newGateway(types.Config{Name: "prod"})
+	now := time.Now()
+	gateway.tuning.idled("figlet", now)
+	gateway.tuning.woken("figlet", now)
+	gateway.tuning.apply("figlet", time.Minute, time.Hour, now)
+
+	if err := saveTuning(path, []*Gateway{gateway}); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newGateway(types.Config{Name: "prod"})
+	if err := loadTuning(path, []*Gateway{restarted}); err != nil {
+		t.Fatal(err)
+	}
+	if learned := restarted.tuning.apply("figlet", time.Minute, time.Hour, now); learned != time.Second*90 {
+		t.Errorf("The learned factor should be restored, wanted: 1m30s got: %s", learned)
+	}
+
+	os.Remove(path)
+	if err := saveTuning(path, []*Gateway{gateway}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Nothing should be saved without changes")
+	}
+
+	server := httptest.NewServer(makeTuningHandler([]*Gateway{restarted}))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "?gateway=prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	list := []tuningStatus{}
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Function != "figlet" || list[0].Factor != 1.5 || list[0].InactivitySeconds != 90 {
+		t.Errorf("Unexpected tuning: %+v", list)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// wakeStore remembers the functions the idler scaled down so that one found
+// scaled back up without the idler, by an operator or the gateway's
+// scale-from-zero, is recorded as woken and left alone for a cooldown. It
+// also follows the replicas listed for every function, to find those scaled
+// up from zero which the idler did not scale down.
+type wakeStore struct {
+	mu       sync.Mutex
+	targets  *lruCache
+	cooldown *lruCache
+	replicas *lruCache
+}
+
+func newWakeStore(maxEntries int, ttl time.Duration) *wakeStore {
+	return &wakeStore{
+		targets:  newLRUCache(maxEntries, ttl),
+		cooldown: newLRUCache(maxEntries, ttl),
+		replicas: newLRUCache(maxEntries, ttl),
+	}
+}
+
+// scaled records the target the idler scaled a function to
+func (w *wakeStore) scaled(name string, target uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.targets.set(name, target)
+}
+
+// observe compares the replicas listed for a function to the target it was
+// scaled to, and starts its cooldown when it has been woken
+func (w *wakeStore) observe(name string, replicas uint64, now time.Time, cooldown time.Duration) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	value, exists := w.targets.get(name)
+	if !exists || replicas <= value.(uint64) {
+		return false
+	}
+
+	w.targets.remove(name)
+	w.extend(name, now.Add(cooldown))
+	return true
+}
+
+// scaledUp records the replicas listed for a function, and starts its
+// cooldown when it had floor replicas or less in the last reconcile pass
+// and has more now. A cooldown of zero only records the replicas.
+func (w *wakeStore) scaledUp(name string, replicas uint64, floor uint64, now time.Time, cooldown time.Duration) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	value, seen := w.replicas.get(name)
+	w.replicas.set(name, replicas)
+	if !seen || cooldown <= 0 || value.(uint64) > floor || replicas <= value.(uint64) {
+		return false
+	}
+
+	w.extend(name, now.Add(cooldown))
+	return true
+}
+
+// hold leaves a function alone until the given time, unless its cooldown
+// already lasts longer
+func (w *wakeStore) hold(name string, until time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.extend(name, until)
+}
+
+func (w *wakeStore) extend(name string, until time.Time) {
+	if value, exists := w.cooldown.get(name); exists && value.(time.Time).After(until) {
+		return
+	}
+	w.cooldown.set(name, until)
+}
+
+// coolingDown returns when a woken function may be idled again, if it is
+// still in its cooldown
+func (w *wakeStore) coolingDown(name string, now time.Time) (time.Time, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	value, exists := w.cooldown.get(name)
+	if !exists {
+		return time.Time{}, false
+	}
+
+	until := value.(time.Time)
+	if !now.Before(until) {
+		w.cooldown.remove(name)
+		return until, false
+	}
+	return until, true
+}
+
+// retain drops functions which were not listed by the gateway in the last
+// reconcile pass
+func (w *wakeStore) retain(names map[string]bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.targets.retain(names)
+	w.cooldown.retain(names)
+	w.replicas.retain(names)
+}
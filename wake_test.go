@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_wakeStore(t *testing.T) {
+	wakes := newWakeStore(0, 0)
+	now := time.Now()
+
+	if wakes.observe("figlet", 1, now, time.Minute) {
+		t.Errorf("A function the idler never scaled should not be woken")
+	}
+
+	wakes.scaled("figlet", 0)
+	if wakes.observe("figlet", 0, now, time.Minute) {
+		t.Errorf("A function still at its target should not be woken")
+	}
+
+	if !wakes.observe("figlet", 2, now, time.Minute) {
+		t.Errorf("A function above its target should be woken")
+	}
+	if wakes.observe("figlet", 2, now, time.Minute) {
+		t.Errorf("A wake should only be recorded once")
+	}
+
+	if until, cooling := wakes.coolingDown("figlet", now.Add(time.Second*30)); !cooling || !until.Equal(now.Add(time.Minute)) {
+		t.Errorf("Cooldown wanted until: %s got: %s %v", now.Add(time.Minute), until, cooling)
+	}
+	if _, cooling := wakes.coolingDown("figlet", now.Add(time.Minute)); cooling {
+		t.Errorf("Cooldown should end after its duration")
+	}
+}
+
+func Test_wakeStore_scaledUp(t *testing.T) {
+	wakes := newWakeStore(0, 0)
+	now := time.Now()
+
+	if wakes.scaledUp("figlet", 1, 0, now, time.Minute) {
+		t.Errorf("A function listed for the first time should not be scaled up")
+	}
+	if wakes.scaledUp("figlet", 0, 0, now, time.Minute) {
+		t.Errorf("A function scaled down should not be scaled up")
+	}
+	if !wakes.scaledUp("figlet", 2, 0, now, time.Minute) {
+		t.Errorf("A function scaled up from zero should be scaled up")
+	}
+	if _, cooling := wakes.coolingDown("figlet", now.Add(time.Second*30)); !cooling {
+		t.Errorf("A function scaled up should cool down")
+	}
+	if wakes.scaledUp("figlet", 3, 0, now, time.Minute) {
+		t.Errorf("A function scaled up from replicas above the floor should not be scaled up")
+	}
+
+	wakes.scaledUp("nodeinfo", 1, 1, now, time.Minute)
+	if !wakes.scaledUp("nodeinfo", 2, 1, now, time.Minute) {
+		t.Errorf("A function scaled up from scale_target should be scaled up")
+	}
+
+	wakes.scaledUp("env", 0, 0, now, 0)
+	if wakes.scaledUp("env", 1, 0, now, 0) {
+		t.Errorf("A cooldown of zero should only record the replicas")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/types"
+)
+
+// prometheusHistory returns how far back Prometheus holds data, bounded by
+// its retention, zero when prometheus_history_query is empty or returned
+// nothing
+func prometheusHistory(ctx context.Context, gateway *Gateway) time.Duration {
+	return queryDuration(ctx, gateway, gateway.Config.PrometheusHistoryQuery, "history")
+}
+
+// queryDuration runs a query returning seconds, zero when the query is empty,
+// failed or returned nothing, or when activity is not read from Prometheus
+func queryDuration(ctx context.Context, gateway *Gateway, promQL string, what string) time.Duration {
+	if len(promQL) == 0 || !readsPrometheus(gateway.Config) {
+		return 0
+	}
+
+	seconds, found, err := queryValue(ctx, gateway.PrometheusClient, promQL, gateway.Config)
+	if err != nil {
+		log.Printf("[%s] Unable to query Prometheus %s: %s\n", gateway.Name, what, err)
+		return 0
+	}
+	if !found || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// metricsWindow returns the window a function's rate is evaluated over, its
+// inactivity duration unless metrics_window is shorter
+func metricsWindow(policy Policy, config types.Config) time.Duration {
+	if config.MetricsWindow > 0 && config.MetricsWindow < policy.InactivityDuration {
+		return config.MetricsWindow
+	}
+	return policy.InactivityDuration
+}
+
+// queryWindow returns the window a function's activity is evaluated over, its
+// metrics window unless Prometheus holds less data than that. Such a
+// window is capped to the data held with long_window=cap, otherwise the
+// function cannot be evaluated. An unknown history of zero is not checked.
+func queryWindow(policy Policy, history time.Duration, config types.Config) (time.Duration, bool) {
+	window := metricsWindow(policy, config)
+	if history <= 0 || window <= history {
+		return window, true
+	}
+
+	if config.LongWindow == types.LongWindowCap {
+		return history.Truncate(time.Second), true
+	}
+	return 0, false
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/types"
+)
+
+func Test_queryWindow(t *testing.T) {
+	policy := Policy{InactivityDuration: time.Hour * 24 * 7}
+
+	cases := []struct {
+		name          string
+		history       time.Duration
+		longWindow    string
+		wantWindow    time.Duration
+		wantEvaluable bool
+	}{
+		{"unknown history", 0, types.LongWindowSkip, policy.InactivityDuration, true},
+		{"history covers the window", time.Hour * 24 * 15, types.LongWindowSkip, policy.InactivityDuration, true},
+		{"skipped beyond history", time.Hour * 24, types.LongWindowSkip, 0, false},
+		{"capped to history", time.Hour*24 + time.Millisecond*300, types.LongWindowCap, time.Hour * 24, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			window, evaluable := queryWindow(policy, c.history, types.Config{LongWindow: c.longWindow})
+			if window != c.wantWindow || evaluable != c.wantEvaluable {
+				t.Errorf("Window wanted: %s %v got: %s %v", c.wantWindow, c.wantEvaluable, window, evaluable)
+			}
+		})
+	}
+}
+
+func Test_metricsWindow(t *testing.T) {
+	policy := Policy{InactivityDuration: time.Minute * 30}
+
+	if window := metricsWindow(policy, types.Config{}); window != policy.InactivityDuration {
+		t.Errorf("Window wanted: %s got: %s", policy.InactivityDuration, window)
+	}
+	if window := metricsWindow(policy, types.Config{MetricsWindow: time.Minute * 5}); window != time.Minute*5 {
+		t.Errorf("Window wanted: %s got: %s", time.Minute*5, window)
+	}
+	// A metrics window never lengthens a shorter inactivity duration
+	if window := metricsWindow(Policy{InactivityDuration: time.Minute}, types.Config{MetricsWindow: time.Minute * 5}); window != time.Minute {
+		t.Errorf("Window wanted: %s got: %s", time.Minute, window)
+	}
+	if window, _ := queryWindow(policy, time.Hour, types.Config{MetricsWindow: time.Minute * 5}); window != time.Minute*5 {
+		t.Errorf("Query window wanted: %s got: %s", time.Minute*5, window)
+	}
+}